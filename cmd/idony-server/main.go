@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pyromancer/idony/internal/agent"
@@ -23,7 +26,11 @@ func main() {
 	}
 
 	model := conf.GetWithDefault("MODEL", "llama3.1")
-	ollamaURL := conf.GetWithDefault("OLLAMA_URL", "http://localhost:11434")
+	providerName := llm.ProviderName(conf.Get("LLM_PROVIDER"))
+	llmURL := conf.GetWithDefault("OLLAMA_URL", "http://localhost:11434")
+	if providerName == llm.ProviderOpenAI {
+		llmURL = conf.GetWithDefault("OPENAI_URL", "http://localhost:8000/v1")
+	}
 	serverAddr := conf.GetWithDefault("SERVER_ADDR", "0.0.0.0:8080")
 	
 	apiKey := conf.Get("SERVER_API_KEY")
@@ -45,15 +52,121 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Initialize Ollama client
-	client := llm.NewOllamaClient(ollamaURL, model)
+	// Initialize LLM provider
+	client, err := llm.NewProvider(providerName, llmURL, model, conf.Get("OPENAI_API_KEY"))
+	if err != nil {
+		fmt.Printf("Error initializing LLM provider: %v\n", err)
+		os.Exit(1)
+	}
+	// Spread requests over several Ollama servers, each asked which models it has
+	var ollamaPool *llm.OllamaPool
+	if hosts := conf.Get("OLLAMA_HOSTS"); hosts != "" {
+		if ollama, ok := client.(*llm.OllamaClient); ok {
+			ollamaPool = llm.NewOllamaPool(append([]string{llmURL}, strings.Split(hosts, ",")...))
+			ollamaPool.Refresh(context.Background())
+			interval, err := time.ParseDuration(conf.Get("OLLAMA_DISCOVERY_INTERVAL"))
+			if err != nil || interval <= 0 {
+				interval = time.Minute
+			}
+			go ollamaPool.Watch(context.Background(), interval)
+			ollama.Pool = ollamaPool
+		} else {
+			fmt.Println("Warning: OLLAMA_HOSTS is ignored unless LLM_PROVIDER is ollama")
+		}
+	}
+
+	// Global model options; agents and single calls can override them
+	modelOptions, err := llm.ParseOptionSettings(conf.AllSettings(), "MODEL_")
+	if err != nil {
+		fmt.Printf("Error reading model options: %v\n", err)
+		os.Exit(1)
+	}
+	client = client.WithOptions(modelOptions)
+
+	// Models to fall back to when MODEL fails, each guarded by a circuit breaker
+	fallbacks, err := llm.ParseRoutes(conf.Get("MODEL_FALLBACKS"))
+	if err != nil {
+		fmt.Printf("Error reading MODEL_FALLBACKS: %v\n", err)
+		os.Exit(1)
+	}
+	breakerThreshold, _ := strconv.Atoi(conf.Get("LLM_BREAKER_THRESHOLD"))
+	breakerCooldown, _ := time.ParseDuration(conf.Get("LLM_BREAKER_COOLDOWN"))
+	health := llm.NewHealth(breakerThreshold, breakerCooldown)
+	client = llm.NewFallbackClient(client, fallbacks, health)
 
 	// Initialize Main Agent
 	idony := agent.NewAgent(client, store)
+	idony.SetNativeTools(conf.GetWithDefault("NATIVE_TOOLS", "true") != "false")
+	contextLimits := agent.ParseContextLimits(conf.AllSettings())
+	idony.SetContextLimits(contextLimits)
+	idony.SetStepLimits(agent.ParseStepLimits(conf.AllSettings(), "", agent.DefaultStepLimits))
+	if n, err := strconv.Atoi(conf.Get("MAX_CONCURRENT_RUNS")); err == nil && n > 0 {
+		idony.SetMaxConcurrentRuns(n)
+	}
+
+	// Long-term memories, ranked by relevance to the input
+	embedder := client
+	if embedModel := conf.Get("EMBED_MODEL"); embedModel != "" {
+		embedder = client.WithModel(embedModel)
+	}
+	memoryIndex := agent.NewMemoryIndex(store, embedder)
+	recencyWeight, _ := strconv.ParseFloat(conf.Get("MEMORY_RECENCY_WEIGHT"), 64)
+	importanceWeight, _ := strconv.ParseFloat(conf.Get("MEMORY_IMPORTANCE_WEIGHT"), 64)
+	halfLife, _ := time.ParseDuration(conf.Get("MEMORY_RECENCY_HALF_LIFE"))
+	memoryIndex.SetWeights(agent.MemoryWeights{Recency: recencyWeight, Importance: importanceWeight, HalfLife: halfLife})
+	minScore, _ := strconv.ParseFloat(conf.Get("MEMORY_MIN_SCORE"), 64)
+	memoryIndex.SetMinScore(minScore)
+	idony.SetMemoryIndex(memoryIndex)
+	// Memories saved before, or with another embedding model, are embedded in the background
+	go memoryIndex.Watch(context.Background(), time.Minute)
+
+	// Tools offered per request, ranked by relevance to the input; 0 offers all
+	toolLimit, err := strconv.Atoi(conf.GetWithDefault("TOOL_SELECTION_LIMIT", strconv.Itoa(agent.DefaultToolLimit)))
+	if err != nil || toolLimit > 0 {
+		coreTools := agent.DefaultCoreTools
+		if v := conf.Get("CORE_TOOLS"); v != "" {
+			coreTools = strings.Split(v, ",")
+		}
+		idony.SetToolSelector(agent.NewToolSelector(embedder, toolLimit, coreTools))
+	}
+
+	// Human-in-the-loop approval for dangerous tool calls
+	policy, err := agent.ParseApprovalPolicy(conf.GetWithDefault("APPROVAL_REQUIRED", agent.DefaultApprovalPolicy))
+	if err != nil {
+		fmt.Printf("Error in APPROVAL_REQUIRED: %v\n", err)
+		os.Exit(1)
+	}
+	approvalTimeout, _ := time.ParseDuration(conf.Get("APPROVAL_TIMEOUT"))
+	approvals := agent.NewApprovalManager(store, policy, approvalTimeout)
+	idony.SetApprovals(approvals)
 
 	// Initialize Managers
 	subManager := agent.NewSubAgentManager(client, store, idony.GetTools())
+	subManager.SetContextLimits(contextLimits)
+	subManager.SetStepLimits(agent.ParseStepLimits(conf.AllSettings(), "SUBAGENT_", agent.DefaultSubAgentStepLimits))
+	subManager.SetApprovals(approvals)
+	subManager.SetRuns(idony.Runs())
+	if n, err := strconv.Atoi(conf.Get("MAX_CONCURRENT_SUBAGENTS")); err == nil && n > 0 {
+		subManager.SetMaxConcurrent(n)
+	}
+	subAgentTimeout, _ := time.ParseDuration(conf.Get("SUBAGENT_TIMEOUT"))
+	subManager.SetTimeout(subAgentTimeout)
+	notifier := agent.NewNotifier(idony, store)
+	notifier.SetFollowUp(conf.Get("NOTIFY_FOLLOW_UP") == "true")
+	subManager.SetNotifier(notifier)
 	councilManager := agent.NewCouncilManager(client, store, subManager)
+	councilManager.SetStepLimits(agent.ParseStepLimits(conf.AllSettings(), "COUNCIL_", agent.DefaultCouncilStepLimits))
+
+	// Sub-agent definitions and councils kept as files, loaded now and on every change
+	agentDir := agent.NewAgentDir(conf.GetWithDefault("AGENTS_DIR", "agents"), store, councilManager)
+	changes, err := agentDir.Sync()
+	for _, c := range changes {
+		fmt.Printf("[Agents]: %s\n", c)
+	}
+	if err != nil {
+		fmt.Printf("Warning: Could not load agents from %s: %v\n", agentDir.Dir(), err)
+	}
+	go agentDir.Watch(context.Background())
 
 	// Initialize Scheduler and start it
 	scheduler := agent.NewScheduler(idony, store, subManager, councilManager)
@@ -63,11 +176,14 @@ func main() {
 	idony.RegisterTool(&tools.TimeTool{})
 	idony.RegisterTool(&tools.GeminiCoder{})
 	idony.RegisterTool(tools.NewScheduleTool(store))
-	idony.RegisterTool(tools.NewConfigUpdateTool(conf, "config.txt", idony))
-	idony.RegisterTool(tools.NewReloadConfigTool(conf, "config.txt", idony))
+	idony.RegisterTool(tools.NewConfigUpdateTool(conf, "config.txt", tools.Refreshables{idony, subManager}))
+	idony.RegisterTool(tools.NewReloadConfigTool(conf, "config.txt", tools.Refreshables{idony, subManager}))
 	idony.RegisterTool(tools.NewPersonalityTool(store))
-	idony.RegisterTool(tools.NewSubAgentTool(subManager, idony))
+	idony.RegisterTool(tools.NewSubAgentTool(subManager))
 	idony.RegisterTool(tools.NewCouncilTool(councilManager))
+	idony.RegisterTool(tools.NewMeshTool(agent.NewMeshManager(idony, subManager, store)))
+	swarmManager := agent.NewSwarmManager(client, store, subManager)
+	idony.RegisterTool(tools.NewSwarmTool(swarmManager))
 	idony.RegisterTool(&tools.ListFilesTool{})
 	idony.RegisterTool(&tools.ReadFileTool{})
 	idony.RegisterTool(&tools.WriteFileTool{})
@@ -90,13 +206,18 @@ func main() {
 	idony.RegisterTool(tools.NewModelListTool(client))
 	idony.RegisterTool(tools.NewAgentListTool(subManager))
 	idony.RegisterTool(&tools.OllamaLibraryTool{})
-	idony.RegisterTool(tools.NewMemoryTool(store))
-	idony.RegisterTool(tools.NewRecallTool(store))
+	idony.RegisterTool(tools.NewMemoryTool(memoryIndex))
+	idony.RegisterTool(tools.NewRecallTool(memoryIndex))
 	idony.RegisterTool(tools.NewGraphAddTool(store))
 	idony.RegisterTool(tools.NewGraphQueryTool(store))
 	idony.RegisterTool(tools.NewCompactTool(store, client))
 	idony.RegisterTool(tools.NewOptimizeMemoryTool(store, client))
-	idony.RegisterTool(tools.NewMessagingTool(store))
+	inbox := agent.NewInbox(store, subManager)
+	if n, err := strconv.Atoi(conf.Get("AGENT_MESSAGE_MAX_DEPTH")); err == nil {
+		inbox.SetMaxDepth(n)
+	}
+	go inbox.Start(context.Background())
+	idony.RegisterTool(tools.NewMessagingTool(inbox))
 	idony.RegisterTool(tools.NewInboxTool(store))
 	idony.RegisterTool(tools.NewWebhookTool(store))
 	
@@ -128,12 +249,19 @@ func main() {
 		if err != nil {
 			fmt.Printf("Failed to initialize Telegram: %v\n", err)
 		} else {
+			tgBridge.SetApprovals(approvals)
+			tgBridge.SetNotifier(notifier)
 			go tgBridge.Start()
 		}
 	}
 
 	// Start Server
 	srv := server.NewServer(idony, subManager, councilManager, store, apiKey)
+	srv.Approvals = approvals
+	srv.SwarmManager = swarmManager
+	srv.AgentDir = agentDir
+	srv.Health = health
+	srv.OllamaPool = ollamaPool
 	
 	certFile := conf.Get("TLS_CERT_FILE")
 	keyFile := conf.Get("TLS_KEY_FILE")
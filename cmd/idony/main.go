@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -42,6 +44,41 @@ func (c *Client) Get(path string, target interface{}) error {
 	return json.NewDecoder(resp.Body).Decode(target)
 }
 
+// streamEvent mirrors agent.Event as sent by the server's /chat/stream endpoint.
+type streamEvent struct {
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	Tool    string `json:"tool"`
+	Input   string `json:"input"`
+	ID      string `json:"id"`
+}
+
+// Stream posts body to path and calls onEvent for every Server-Sent Event received.
+func (c *Client) Stream(path string, body interface{}, onEvent func(streamEvent)) error {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest("POST", c.BaseURL+path, bytes.NewBuffer(data))
+	if err != nil { return err }
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	if c.APIKey != "" { req.Header.Set("X-API-Key", c.APIKey) }
+	client := &http.Client{} // No timeout: generations can take minutes
+	resp, err := client.Do(req)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return fmt.Errorf("status %d", resp.StatusCode) }
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") { continue }
+		var ev streamEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil { continue }
+		onEvent(ev)
+	}
+	return scanner.Err()
+}
+
 func main() {
 	conf, err := config.LoadConfig("config.txt")
 	if err != nil {
@@ -122,6 +159,44 @@ func main() {
 		if visibility["planner"] { mainContent.AddItem(plannerTree, 0, 1, false) }
 	}
 
+	// Tool calls waiting for approval are shown one at a time in a modal
+	pages := tview.NewPages().AddPage("main", rootFlex, true, true)
+	shownApprovals := map[string]bool{}
+	var approvalQueue []db.Approval
+	var showNextApproval func()
+	showNextApproval = func() {
+		if len(approvalQueue) == 0 || pages.HasPage("approval") { return }
+		a := approvalQueue[0]
+		approvalQueue = approvalQueue[1:]
+		input := a.Input
+		if len(input) > 300 { input = input[:297] + "..." }
+		modal := tview.NewModal().
+			SetText(fmt.Sprintf("Approval needed (session %s)\n\n%s %s", a.SessionID, a.Tool, input)).
+			AddButtons([]string{"Approve", "Deny"}).
+			SetDoneFunc(func(idx int, label string) {
+				go func() {
+					resp, err := client.Post("/approvals/"+a.ID, map[string]interface{}{"approve": label == "Approve", "by": "tui"})
+					if err == nil { resp.Body.Close() }
+				}()
+				fmt.Fprintf(outputView, "[yellow]%s: %s %s[white]\n", label, a.Tool, a.ID)
+				pages.RemovePage("approval")
+				app.SetFocus(inputField)
+				showNextApproval()
+			})
+		pages.AddPage("approval", modal, true, true)
+		app.SetFocus(modal)
+	}
+	// queueApproval must be called from the UI goroutine
+	queueApproval := func(a db.Approval) {
+		if shownApprovals[a.ID] { return }
+		shownApprovals[a.ID] = true
+		approvalQueue = append(approvalQueue, a)
+		showNextApproval()
+	}
+
+	// IDs of this client's runs in progress, only touched from the UI goroutine
+	activeRuns := make(map[string]bool)
+
 	focusList := []tview.Primitive{inputField, outputView, historyView, agentsView, plannerTree, statusMenu}
 	focusIdx := 0
 
@@ -139,6 +214,20 @@ func main() {
 			return nil
 		}
 		if event.Key() == tcell.KeyCtrlC { app.Stop(); return nil }
+		if event.Key() == tcell.KeyCtrlX {
+			if len(activeRuns) == 0 {
+				fmt.Fprintf(outputView, "[gray]Nothing is running.[white]\n")
+				return nil
+			}
+			for id := range activeRuns {
+				go func(id string) {
+					resp, err := client.Post("/runs/"+id+"/cancel", nil)
+					if err == nil { resp.Body.Close() }
+				}(id)
+			}
+			fmt.Fprintf(outputView, "[yellow]Cancelling...[white]\n")
+			return nil
+		}
 		return event
 	})
 
@@ -158,9 +247,57 @@ func main() {
 		return event
 	})
 
+	// The planner tree shows every project with its task tree and their live status
+	collapsedProjects := map[string]bool{} // Only touched from the UI goroutine
+	taskIcons := map[string]string{"pending": "○", "in_progress": "◐", "completed": "●", "failed": "✗", "cancelled": "✗", "skipped": "–"}
+	refreshPlanner := func() {
+		var projects []db.Project
+		if client.Get("/projects", &projects) != nil { return }
+		tasks := make(map[string][]db.Task)
+		for _, p := range projects {
+			var pt []db.Task
+			client.Get("/tasks?project_id="+url.QueryEscape(p.ID), &pt)
+			tasks[p.ID] = pt
+		}
+		app.QueueUpdateDraw(func() {
+			plannerRoot.ClearChildren()
+			for _, p := range projects {
+				done := 0
+				for _, t := range tasks[p.ID] { if t.Status == "completed" { done++ } }
+				pn := tview.NewTreeNode(fmt.Sprintf("%s [%s %d/%d]", p.Name, p.Status, done, len(tasks[p.ID]))).SetColor(tcell.ColorYellow).SetReference(p.ID).SetExpanded(!collapsedProjects[p.ID])
+				nodes := map[string]*tview.TreeNode{}
+				for _, t := range tasks[p.ID] {
+					icon := taskIcons[t.Status]
+					if icon == "" { icon = "○" }
+					label := fmt.Sprintf("%s %s", icon, t.Title)
+					if t.AssignedAgent != "" { label += " (" + t.AssignedAgent + ")" }
+					nodes[t.ID] = tview.NewTreeNode(label).SetReference(t)
+				}
+				for _, t := range tasks[p.ID] {
+					if parent, ok := nodes[t.ParentID]; ok { parent.AddChild(nodes[t.ID]) } else { pn.AddChild(nodes[t.ID]) }
+				}
+				plannerRoot.AddChild(pn)
+			}
+		})
+	}
+
+	plannerTree.SetSelectedFunc(func(node *tview.TreeNode) {
+		t, ok := node.GetReference().(db.Task)
+		if !ok {
+			node.SetExpanded(!node.IsExpanded())
+			if id, isProject := node.GetReference().(string); isProject { collapsedProjects[id] = !node.IsExpanded() }
+			return
+		}
+		fmt.Fprintf(outputView, "[yellow]Task %s (%s):[white] %s\n", t.Title, t.Status, tview.Escape(t.Description))
+		if t.Result != "" { fmt.Fprintf(outputView, "%s\n", tview.Escape(t.Result)) }
+		fmt.Fprintln(outputView)
+		outputView.ScrollToEnd()
+	})
+
 	go func() {
 		spinner := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
 		i := 0
+		lastNotification := -1 // The first poll only skips older notifications
 		for {
 			var activities []db.Activity
 			if err := client.Get("/history", &activities); err == nil {
@@ -169,6 +306,8 @@ func main() {
 				app.QueueUpdateDraw(func() { historyView.SetText(sb.String()) })
 			}
 			
+			if i%10 == 0 { go refreshPlanner() }
+
 			var agents []db.SubAgentDefinition
 			client.Get("/agents", &agents)
 			var asb strings.Builder
@@ -181,6 +320,32 @@ func main() {
 			}
 			err := client.Get("/status", &statusData)
 
+			// Results of background jobs started from any TUI session
+			var notes []db.Notification
+			if client.Get(fmt.Sprintf("/notifications?channel=tui&after=%d", max(lastNotification, 0)), &notes) == nil {
+				show := lastNotification >= 0
+				if len(notes) > 0 { lastNotification = notes[len(notes)-1].ID } else if !show { lastNotification = 0 }
+				if show && len(notes) > 0 {
+					app.QueueUpdateDraw(func() {
+						for _, n := range notes {
+							if n.Kind == "reply" {
+								fmt.Fprintf(outputView, "\n[yellow]Idony (%s):[white] %s\n\n", n.SessionID, tview.Escape(n.Summary))
+							} else {
+								fmt.Fprintf(outputView, "[blue]The %s %s is %s (%s):[white]\n%s\n\n", n.Kind, n.JobID, n.Status, n.SessionID, tview.Escape(n.Summary))
+							}
+						}
+						outputView.ScrollToEnd()
+					})
+				}
+			}
+
+			var pending []db.Approval
+			if client.Get("/approvals", &pending) == nil && len(pending) > 0 {
+				app.QueueUpdateDraw(func() {
+					for _, a := range pending { queueApproval(a) }
+				})
+			}
+
 			app.QueueUpdateDraw(func() {
 				var sb strings.Builder
 				if err != nil {
@@ -207,15 +372,133 @@ func main() {
 		}
 	}()
 
+	// The TUI talks in its own sessions, switched with "/session <name>"
+	sessionID := "tui:main"
+
+	showSessions := func() {
+		var sessions []db.Session
+		err := client.Get("/sessions?channel=tui", &sessions)
+		app.QueueUpdateDraw(func() {
+			if err != nil { fmt.Fprintf(outputView, "[red]Error listing sessions: %v[white]\n", err); return }
+			fmt.Fprintf(outputView, "[yellow]Sessions:[white]\n")
+			for _, ss := range sessions {
+				marker := "  "
+				if ss.ID == sessionID { marker = "* " }
+				fmt.Fprintf(outputView, "%s%s [gray](%s)[white]\n", marker, strings.TrimPrefix(ss.ID, "tui:"), ss.UpdatedAt.Format("2006-01-02 15:04"))
+			}
+			fmt.Fprintln(outputView)
+		})
+	}
+
+	switchSession := func(id string) {
+		var msgs []db.Message
+		err := client.Get("/sessions/"+url.PathEscape(id)+"/messages", &msgs)
+		app.QueueUpdateDraw(func() {
+			outputView.Clear()
+			fmt.Fprintf(outputView, "[yellow]Session: %s[white]\n\n", strings.TrimPrefix(id, "tui:"))
+			if err != nil { fmt.Fprintf(outputView, "[red]Error loading session: %v[white]\n", err); return }
+			for _, m := range msgs {
+				switch m.Role {
+				case "user": fmt.Fprintf(outputView, "[green]You:[white] %s\n", m.Content)
+				case "assistant": fmt.Fprintf(outputView, "\n[yellow]Idony:[white] %s\n\n", m.Content)
+				}
+			}
+			outputView.ScrollToEnd()
+		})
+	}
+
+	showRuns := func(session string) {
+		var runs []db.RunSummary
+		err := client.Get("/runs/recent?session_id="+url.QueryEscape(session), &runs)
+		app.QueueUpdateDraw(func() {
+			if err != nil { fmt.Fprintf(outputView, "[red]Error listing runs: %v[white]\n", err); return }
+			fmt.Fprintf(outputView, "[yellow]Recent runs (/trace <id> for details):[white]\n")
+			for _, run := range runs {
+				input := run.Input
+				if len(input) > 60 { input = input[:57] + "..." }
+				fmt.Fprintf(outputView, "  %s [gray]%s %s, %d steps[white] %s\n", run.RunID, run.StartedAt.Format("01-02 15:04"), run.Outcome, run.Steps, input)
+			}
+			fmt.Fprintln(outputView)
+			outputView.ScrollToEnd()
+		})
+	}
+
+	showTrace := func(id string) {
+		var steps []db.RunStep
+		err := client.Get("/runs/"+url.PathEscape(id)+"/trace", &steps)
+		app.QueueUpdateDraw(func() {
+			if err != nil { fmt.Fprintf(outputView, "[red]Error loading trace: %v[white]\n", err); return }
+			fmt.Fprintf(outputView, "[yellow]Trace of run %s:[white]\n", id)
+			for _, st := range steps {
+				label := st.Kind
+				if st.Tool != "" { label += " " + st.Tool }
+				if st.Model != "" && st.Kind == "llm" { label += " (" + st.Model + ")" }
+				fmt.Fprintf(outputView, "[blue]#%d %s[gray] %s, %s[white]\n", st.Step, label, st.StartedAt.Format("15:04:05"), st.Duration.Round(time.Millisecond))
+				if st.Input != "" { fmt.Fprintf(outputView, "  [gray]in:[white] %s\n", tview.Escape(st.Input)) }
+				if st.Output != "" { fmt.Fprintf(outputView, "  [gray]out:[white] %s\n", tview.Escape(st.Output)) }
+				if st.Error != "" { fmt.Fprintf(outputView, "  [red]error: %s[white]\n", tview.Escape(st.Error)) }
+			}
+			fmt.Fprintln(outputView)
+			outputView.ScrollToEnd()
+		})
+	}
+
+	showThreads := func() {
+		var threads []db.AgentThread
+		err := client.Get("/agent-messages/threads", &threads)
+		app.QueueUpdateDraw(func() {
+			if err != nil { fmt.Fprintf(outputView, "[red]Error listing agent conversations: %v[white]\n", err); return }
+			fmt.Fprintf(outputView, "[yellow]Agent conversations (/inbox <id> to read one):[white]\n")
+			for _, t := range threads {
+				fmt.Fprintf(outputView, "  #%d [gray]%s %s, %d messages[white] %s\n", t.ThreadID, t.LastAt.Format("01-02 15:04"), t.Participants, t.Messages, tview.Escape(t.Subject))
+			}
+			fmt.Fprintln(outputView)
+			outputView.ScrollToEnd()
+		})
+	}
+
+	showThread := func(id string) {
+		var msgs []db.AgentMessage
+		err := client.Get("/agent-messages/threads/"+url.PathEscape(strings.TrimPrefix(id, "#")), &msgs)
+		app.QueueUpdateDraw(func() {
+			if err != nil { fmt.Fprintf(outputView, "[red]Error loading conversation: %v[white]\n", err); return }
+			fmt.Fprintf(outputView, "[yellow]Agent conversation #%s:[white]\n", strings.TrimPrefix(id, "#"))
+			for _, m := range msgs {
+				fmt.Fprintf(outputView, "[blue]#%d %s -> %s[gray] %s[white]\n  %s\n", m.ID, m.From, m.To, m.CreatedAt.Format("01-02 15:04"), tview.Escape(m.Content))
+			}
+			fmt.Fprintln(outputView)
+			outputView.ScrollToEnd()
+		})
+	}
+
 	inputField.SetDoneFunc(func(key tcell.Key) {
 		if key != tcell.KeyEnter { return }
 		text := strings.TrimSpace(inputField.GetText())
 		inputField.SetText("")
 		if text == "" { return }
+		if text == "/session" || strings.HasPrefix(text, "/session ") {
+			name := strings.TrimSpace(strings.TrimPrefix(text, "/session"))
+			if name == "" { go showSessions(); return }
+			sessionID = "tui:" + name
+			go switchSession(sessionID)
+			return
+		}
+		if text == "/trace" || strings.HasPrefix(text, "/trace ") {
+			id := strings.TrimSpace(strings.TrimPrefix(text, "/trace"))
+			if id == "" { go showRuns(sessionID); return }
+			go showTrace(id)
+			return
+		}
+		if text == "/inbox" || strings.HasPrefix(text, "/inbox ") {
+			id := strings.TrimSpace(strings.TrimPrefix(text, "/inbox"))
+			if id == "" { go showThreads(); return }
+			go showThread(id)
+			return
+		}
 		fmt.Fprintf(outputView, "[green]You:[white] %s\n", text)
+		session := sessionID
 		go func() {
-			var resp *http.Response
-			var err error
+			var body interface{} = map[string]string{"text": text, "session_id": session}
 
 			if strings.HasPrefix(text, "/image ") {
 				parts := strings.SplitN(strings.TrimPrefix(text, "/image "), " ", 2)
@@ -228,32 +511,71 @@ func main() {
 					app.QueueUpdateDraw(func() { fmt.Fprintf(outputView, "[red]Error loading image: %v[white]\n", err) })
 					return
 				}
-				resp, err = client.Post("/chat", map[string]interface{}{
-					"text":   prompt,
-					"images": []string{b64},
-				})
-			} else {
-				resp, err = client.Post("/chat", map[string]string{"text": text})
+				body = map[string]interface{}{
+					"text":       prompt,
+					"images":     []string{b64},
+					"session_id": session,
+				}
 			}
 
-			if err == nil {
-				defer resp.Body.Close()
-				if resp.StatusCode != http.StatusOK {
-					app.QueueUpdateDraw(func() { fmt.Fprintf(outputView, "[red]Server Error: Status %d[white]\n", resp.StatusCode) })
-					return
-				}
-				var data map[string]string
-				json.NewDecoder(resp.Body).Decode(&data)
-				app.QueueUpdateDraw(func() { fmt.Fprintf(outputView, "\n[yellow]Idony:[white] %s\n\n", data["response"]) })
-			} else {
+			streaming := false
+			beforeStream := "" // Output before the streamed answer, restored to redraw it
+			runID := ""
+			err := client.Stream("/chat/stream", body, func(ev streamEvent) {
+				app.QueueUpdateDraw(func() {
+					switch ev.Type {
+					case "started":
+						activeRuns[ev.ID] = true
+						runID = ev.ID
+					case "thought":
+						fmt.Fprintf(outputView, "[gray]Thought: %s[white]\n", ev.Content)
+					case "tool_call":
+						fmt.Fprintf(outputView, "[blue]> %s %s[white]\n", ev.Tool, ev.Input)
+					case "observation":
+						obs := ev.Content
+						if len(obs) > 200 { obs = obs[:197] + "..." }
+						fmt.Fprintf(outputView, "[gray]Observation: %s[white]\n", obs)
+					case "token":
+						if !streaming {
+							beforeStream = outputView.GetText(false)
+							fmt.Fprintf(outputView, "\n[yellow]Idony:[white] ")
+							streaming = true
+						}
+						fmt.Fprint(outputView, tview.Escape(ev.Content))
+					case "reset":
+						if streaming { outputView.SetText(beforeStream) }
+						streaming = false
+					case "final":
+						// Redraw from the final answer, which may differ from what was streamed
+						if streaming { outputView.SetText(beforeStream) }
+						fmt.Fprintf(outputView, "\n[yellow]Idony:[white] %s\n\n", tview.Escape(ev.Content))
+						streaming = false
+					case "aborted", "cancelled":
+						if streaming { fmt.Fprint(outputView, "\n") }
+						streaming = false
+						fmt.Fprintf(outputView, "[yellow]%s[white]\n\n", ev.Content)
+					case "context":
+						fmt.Fprintf(outputView, "[gray]Context trimmed: %s[white]\n", ev.Content)
+					case "approval":
+						fmt.Fprintf(outputView, "[yellow]Waiting for approval: %s %s[white]\n", ev.Tool, ev.Input)
+						queueApproval(db.Approval{ID: ev.ID, SessionID: session, Tool: ev.Tool, Input: ev.Input})
+					case "error":
+						fmt.Fprintf(outputView, "[red]Agent Error: %s[white]\n", ev.Content)
+					}
+					outputView.ScrollToEnd()
+				})
+			})
+			app.QueueUpdateDraw(func() { delete(activeRuns, runID) })
+			if err != nil {
 				app.QueueUpdateDraw(func() { fmt.Fprintf(outputView, "[red]Connection Error: %v[white]\n", err) })
 			}
 		}()
 	})
 
 	updateLayout()
-	fmt.Fprintf(outputView, "[yellow]Idony TUI Client v1.5.1\n[white]Connected to: %s\n\n", serverAddr)
-	if err := app.SetRoot(rootFlex, true).SetFocus(inputField).Run(); err != nil {
+	fmt.Fprintf(outputView, "[yellow]Idony TUI Client v1.5.1\n[white]Connected to: %s\n", serverAddr)
+	fmt.Fprintf(outputView, "Session: main (/session to list, /session <name> to switch)\n\n")
+	if err := app.SetRoot(pages, true).SetFocus(inputField).Run(); err != nil {
 		fmt.Printf("Error: %v\n", err)
 	}
 }
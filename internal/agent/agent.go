@@ -3,8 +3,11 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pyromancer/idony/internal/db"
 	"github.com/pyromancer/idony/internal/llm"
@@ -12,63 +15,112 @@ import (
 )
 
 // ThoughtProcess represents the structured reasoning format expected from the LLM.
+// A step calls either one tool through Tool and Input, or several independent
+// tools at once through Tools.
 type ThoughtProcess struct {
-	Thought string          `json:"thought"`
-	Tool    string          `json:"tool,omitempty"`
-	Input   json.RawMessage `json:"input,omitempty"`
-	Final   string          `json:"final,omitempty"`
+	Thought string            `json:"thought"`
+	Tool    string            `json:"tool,omitempty"`
+	Input   json.RawMessage   `json:"input,omitempty"`
+	Tools   []ThoughtToolCall `json:"tools,omitempty"`
+	Final   string            `json:"final,omitempty"`
 }
 
-// Agent is the core logic engine responsible for the loop.
+// ThoughtToolCall is one entry of ThoughtProcess.Tools.
+type ThoughtToolCall struct {
+	Tool  string          `json:"tool"`
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+// thoughtSchema constrains text-protocol replies to the ThoughtProcess shape.
+var thoughtSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"thought": map[string]interface{}{"type": "string"},
+		"tool":    map[string]interface{}{"type": "string"},
+		"input":   map[string]interface{}{"type": []string{"string", "object"}},
+		"tools": map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"tool":  map[string]interface{}{"type": "string"},
+					"input": map[string]interface{}{"type": []string{"string", "object"}},
+				},
+				"required": []string{"tool"},
+			},
+		},
+		"final": map[string]interface{}{"type": "string"},
+	},
+	"required": []string{"thought"},
+}
+
+// Agent is the core logic engine responsible for the loop. It is safe for
+// concurrent use: every turn runs with its own execution context (see run),
+// ordered by the run queue.
 type Agent struct {
-	client         *llm.OllamaClient
-	tools          map[string]base.Tool
-	history        []llm.Message
-	store          *db.Store
-	isThinking     bool
-	personality    string
-	model          string
-	lastUserImages []string
+	client       llm.Provider
+	tools        map[string]base.Tool
+	sessions     map[string]*session // Conversation state per session ID
+	sessionsMu   sync.Mutex
+	queue        *runQueue
+	store        *db.Store
+	history      *db.Store // Optional; where session histories are persisted, usually the store
+	personality  string
+	name         string     // Sub-agent definition the agent runs as; "" for the main agent
+	mu           sync.Mutex // Guards model, baseURL and noToolModels
+	model        string
+	baseURL      string           // Server set with SetBaseURL; "" keeps the client's
+	options      llm.Options      // Layered over the client's options, e.g. from a sub-agent definition
+	fallbacks    []llm.Route      // Replace the client's fallback chain if set, e.g. from a sub-agent definition
+	nativeTools  bool             // Offer tools through the provider's tool-calling API
+	noToolModels map[string]bool  // Models that rejected native tool calling
+	limits       ContextLimits    // Context window budget per model
+	stepLimits   StepLimits       // Bounds on the number of steps and repeated tool calls per run
+	approvals    *ApprovalManager // Optional; parks tool calls that need a human's approval
+	runs         *RunRegistry     // Cancellable handles of the runs in progress
+	traces       *db.Store        // Optional; records the steps of every run, even without persisted history
+	memories     *MemoryIndex     // Optional; picks the memories relevant to the input
+	selector     *ToolSelector    // Optional; offers only the tools relevant to the input
 }
 
 // NewAgent initializes a new Agent with a client and a persistence store.
-func NewAgent(client *llm.OllamaClient, store *db.Store) *Agent {
+func NewAgent(client llm.Provider, store *db.Store) *Agent {
 	a := &Agent{
-		client:      client,
-		tools:       make(map[string]base.Tool),
-		store:       store,
-		isThinking:  false,
-		personality: "",
-		model:       "",
+		client:       client,
+		tools:        make(map[string]base.Tool),
+		sessions:     make(map[string]*session),
+		queue:        newRunQueue(DefaultMaxConcurrentRuns),
+		runs:         NewRunRegistry(),
+		store:        store,
+		history:      store,
+		traces:       store,
+		personality:  "",
+		model:        "",
+		nativeTools:  true,
+		noToolModels: make(map[string]bool),
+		limits:       ContextLimits{Reserve: DefaultContextReserve},
+		stepLimits:   DefaultStepLimits,
 	}
-	a.loadHistory()
+	// Restore the default session right away; others are restored on first use
+	a.session(DefaultSession)
 	return a
 }
 
+// IsThinking reports whether any run is in progress.
 func (a *Agent) IsThinking() bool {
-	return a.isThinking
+	running, _ := a.queue.stats()
+	return running > 0
 }
 
-func (a *Agent) GetLastUserImages() []string {
-	return a.lastUserImages
+// QueueStats returns the number of running runs and of runs waiting their turn.
+func (a *Agent) QueueStats() (running, waiting int) {
+	return a.queue.stats()
 }
 
-func (a *Agent) SetLastUserImages(images []string) {
-	a.lastUserImages = images
-}
-
-func (a *Agent) loadHistory() {
-	if a.store == nil {
-		return
-	}
-	msgs, err := a.store.LoadLastMessages(20) // Load last 20 messages for context
-	if err != nil {
-		fmt.Printf("Warning: Could not load history from DB: %v\n", err)
-		return
-	}
-	for _, m := range msgs {
-		a.history = append(a.history, llm.Message{Role: m.Role, Content: m.Content})
-	}
+// SetMaxConcurrentRuns sets how many sessions may run at the same time. Turns
+// of the same session always run one after another. Call it before the first run.
+func (a *Agent) SetMaxConcurrentRuns(n int) {
+	a.queue = newRunQueue(n)
 }
 
 // RegisterTool adds a tool to the agent's repertoire.
@@ -81,64 +133,224 @@ func (a *Agent) GetTools() map[string]base.Tool {
 	return a.tools
 }
 
-// SetModel updates the underlying model.
+// SetModel updates the model of new runs. Runs already in progress keep theirs.
+// The shared client is never changed; every run uses a copy bound to its model.
 func (a *Agent) SetModel(model string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	a.model = model
-	if a.client != nil {
-		a.client.SetModel(model)
-	}
 }
 
-// SetBaseURL updates the underlying LLM client's base URL.
-func (a *Agent) SetBaseURL(url string) {
-	if a.client != nil {
-		a.client.BaseURL = url
+// Model returns the model new runs use: the agent's own, or the provider's.
+func (a *Agent) Model() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.model != "" {
+		return a.model
 	}
+	return a.client.GetModel()
 }
 
-// Run processes a user input through the agentic loop.
-func (a *Agent) Run(ctx context.Context, userInput string) (string, error) {
-	a.isThinking = true
-	a.lastUserImages = nil
-	defer func() { a.isThinking = false }()
+// SetNativeTools toggles native tool calling. When disabled, or when the model
+// does not support it, the agent falls back to the <json> text protocol.
+func (a *Agent) SetNativeTools(enabled bool) {
+	a.nativeTools = enabled
+}
+
+// SetStepLimits bounds the number of steps and repeated tool calls per run.
+func (a *Agent) SetStepLimits(limits StepLimits) {
+	a.stepLimits = limits
+}
+
+// SetApprovals makes tool calls matching the manager's policy wait for approval.
+func (a *Agent) SetApprovals(m *ApprovalManager) {
+	a.approvals = m
+}
+
+// SetMemoryIndex makes runs include the memories most relevant to the user's
+// input instead of the newest ones.
+func (a *Agent) SetMemoryIndex(x *MemoryIndex) {
+	a.memories = x
+}
+
+// SetToolSelector makes runs offer the core tools and those relevant to the
+// user's input instead of every registered tool.
+func (a *Agent) SetToolSelector(s *ToolSelector) {
+	a.selector = s
+}
+
+// Runs returns the registry of the agent's runs in progress. Share it with the
+// SubAgentManager so sub-agents and councils can be cancelled the same way.
+func (a *Agent) Runs() *RunRegistry {
+	return a.runs
+}
+
+// SetBaseURL points new runs at a different server. Like SetModel it leaves
+// the shared client alone.
+func (a *Agent) SetBaseURL(url string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.baseURL = url
+}
 
-	a.history = append(a.history, llm.Message{Role: "user", Content: userInput})
-	if a.store != nil {
-		a.store.SaveMessage("user", userInput)
+// runClient returns a copy of the agent's client bound to model and its server.
+func (a *Agent) runClient(model string) llm.Provider {
+	a.mu.Lock()
+	baseURL := a.baseURL
+	a.mu.Unlock()
+	client := a.client.WithModel(model)
+	if baseURL != "" {
+		client.SetBaseURL(baseURL)
 	}
+	return client
+}
 
-	return a.internalLoop(ctx)
+// Run processes a user input through the agentic loop in the default session.
+func (a *Agent) Run(ctx context.Context, userInput string) (string, error) {
+	return a.RunStream(ctx, DefaultSession, userInput, nil, nil)
 }
 
-// RunVision processes a user input with one or more base64 images.
+// RunVision processes a user input with one or more base64 images in the default session.
 func (a *Agent) RunVision(ctx context.Context, userInput string, b64Images []string) (string, error) {
-	a.isThinking = true
-	a.lastUserImages = b64Images
-	defer func() { a.isThinking = false }()
+	return a.RunStream(ctx, DefaultSession, userInput, b64Images, nil)
+}
 
-	a.history = append(a.history, llm.Message{Role: "user", Content: userInput, Images: b64Images})
-	if a.store != nil {
-		a.store.SaveMessage("user", "[Image Attached] "+userInput)
+// RunStream processes a user input (and optional images) through the agentic loop
+// in the given session, reporting thoughts, tool calls, observations and
+// final-answer tokens to emit as they happen. emit may be nil.
+func (a *Agent) RunStream(ctx context.Context, sessionID, userInput string, b64Images []string, emit EventHandler) (string, error) {
+	s := a.session(sessionID)
+
+	// Register the run before queueing so it can be cancelled while it waits
+	ctx, runID := takeRunID(ctx)
+	ctx, callOptions := llm.TakeCallOptions(ctx)
+	ctx, done := a.runs.start(ctx, runID, "chat", s.id, userInput)
+	defer done()
+	if emit != nil {
+		emit(Event{Type: EventStarted, ID: runID})
 	}
 
-	return a.internalLoop(ctx)
-}
+	release, err := a.queue.acquire(ctx, s.id)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			err = ErrRunCancelled
+		}
+		if emit != nil {
+			emit(Event{Type: EventError, Content: err.Error()})
+		}
+		return "", err
+	}
+	defer release()
+
+	r := a.newRun(runID, s, b64Images, callOptions)
+	defer r.commit()
+	r.record(db.RunStep{Kind: "input", Model: r.model, Input: userInput, StartedAt: r.started})
+	ctx = base.WithImages(base.WithSession(ctx, s.id), b64Images)
+	if a.name != "" {
+		ctx = base.WithAgent(ctx, a.name)
+	}
 
-func (a *Agent) internalLoop(ctx context.Context) (string, error) {
-	// If a specific model is set for this agent instance, ensure the client uses it
-	originalModel := a.client.Model
-	if a.model != "" {
-		a.client.SetModel(a.model)
+	r.turnStart = len(r.history)
+	if len(b64Images) > 0 {
+		r.history = append(r.history, llm.Message{Role: "user", Content: userInput, Images: b64Images})
+		a.saveMessage(s, "user", "[Image Attached] "+userInput)
+	} else {
+		r.history = append(r.history, llm.Message{Role: "user", Content: userInput})
+		a.saveMessage(s, "user", userInput)
+	}
+
+	result, err := a.internalLoop(ctx, r, emit)
+	var abortErr *AbortError
+	if errors.Is(ctx.Err(), context.Canceled) && !errors.As(err, &abortErr) {
+		result, err = a.cancelled(r)
+	}
+	r.recordOutcome(result, err)
+	if emit != nil {
+		if errors.As(err, &abortErr) {
+			emit(Event{Type: EventAborted, Content: result})
+		} else if errors.Is(err, ErrRunCancelled) {
+			emit(Event{Type: EventCancelled, Content: result})
+		} else if err != nil {
+			emit(Event{Type: EventError, Content: err.Error()})
+		} else {
+			emit(Event{Type: EventFinal, Content: result})
+		}
 	}
-	defer func() { a.client.SetModel(originalModel) }()
+	return result, err
+}
 
+func (a *Agent) internalLoop(ctx context.Context, r *run, emit EventHandler) (string, error) {
+	guard := newRunGuard(a.stepLimits)
 	for {
-		// Construct system prompt with tool descriptions
-		systemPrompt := a.buildSystemPrompt()
-		
-		messages := append([]llm.Message{{Role: "system", Content: systemPrompt}}, a.history...)
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		r.step++
+		if err := guard.step(); err != nil {
+			return a.abort(r, err)
+		}
+
+		model := r.model
+		native := a.nativeTools && a.supportsNativeTools(model) && len(a.tools) > 0
+
+		// Construct system prompt, tools and history within the context window
+		messages, toolDefs, report := a.prepareContext(ctx, r, native)
+		if report.Trimmed() {
+			fmt.Printf("[Agent]: Context budget: %s\n", report)
+			if emit != nil {
+				emit(Event{Type: EventContext, Content: report.String()})
+			}
+		}
+
+		var streamer *finalStreamer
+		var onDelta func(string)
+		if emit != nil {
+			streamer = newFinalStreamer(emit)
+			onDelta = streamer.Write
+		}
 
-		rawResponse, err := a.client.GenerateResponse(ctx, messages)
+		var rawResponse string
+		var err error
+		llmStart := time.Now()
+		llmCtx := r.generationContext(ctx)
+		if native {
+			var reply llm.Message
+			reply, err = r.client.GenerateWithTools(llmCtx, messages, toolDefs, onDelta)
+			r.recordLLM(messages, llmStart, replySummary(reply), err)
+			if errors.Is(err, llm.ErrToolsUnsupported) {
+				fmt.Printf("[Agent]: Model %s does not support native tools, falling back to the text protocol\n", model)
+				a.markNoTools(model)
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			if len(reply.ToolCalls) > 0 {
+				if streamer != nil {
+					// Text written alongside tool calls is not the answer
+					streamer.Reset()
+				}
+				if err := a.runToolCalls(ctx, r, reply, guard, emit); err != nil {
+					return a.abort(r, err)
+				}
+				continue
+			}
+			// No tool calls: the content is the answer, possibly still in the text protocol
+			rawResponse = reply.Content
+		} else {
+			var tp ThoughtProcess
+			rawResponse, err = llm.GenerateStructured(llmCtx, r.client, messages, llm.Structured{
+				Schema:   thoughtSchema,
+				Validate: func() error { return a.checkThought(tp) },
+				OnDelta:  onDelta,
+				OnRepair: func(error) {
+					if streamer != nil {
+						streamer.Reset()
+					}
+				},
+			}, &tp)
+			r.recordLLM(messages, llmStart, rawResponse, err)
+		}
 		if err != nil {
 			return "", err
 		}
@@ -150,74 +362,174 @@ func (a *Agent) internalLoop(ctx context.Context) (string, error) {
 
 		// Attempt to parse the LLM's thought process
 		var tp ThoughtProcess
-		extracted := a.extractJSON(rawResponse)
+		extracted := llm.ExtractJSON(rawResponse)
 		err = json.Unmarshal([]byte(extracted), &tp)
-		if err != nil || (tp.Final == "" && tp.Tool == "" && tp.Thought == "") {
+		if err != nil || (tp.Final == "" && tp.Tool == "" && len(tp.Tools) == 0 && tp.Thought == "") {
 			// If JSON parsing fails, the model might just be talking; treat as final
-			a.history = append(a.history, llm.Message{Role: "assistant", Content: rawResponse})
-			if a.store != nil {
-				a.store.SaveMessage("assistant", rawResponse)
-			}
+			r.history = append(r.history, llm.Message{Role: "assistant", Content: rawResponse})
+			a.saveMessage(r.session, "assistant", rawResponse)
 			return rawResponse, nil
 		}
 
 		// If the model provides a final answer, return it
 		if tp.Final != "" {
-			a.history = append(a.history, llm.Message{Role: "assistant", Content: tp.Final})
-			if a.store != nil {
-				a.store.SaveMessage("assistant", tp.Final)
-			}
+			r.history = append(r.history, llm.Message{Role: "assistant", Content: tp.Final})
+			a.saveMessage(r.session, "assistant", tp.Final)
 			return tp.Final, nil
 		}
 
-		// Execute tool if requested
-		if tp.Tool != "" {
-			tool, ok := a.tools[tp.Tool]
-			if !ok {
-				errorMsg := fmt.Sprintf("Error: Tool '%s' not found.", tp.Tool)
-				a.history = append(a.history, llm.Message{Role: "assistant", Content: errorMsg})
-				continue
+		// Execute the requested tools
+		if calls := tp.calls(); len(calls) > 0 {
+			if streamer != nil {
+				streamer.Reset()
 			}
-
 			fmt.Printf("\n[Idony Thought]: %s\n", tp.Thought)
-			inputStr := string(tp.Input)
-			// Remove surrounding quotes if it's just a string, otherwise keep as JSON
-			if strings.HasPrefix(inputStr, "\"") && strings.HasSuffix(inputStr, "\"") {
-				var s string
-				if err := json.Unmarshal(tp.Input, &s); err == nil {
-					inputStr = s
-				}
+			if emit != nil && tp.Thought != "" {
+				emit(Event{Type: EventThought, Content: tp.Thought})
 			}
-			fmt.Printf("[Executing Tool]: %s with input: %s\n", tp.Tool, inputStr)
+			results, err := a.runTools(ctx, r, calls, guard, emit)
 
-			result, err := tool.Execute(ctx, inputStr)
+			// Add the observations back to history, in the order they were requested
+			r.history = append(r.history, llm.Message{Role: "assistant", Content: observations(calls, results)})
 			if err != nil {
-				result = fmt.Sprintf("Tool error: %v", err)
+				return a.abort(r, err)
 			}
-			fmt.Printf("[Tool Result]: %s\n", result)
-
-			// Add observation back to history
-			observation := fmt.Sprintf("Observation: %s", result)
-			a.history = append(a.history, llm.Message{Role: "assistant", Content: observation})
 			continue
 		}
 
 		// Fallback: if we have a thought but no action/final, return the raw response
 		// to preserve any conversational text outside the JSON.
-		a.history = append(a.history, llm.Message{Role: "assistant", Content: rawResponse})
-		if a.store != nil {
-			a.store.SaveMessage("assistant", rawResponse)
-		}
+		r.history = append(r.history, llm.Message{Role: "assistant", Content: rawResponse})
+		a.saveMessage(r.session, "assistant", rawResponse)
 		return rawResponse, nil
 	}
 }
 
-func (a *Agent) buildSystemPrompt() string {
-	var toolDocs []string
-	for _, t := range a.tools {
-		toolDocs = append(toolDocs, fmt.Sprintf("- %s: %s", t.Name(), t.Description()))
+// supportsNativeTools reports whether model has not rejected native tool calling.
+func (a *Agent) supportsNativeTools(model string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return !a.noToolModels[model]
+}
+
+// markNoTools remembers that model rejected native tool calling.
+func (a *Agent) markNoTools(model string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.noToolModels[model] = true
+}
+
+// checkThought rejects text-protocol replies that neither call a known tool nor answer.
+func (a *Agent) checkThought(tp ThoughtProcess) error {
+	calls := tp.calls()
+	if len(calls) == 0 && tp.Final == "" {
+		return fmt.Errorf("either \"tool\", \"tools\" or \"final\" must be set")
+	}
+	for _, c := range calls {
+		if c.name == moreToolsName && a.selector != nil {
+			continue
+		}
+		if _, ok := a.tools[c.name]; !ok {
+			return fmt.Errorf("tool %q does not exist", c.name)
+		}
+	}
+	return nil
+}
+
+// abort ends a run stopped by its limits. A note is left in the history so the
+// next turn knows this one was cut short; it is also returned as the result.
+func (a *Agent) abort(r *run, err error) (string, error) {
+	note := fmt.Sprintf("[Run aborted: %v]", err)
+	fmt.Printf("[Agent]: %s\n", note)
+	r.history = append(r.history, llm.Message{Role: "assistant", Content: note})
+	a.saveMessage(r.session, "assistant", note)
+	return note, err
+}
+
+// cancelled ends a run stopped through its handle. Like abort, it leaves a note
+// in the history so the next turn knows this one was cut short.
+func (a *Agent) cancelled(r *run) (string, error) {
+	note := "[Run cancelled by the user]"
+	fmt.Printf("[Agent]: %s\n", note)
+	r.history = append(r.history, llm.Message{Role: "assistant", Content: note})
+	a.saveMessage(r.session, "assistant", note)
+	return note, ErrRunCancelled
+}
+
+// runToolCalls executes the native tool calls of an assistant reply and records
+// the call and every result in the history, in the order of the calls. Calls
+// after one rejected by guard are answered without running them, so the history
+// stays valid.
+func (a *Agent) runToolCalls(ctx context.Context, r *run, reply llm.Message, guard *runGuard, emit EventHandler) error {
+	calls := make([]toolCall, len(reply.ToolCalls))
+	for i := range reply.ToolCalls {
+		// OpenAI-compatible servers need IDs to pair calls with results; Ollama does not assign them
+		if reply.ToolCalls[i].ID == "" {
+			reply.ToolCalls[i].ID = fmt.Sprintf("call_%d_%d", len(r.history), i)
+		}
+		calls[i] = toolCall{name: reply.ToolCalls[i].Function.Name, input: toolInput(reply.ToolCalls[i].Function.Arguments)}
+	}
+	r.history = append(r.history, llm.Message{Role: "assistant", Content: reply.Content, ToolCalls: reply.ToolCalls})
+
+	if thought := strings.TrimSpace(reply.Content); thought != "" {
+		fmt.Printf("\n[Idony Thought]: %s\n", thought)
+		if emit != nil {
+			emit(Event{Type: EventThought, Content: thought})
+		}
+	}
+
+	results, abortErr := a.runTools(ctx, r, calls, guard, emit)
+	for i, call := range reply.ToolCalls {
+		r.history = append(r.history, llm.Message{Role: "tool", Content: results[i], ToolName: calls[i].name, ToolCallID: call.ID})
+	}
+	return abortErr
+}
+
+// executeTool runs a single tool, reports the call and its result to emit and
+// records them in the run's trace. Calls that need approval wait for it first;
+// tool errors and denials are returned as text so the model can react to them.
+func (a *Agent) executeTool(ctx context.Context, r *run, tool base.Tool, input string, emit EventHandler) string {
+	fmt.Printf("[Executing Tool]: %s with input: %s\n", tool.Name(), input)
+	if emit != nil {
+		emit(Event{Type: EventToolCall, Tool: tool.Name(), Input: input})
+	}
+
+	start := time.Now()
+	step := db.RunStep{Kind: "tool", Tool: tool.Name(), Input: input, StartedAt: start}
+	var result string
+	var err error
+	if ok, reason := a.approve(ctx, tool.Name(), input, emit); !ok {
+		result = reason
+		step.Error = "not approved"
+	} else {
+		result, err = tool.Execute(ctx, input)
+	}
+	if err != nil {
+		result = fmt.Sprintf("Tool error: %v", err)
+		step.Error = err.Error()
+	}
+	step.Output = result
+	step.Duration = time.Since(start)
+	r.record(step)
+	fmt.Printf("[Tool Result]: %s\n", result)
+	if emit != nil {
+		emit(Event{Type: EventObservation, Tool: tool.Name(), Content: result})
+	}
+	return result
+}
+
+// approve waits for a human's decision if the call needs one.
+func (a *Agent) approve(ctx context.Context, tool, input string, emit EventHandler) (bool, string) {
+	if a.approvals == nil || !a.approvals.Requires(tool, input) {
+		return true, ""
 	}
+	return a.approvals.wait(ctx, base.SessionID(ctx), tool, input, emit)
+}
 
+// buildSystemPrompt assembles the personality, memories and tool instructions.
+// With native tool calling the tools travel in the request, so only the text
+// protocol needs the <json> format and the tool list.
+func (a *Agent) buildSystemPrompt(native bool, memories, toolDocs []string) string {
 	personality := a.personality
 	if personality == "" {
 		if a.store != nil {
@@ -230,18 +542,23 @@ func (a *Agent) buildSystemPrompt() string {
 
 	// Inject Memories
 	memoryContext := ""
-	if a.store != nil {
-		// Fetch recent/relevant memories. 
-		// For now, we fetch the last 10 memories as context.
-		// Future improvement: Vector search based on current input.
-		memories, _ := a.store.SearchMemories("", 10) 
-		if len(memories) > 0 {
-			var mems []string
-			for _, m := range memories {
-				mems = append(mems, fmt.Sprintf("- [%s] %s", m.Type, m.Content))
-			}
-			memoryContext = "\n\nRELEVANT MEMORIES:\n" + strings.Join(mems, "\n")
-		}
+	if len(memories) > 0 {
+		memoryContext = "\n\nRELEVANT MEMORIES:\n" + strings.Join(memories, "\n")
+	}
+
+	if native {
+		return fmt.Sprintf("%s\n"+
+			"You operate in a Think -> Plan -> Act -> Observe loop.\n"+
+			"Call the provided tools whenever you need information or need to take an action. "+
+			"Calls that do not depend on each other can be made together in one reply. "+
+			"Once you have the final answer, reply to the user directly in plain text.\n"+
+			"%s\n\n"+
+			"INTERACTIVE MODE:\n"+
+			"If a tool requires parameters you do not have, ask the user for them in your reply.\n\n"+
+			"IMAGE ANALYSIS:\n"+
+			"You can analyze images directly or use the 'subagent' tool.",
+			personality,
+			memoryContext)
 	}
 
 	return fmt.Sprintf("%s\n"+
@@ -263,26 +580,9 @@ func (a *Agent) buildSystemPrompt() string {
 		"You can analyze images directly or use the 'subagent' tool.\n\n"+
 		"Available Tools:\n"+
 		"%s\n\n"+
-		"If you have the final answer, use \"final\". If you need a tool, use \"tool\" and \"input\".",
+		"If you have the final answer, use \"final\". If you need a tool, use \"tool\" and \"input\".\n"+
+		"To call several independent tools at once, use \"tools\" instead: a list of {\"tool\": ..., \"input\": ...} objects.",
 		personality,
 		memoryContext,
 		strings.Join(toolDocs, "\n"))
 }
-
-// extractJSON is a helper to find a JSON block in the model's output.
-func (a *Agent) extractJSON(s string) string {
-	// Try to find <json> tags first
-	if start := strings.Index(s, "<json>"); start != -1 {
-		if end := strings.Index(s[start:], "</json>"); end != -1 {
-			return s[start+6 : start+end]
-		}
-	}
-
-	// Fallback to first { and last }
-	start := strings.Index(s, "{")
-	end := strings.LastIndex(s, "}")
-	if start != -1 && end != -1 && end > start {
-		return s[start : end+1]
-	}
-	return s
-}
@@ -0,0 +1,563 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
+)
+
+// agentDirPollInterval is how often the agents directory is checked for changes.
+const agentDirPollInterval = 5 * time.Second
+
+// AgentChange is a difference between the agents directory and the database.
+type AgentChange struct {
+	Kind   string // "agent" or "council"
+	Name   string
+	Action string   // "create", "update" or "delete"
+	Fields []string // Fields that differ, for updates
+	Source string   // File the change comes from; the removed file for deletions
+
+	agent   *db.SubAgentDefinition
+	council *db.Council
+}
+
+func (c AgentChange) String() string {
+	s := fmt.Sprintf("%s %s '%s'", c.Action, c.Kind, c.Name)
+	if len(c.Fields) > 0 {
+		s += " (" + strings.Join(c.Fields, ", ") + ")"
+	}
+	if c.Source != "" {
+		s += " from " + c.Source
+	}
+	return s
+}
+
+// AgentDir keeps sub-agent definitions and councils in sync with a directory
+// of agent files (see AgentFile), so they can be reviewed in git and shared
+// between machines. The files are the source of truth for the names they
+// define: a definition changed through the tools is reset on the next sync.
+// Definitions loaded from the directory remember their file and are deleted
+// with it; those only ever created through the tools are left alone.
+type AgentDir struct {
+	dir      string
+	store    *db.Store
+	councils *CouncilManager
+	mu       sync.Mutex // Serializes syncs and exports
+}
+
+func NewAgentDir(dir string, store *db.Store, councils *CouncilManager) *AgentDir {
+	return &AgentDir{dir: filepath.Clean(dir), store: store, councils: councils}
+}
+
+// Dir returns the directory the agent files are kept in.
+func (d *AgentDir) Dir() string {
+	return d.dir
+}
+
+// Load reads every .md file of the directory and its subdirectories. A missing
+// directory holds no files.
+func (d *AgentDir) Load() ([]AgentFile, error) {
+	var files []AgentFile
+	seen := make(map[string]string)
+	err := filepath.WalkDir(d.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == d.dir && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".md") {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		f, err := ParseAgentFile(path, data)
+		if err != nil {
+			return err
+		}
+		key := f.Kind() + " " + f.Name()
+		if other, dup := seen[key]; dup {
+			return fmt.Errorf("%s '%s' is defined in both %s and %s", f.Kind(), f.Name(), other, path)
+		}
+		seen[key] = path
+		files = append(files, f)
+		return nil
+	})
+	return files, err
+}
+
+// Diff compares the directory with the database without changing anything.
+func (d *AgentDir) Diff() ([]AgentChange, error) {
+	files, err := d.Load()
+	if err != nil {
+		return nil, err
+	}
+	return d.plan(files)
+}
+
+// Sync applies the changes of the directory to the database. Every change is
+// tried; the ones that failed are reported in the error.
+func (d *AgentDir) Sync() ([]AgentChange, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	files, err := d.Load()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := d.plan(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []AgentChange
+	var errs []error
+	for _, c := range changes {
+		if err := d.apply(c); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c, err))
+			continue
+		}
+		applied = append(applied, c)
+	}
+	return applied, errors.Join(errs...)
+}
+
+// plan lists the changes that make the database match files. Agents come
+// before councils, which may name them as moderator, and deletions come last.
+func (d *AgentDir) plan(files []AgentFile) ([]AgentChange, error) {
+	agents := make(map[string]db.SubAgentDefinition)
+	councils := make(map[string]db.Council)
+	joins := make(map[string][]string) // Council -> agents whose file names it
+	joinSrc := make(map[string]string) // Council -> first agent file naming it
+	for _, f := range files {
+		if f.Council != nil {
+			councils[f.Council.Name] = *f.Council
+			continue
+		}
+		agents[f.Agent.Name] = *f.Agent
+		for _, council := range f.Councils {
+			joins[council] = append(joins[council], f.Agent.Name)
+			if _, ok := joinSrc[council]; !ok || f.Path < joinSrc[council] {
+				joinSrc[council] = f.Path
+			}
+		}
+	}
+
+	existingAgents, err := d.store.GetSubAgentDefinitions()
+	if err != nil {
+		return nil, err
+	}
+	existingCouncils, err := d.store.GetCouncils()
+	if err != nil {
+		return nil, err
+	}
+	oldAgents := make(map[string]db.SubAgentDefinition)
+	for _, a := range existingAgents {
+		oldAgents[a.Name] = a
+	}
+	oldCouncils := make(map[string]db.Council)
+	for _, c := range existingCouncils {
+		oldCouncils[c.Name] = c
+	}
+
+	// Agents join the councils their files name. A council without a file of
+	// its own is kept as it is in the database, or created with the defaults.
+	for name, members := range joins {
+		c, ok := councils[name]
+		if !ok {
+			c = db.Council{Name: name, Source: joinSrc[name]}
+			if old, exists := oldCouncils[name]; exists && old.Source == "" {
+				c = old
+			}
+		}
+		list := splitList(c.Members)
+		sort.Strings(members)
+		for _, m := range members {
+			if !contains(list, m) {
+				list = append(list, m)
+			}
+		}
+		c.Members = strings.Join(list, ",")
+		councils[name] = c
+	}
+
+	var changes []AgentChange
+	for _, name := range sortedKeys(agents) {
+		def := agents[name]
+		c := AgentChange{Kind: "agent", Name: name, Source: def.Source, agent: &def}
+		if old, ok := oldAgents[name]; !ok {
+			c.Action = "create"
+		} else if c.Fields = agentDiff(old, def); len(c.Fields) > 0 {
+			c.Action = "update"
+		} else {
+			continue
+		}
+		changes = append(changes, c)
+	}
+	for _, name := range sortedKeys(councils) {
+		council := councils[name]
+		c := AgentChange{Kind: "council", Name: name, Source: council.Source, council: &council}
+		if old, ok := oldCouncils[name]; !ok {
+			c.Action = "create"
+		} else if c.Fields = councilDiff(old, council); len(c.Fields) > 0 {
+			c.Action = "update"
+		} else {
+			continue
+		}
+		changes = append(changes, c)
+	}
+	for _, old := range existingCouncils {
+		if _, kept := councils[old.Name]; !kept && d.owns(old.Source) {
+			changes = append(changes, AgentChange{Kind: "council", Name: old.Name, Action: "delete", Source: old.Source})
+		}
+	}
+	for _, old := range existingAgents {
+		if _, kept := agents[old.Name]; !kept && d.owns(old.Source) {
+			changes = append(changes, AgentChange{Kind: "agent", Name: old.Name, Action: "delete", Source: old.Source})
+		}
+	}
+	return changes, nil
+}
+
+// owns reports whether source is a file of the directory.
+func (d *AgentDir) owns(source string) bool {
+	if source == "" {
+		return false
+	}
+	rel, err := filepath.Rel(d.dir, source)
+	return err == nil && !strings.HasPrefix(rel, "..")
+}
+
+func (d *AgentDir) apply(c AgentChange) error {
+	switch {
+	case c.Kind == "agent" && c.Action == "delete":
+		return d.store.DeleteSubAgentDefinition(c.Name)
+	case c.Kind == "council" && c.Action == "delete":
+		return d.store.DeleteCouncil(c.Name)
+	case c.agent != nil:
+		return saveAgentDefinition(d.store, *c.agent)
+	case c.council != nil:
+		return d.councils.DefineCouncil(*c.council)
+	}
+	return nil
+}
+
+// Import saves a single agent or council file, e.g. one shared by a teammate,
+// as if it had been defined through the tools. An agent file also adds the
+// agent to the councils it names that exist.
+func (d *AgentDir) Import(data []byte) (AgentChange, error) {
+	f, err := ParseAgentFile("", data)
+	if err != nil {
+		return AgentChange{}, err
+	}
+	c := AgentChange{Kind: f.Kind(), Name: f.Name(), Action: "create"}
+	if f.Council != nil {
+		old, err := d.store.GetCouncil(f.Council.Name)
+		if err != nil {
+			return AgentChange{}, err
+		}
+		if old != nil {
+			c.Action, c.Fields = "update", councilDiff(*old, *f.Council)
+		}
+		return c, d.councils.DefineCouncil(*f.Council)
+	}
+
+	old, err := d.store.GetSubAgentDefinition(f.Agent.Name)
+	if err != nil {
+		return AgentChange{}, err
+	}
+	if old != nil {
+		c.Action, c.Fields = "update", agentDiff(*old, *f.Agent)
+	}
+	if err := saveAgentDefinition(d.store, *f.Agent); err != nil {
+		return AgentChange{}, err
+	}
+	for _, name := range f.Councils {
+		council, err := d.store.GetCouncil(name)
+		if err != nil {
+			return c, err
+		}
+		if council == nil {
+			return c, fmt.Errorf("council '%s' does not exist", name)
+		}
+		if members := splitList(council.Members); !contains(members, f.Agent.Name) {
+			council.Members = strings.Join(append(members, f.Agent.Name), ",")
+			if err := d.store.SaveCouncil(*council); err != nil {
+				return c, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// Export writes every sub-agent definition and council of the database to the
+// directory, agents under agents/ and councils under councils/ unless they
+// already have a file there, and records those files as their source. It
+// returns the files that were written.
+func (d *AgentDir) Export() ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	agents, err := d.store.GetSubAgentDefinitions()
+	if err != nil {
+		return nil, err
+	}
+	councils, err := d.store.GetCouncils()
+	if err != nil {
+		return nil, err
+	}
+
+	var written []string
+	write := func(source, sub, name string, data []byte) (string, error) {
+		path := source
+		if !d.owns(path) {
+			path = filepath.Join(d.dir, sub, fileName(name)+".md")
+		}
+		// Files that already say the same are left as they were written
+		if old, err := os.ReadFile(path); err == nil && sameAgentFile(path, old, data) {
+			return path, nil
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return "", err
+		}
+		written = append(written, path)
+		return path, nil
+	}
+
+	for _, a := range agents {
+		data, err := FormatAgentFile(a, councilsOf(a.Name, councils))
+		if err != nil {
+			return written, err
+		}
+		path, err := write(a.Source, "agents", a.Name, data)
+		if err != nil {
+			return written, err
+		}
+		if a.Source != path {
+			a.Source = path
+			if err := d.store.SaveSubAgentDefinition(a); err != nil {
+				return written, err
+			}
+		}
+	}
+	for _, c := range councils {
+		path, err := write(c.Source, "councils", c.Name, FormatCouncilFile(c))
+		if err != nil {
+			return written, err
+		}
+		if c.Source != path {
+			c.Source = path
+			if err := d.store.SaveCouncil(c); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// ExportAgent returns a sub-agent definition as an agent file, or nil if
+// there is no such agent.
+func (d *AgentDir) ExportAgent(name string) ([]byte, error) {
+	def, err := d.store.GetSubAgentDefinition(name)
+	if err != nil || def == nil {
+		return nil, err
+	}
+	councils, err := d.store.GetCouncils()
+	if err != nil {
+		return nil, err
+	}
+	return FormatAgentFile(*def, councilsOf(name, councils))
+}
+
+// ExportCouncil returns a council as a council file, or nil if there is no
+// such council.
+func (d *AgentDir) ExportCouncil(name string) ([]byte, error) {
+	c, err := d.store.GetCouncil(name)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	return FormatCouncilFile(*c), nil
+}
+
+// Watch syncs the directory whenever one of its files changes, until ctx is
+// done. Call Sync first to load the directory on startup.
+func (d *AgentDir) Watch(ctx context.Context) {
+	last := d.fingerprint()
+	ticker := time.NewTicker(agentDirPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		current := d.fingerprint()
+		if current == last {
+			continue
+		}
+		last = current
+		changes, err := d.Sync()
+		for _, c := range changes {
+			fmt.Printf("[Agents]: %s\n", c)
+		}
+		if err != nil {
+			fmt.Printf("[Agents]: Could not sync %s: %v\n", d.dir, err)
+		}
+	}
+}
+
+// fingerprint summarizes the names, sizes and modification times of the
+// directory's files, so changes can be noticed without reading them.
+func (d *AgentDir) fingerprint() string {
+	var b strings.Builder
+	filepath.WalkDir(d.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(entry.Name(), ".md") {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	return b.String()
+}
+
+// sameAgentFile reports whether two agent files define the same thing.
+func sameAgentFile(path string, old, data []byte) bool {
+	if bytes.Equal(old, data) {
+		return true
+	}
+	a, err := ParseAgentFile(path, old)
+	if err != nil {
+		return false
+	}
+	b, err := ParseAgentFile(path, data)
+	if err != nil || a.Kind() != b.Kind() {
+		return false
+	}
+	if a.Council != nil {
+		return len(councilDiff(*a.Council, *b.Council)) == 0
+	}
+	sort.Strings(a.Councils)
+	return len(agentDiff(*a.Agent, *b.Agent)) == 0 && strings.Join(a.Councils, ",") == strings.Join(b.Councils, ",")
+}
+
+// councilsOf returns the names of the councils agent is a member of.
+func councilsOf(agent string, councils []db.Council) []string {
+	var names []string
+	for _, c := range councils {
+		if contains(splitList(c.Members), agent) {
+			names = append(names, c.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// saveAgentDefinition checks a sub-agent definition and saves it.
+func saveAgentDefinition(store *db.Store, def db.SubAgentDefinition) error {
+	if def.Timeout != "" {
+		if t, err := time.ParseDuration(def.Timeout); err != nil || t <= 0 {
+			return fmt.Errorf("invalid timeout %q, expected a duration such as 30m", def.Timeout)
+		}
+	}
+	opts, err := llm.ParseOptions(def.Options)
+	if err != nil {
+		return fmt.Errorf("invalid model options: %w", err)
+	}
+	def.Options = opts.String()
+	fallbacks, err := llm.ParseRoutes(def.Fallbacks)
+	if err != nil {
+		return fmt.Errorf("invalid fallbacks: %w", err)
+	}
+	def.Fallbacks = llm.FormatRoutes(fallbacks)
+	return store.SaveSubAgentDefinition(def)
+}
+
+// agentDiff lists the fields in which two definitions differ.
+func agentDiff(old, def db.SubAgentDefinition) []string {
+	var fields []string
+	if strings.TrimSpace(old.Personality) != strings.TrimSpace(def.Personality) {
+		fields = append(fields, "personality")
+	}
+	if strings.Join(splitList(old.Tools), ",") != strings.Join(splitList(def.Tools), ",") {
+		fields = append(fields, "tools")
+	}
+	if old.Model != def.Model {
+		fields = append(fields, "model")
+	}
+	if old.Timeout != def.Timeout {
+		fields = append(fields, "timeout")
+	}
+	if normalizeOptions(old.Options) != normalizeOptions(def.Options) {
+		fields = append(fields, "options")
+	}
+	if strings.Join(splitList(old.Fallbacks), ",") != strings.Join(splitList(def.Fallbacks), ",") {
+		fields = append(fields, "fallbacks")
+	}
+	if old.Source != def.Source {
+		fields = append(fields, "source")
+	}
+	return fields
+}
+
+// councilDiff lists the fields in which two councils differ.
+func councilDiff(old, c db.Council) []string {
+	var fields []string
+	if strings.Join(splitList(old.Members), ",") != strings.Join(splitList(c.Members), ",") {
+		fields = append(fields, "members")
+	}
+	if old.Rounds != c.Rounds {
+		fields = append(fields, "rounds")
+	}
+	if old.Order != c.Order {
+		fields = append(fields, "order")
+	}
+	if old.Moderator != c.Moderator {
+		fields = append(fields, "moderator")
+	}
+	if old.Voting != c.Voting {
+		fields = append(fields, "voting")
+	}
+	if old.StopOnConsensus != c.StopOnConsensus {
+		fields = append(fields, "stop_on_consensus")
+	}
+	if old.Source != c.Source {
+		fields = append(fields, "source")
+	}
+	return fields
+}
+
+// fileName turns an agent or council name into a safe file name.
+func fileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,429 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pyromancer/idony/internal/db"
+)
+
+// AgentFile is a sub-agent definition or council written as Markdown with a
+// front-matter header. For agents the body is the personality:
+//
+//	---
+//	name: critic
+//	model: llama3.1:8b
+//	tools: [web_search, read_file]
+//	timeout: 30m
+//	fallbacks: [llama3.1:8b, llama3.1:8b@http://gpu2:11434]
+//	councils: [review-board]
+//	options:
+//	  temperature: 0.2
+//	---
+//	You are a harsh but fair critic...
+//
+// Council files set "kind: council" and the council's settings; their body is
+// free text for the reader:
+//
+//	---
+//	kind: council
+//	name: review-board
+//	members: [critic, optimist]
+//	rounds: 3
+//	order: sequential
+//	moderator: judge
+//	voting: true
+//	stop_on_consensus: false
+//	---
+type AgentFile struct {
+	Path     string
+	Agent    *db.SubAgentDefinition // Set for agent files
+	Councils []string               // Councils the agent is a member of
+	Council  *db.Council            // Set for council files
+}
+
+// Kind returns "agent" or "council".
+func (f AgentFile) Kind() string {
+	if f.Council != nil {
+		return "council"
+	}
+	return "agent"
+}
+
+// Name returns the name of the agent or council.
+func (f AgentFile) Name() string {
+	if f.Council != nil {
+		return f.Council.Name
+	}
+	return f.Agent.Name
+}
+
+// ParseAgentFile reads an agent or council file. path is only used in errors
+// and recorded as the source of the definition.
+func ParseAgentFile(path string, data []byte) (AgentFile, error) {
+	fail := func(format string, args ...interface{}) (AgentFile, error) {
+		err := fmt.Errorf(format, args...)
+		if path != "" {
+			err = fmt.Errorf("%s: %w", path, err)
+		}
+		return AgentFile{}, err
+	}
+	fields, body, err := parseFrontMatter(data)
+	if err != nil {
+		return fail("%w", err)
+	}
+	f := AgentFile{Path: path}
+	var known []string
+	str := func(key string) string {
+		known = append(known, key)
+		v, _ := fields[key].(string)
+		return v
+	}
+	list := func(key string) []string {
+		known = append(known, key)
+		switch v := fields[key].(type) {
+		case []string:
+			return v
+		case string:
+			return splitList(v)
+		}
+		return nil
+	}
+
+	switch kind := str("kind"); kind {
+	case "", "agent":
+		def := &db.SubAgentDefinition{
+			Name:        str("name"),
+			Personality: body,
+			Tools:       strings.Join(list("tools"), ","),
+			Model:       str("model"),
+			Timeout:     str("timeout"),
+			Fallbacks:   strings.Join(list("fallbacks"), ","),
+			Source:      path,
+		}
+		known = append(known, "options")
+		if opts, ok := fields["options"]; ok {
+			if def.Options, err = encodeOptions(opts); err != nil {
+				return fail("options: %w", err)
+			}
+		}
+		if def.Personality == "" {
+			return fail("the personality (the text after the header) is empty")
+		}
+		f.Agent = def
+		f.Councils = list("councils")
+	case "council":
+		c := &db.Council{
+			Name:      str("name"),
+			Members:   strings.Join(list("members"), ","),
+			Order:     str("order"),
+			Moderator: str("moderator"),
+			Source:    path,
+		}
+		if v := str("rounds"); v != "" {
+			if c.Rounds, err = strconv.Atoi(v); err != nil {
+				return fail("rounds must be a number")
+			}
+		}
+		for key, dst := range map[string]*bool{"voting": &c.Voting, "stop_on_consensus": &c.StopOnConsensus} {
+			if v := str(key); v != "" {
+				if *dst, err = strconv.ParseBool(v); err != nil {
+					return fail("%s must be true or false", key)
+				}
+			}
+		}
+		f.Council = c
+	default:
+		return fail("unknown kind %q, expected agent or council", kind)
+	}
+
+	if f.Name() == "" {
+		return fail("name is required")
+	}
+	for key := range fields {
+		if !contains(known, key) {
+			return fail("unknown field %q in %s file", key, f.Kind())
+		}
+	}
+	return f, nil
+}
+
+// FormatAgentFile writes a sub-agent definition as an agent file.
+func FormatAgentFile(def db.SubAgentDefinition, councils []string) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	writeField(&b, "name", def.Name)
+	writeField(&b, "model", def.Model)
+	writeList(&b, "tools", splitList(def.Tools))
+	writeField(&b, "timeout", def.Timeout)
+	writeList(&b, "fallbacks", splitList(def.Fallbacks))
+	writeList(&b, "councils", councils)
+	if def.Options != "" {
+		var opts map[string]interface{}
+		if err := json.Unmarshal([]byte(def.Options), &opts); err != nil {
+			return nil, fmt.Errorf("options of agent '%s' are not a JSON object: %w", def.Name, err)
+		}
+		keys := make([]string, 0, len(opts))
+		for k := range opts {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if len(keys) > 0 {
+			b.WriteString("options:\n")
+		}
+		for _, k := range keys {
+			fmt.Fprintf(&b, "  %s: %s\n", k, formatOption(opts[k]))
+		}
+	}
+	b.WriteString("---\n")
+	b.WriteString(strings.TrimSpace(def.Personality))
+	b.WriteString("\n")
+	return b.Bytes(), nil
+}
+
+// FormatCouncilFile writes a council as a council file.
+func FormatCouncilFile(c db.Council) []byte {
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	writeField(&b, "kind", "council")
+	writeField(&b, "name", c.Name)
+	writeList(&b, "members", splitList(c.Members))
+	if c.Rounds > 0 {
+		writeField(&b, "rounds", strconv.Itoa(c.Rounds))
+	}
+	writeField(&b, "order", c.Order)
+	writeField(&b, "moderator", c.Moderator)
+	writeField(&b, "voting", strconv.FormatBool(c.Voting))
+	writeField(&b, "stop_on_consensus", strconv.FormatBool(c.StopOnConsensus))
+	b.WriteString("---\n")
+	return b.Bytes()
+}
+
+// parseFrontMatter splits a file into its header fields and body. The header
+// is a small subset of YAML: "key: value" lines, lists written as [a, b] or as
+// "- item" lines, and one level of nested "key: value" lines (for options).
+// Values are strings; lists are []string and nested keys map[string]interface{}.
+func parseFrontMatter(data []byte) (map[string]interface{}, string, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if !strings.HasPrefix(text, "---\n") {
+		return nil, "", fmt.Errorf("missing front-matter: the file must start with a --- line")
+	}
+	lines := strings.Split(text, "\n")
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimRight(lines[i], " \t") == "---" {
+			end = i
+			break
+		}
+	}
+	if end == -1 {
+		return nil, "", fmt.Errorf("front-matter is not closed with a --- line")
+	}
+
+	fields := make(map[string]interface{})
+	parent := ""
+	for i := 1; i < end; i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indented := line != trimmed
+
+		if parent != "" && strings.HasPrefix(trimmed, "- ") {
+			items, _ := fields[parent].([]string)
+			if _, isMap := fields[parent].(map[string]interface{}); isMap {
+				return nil, "", fmt.Errorf("line %d: list item under %q, which has keys", i+1, parent)
+			}
+			fields[parent] = append(items, unquote(strings.TrimPrefix(trimmed, "- ")))
+			continue
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, "", fmt.Errorf("line %d: expected \"key: value\"", i+1)
+		}
+		value = strings.TrimSpace(value)
+
+		if indented {
+			if parent == "" {
+				return nil, "", fmt.Errorf("line %d: unexpected indentation", i+1)
+			}
+			nested, isMap := fields[parent].(map[string]interface{})
+			if !isMap {
+				if _, isList := fields[parent].([]string); isList {
+					return nil, "", fmt.Errorf("line %d: key under %q, which is a list", i+1, parent)
+				}
+				nested = make(map[string]interface{})
+				fields[parent] = nested
+			}
+			nested[key] = parseValue(value)
+			continue
+		}
+
+		if _, dup := fields[key]; dup {
+			return nil, "", fmt.Errorf("line %d: %q is set twice", i+1, key)
+		}
+		parent = ""
+		if value == "" {
+			// Items or keys follow on indented lines
+			parent = key
+			fields[key] = nil
+			continue
+		}
+		fields[key] = parseValue(value)
+	}
+	for key, v := range fields {
+		if v == nil {
+			fields[key] = ""
+		}
+	}
+
+	body := strings.TrimSpace(strings.Join(lines[end+1:], "\n"))
+	return fields, body, nil
+}
+
+// parseValue reads an inline list or a scalar.
+func parseValue(value string) interface{} {
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		items := []string{}
+		for _, item := range strings.Split(value[1:len(value)-1], ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, unquote(item))
+			}
+		}
+		return items
+	}
+	return unquote(value)
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 {
+		switch {
+		case s[0] == '"' && s[len(s)-1] == '"':
+			if u, err := strconv.Unquote(s); err == nil {
+				return u
+			}
+		case s[0] == '\'' && s[len(s)-1] == '\'':
+			return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+		}
+	}
+	return s
+}
+
+// quote quotes a value that would not read back as the same string.
+func quote(s string) string {
+	if s == "" || s != strings.TrimSpace(s) || strings.ContainsAny(s, ":#,[]{}\"'\n") || strings.HasPrefix(s, "- ") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func writeField(b *bytes.Buffer, key, value string) {
+	if value != "" {
+		fmt.Fprintf(b, "%s: %s\n", key, quote(value))
+	}
+}
+
+func writeList(b *bytes.Buffer, key string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = quote(item)
+	}
+	fmt.Fprintf(b, "%s: [%s]\n", key, strings.Join(quoted, ", "))
+}
+
+// encodeOptions turns the options of an agent file into a JSON object. Numbers
+// and booleans keep their type, lists (such as stop sequences) stay lists.
+func encodeOptions(v interface{}) (string, error) {
+	nested, ok := v.(map[string]interface{})
+	if !ok {
+		if s, _ := v.(string); s == "" {
+			return "", nil
+		}
+		return "", fmt.Errorf("expected indented \"key: value\" lines")
+	}
+	opts := make(map[string]interface{}, len(nested))
+	for k, v := range nested {
+		switch v := v.(type) {
+		case string:
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				opts[k] = n
+			} else if b, err := strconv.ParseBool(v); err == nil {
+				opts[k] = b
+			} else {
+				opts[k] = v
+			}
+		default:
+			opts[k] = v
+		}
+	}
+	if len(opts) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(opts)
+	return string(data), err
+}
+
+// normalizeOptions re-encodes a JSON object so equal options compare equal.
+func normalizeOptions(options string) string {
+	if strings.TrimSpace(options) == "" {
+		return ""
+	}
+	var opts map[string]interface{}
+	if err := json.Unmarshal([]byte(options), &opts); err != nil {
+		return options
+	}
+	if len(opts) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(opts)
+	return string(data)
+}
+
+func formatOption(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return quote(v)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = quote(fmt.Sprint(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
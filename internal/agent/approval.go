@@ -0,0 +1,227 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pyromancer/idony/internal/db"
+)
+
+// DefaultApprovalPolicy requires approval for shell commands, file changes,
+// config updates and sending email.
+const DefaultApprovalPolicy = `exec;rm;write_file;update_config;email.action=(?i)^\s*send\s*$`
+
+// DefaultApprovalTimeout is how long a tool call waits for a decision before it is denied.
+const DefaultApprovalTimeout = 10 * time.Minute
+
+// ApprovalPolicy decides which tool calls need a human's approval.
+type ApprovalPolicy struct {
+	rules []approvalRule
+}
+
+type approvalRule struct {
+	tool    string
+	field   string         // Optional; the pattern applies to this field of a JSON input
+	pattern *regexp.Regexp // Optional; only inputs matching it need approval
+}
+
+// ParseApprovalPolicy parses a ';'-separated list of rules. A rule is either a
+// tool name, requiring approval for every call, "tool=regexp", requiring it
+// only for inputs matching the expression, or "tool.field=regexp", requiring it
+// only if a field of the JSON input matches. "none" disables approvals.
+func ParseApprovalPolicy(spec string) (ApprovalPolicy, error) {
+	var p ApprovalPolicy
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "none" {
+		return p, nil
+	}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		tool, expr, hasPattern := strings.Cut(entry, "=")
+		rule := approvalRule{tool: strings.TrimSpace(tool)}
+		if name, field, ok := strings.Cut(rule.tool, "."); ok && hasPattern {
+			rule.tool, rule.field = name, field
+		}
+		if hasPattern {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return p, fmt.Errorf("invalid approval pattern for %s: %w", rule.tool, err)
+			}
+			rule.pattern = re
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p, nil
+}
+
+// Requires reports whether calling tool with input needs approval.
+func (p ApprovalPolicy) Requires(tool, input string) bool {
+	for _, r := range p.rules {
+		if r.tool != tool {
+			continue
+		}
+		if r.pattern == nil || r.matches(input) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the rule's pattern matches input, or the rule's
+// field of it. Tools decode their input with encoding/json, which takes keys
+// regardless of case and the last of duplicate keys, so every key that could
+// be the field is checked. An input that is not a JSON object is matched as a
+// whole.
+func (r approvalRule) matches(input string) bool {
+	if r.field == "" {
+		return r.pattern.MatchString(input)
+	}
+	dec := json.NewDecoder(strings.NewReader(input))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') || !json.Valid([]byte(input)) {
+		return r.pattern.MatchString(input)
+	}
+	for dec.More() {
+		key, _ := dec.Token()
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			break
+		}
+		if name, _ := key.(string); !strings.EqualFold(name, r.field) {
+			continue
+		}
+		value := string(raw)
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			value = s
+		}
+		if r.pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// ApprovalManager parks tool calls that need approval until a human approves or
+// denies them through the API, a client or Telegram.
+type ApprovalManager struct {
+	store     *db.Store
+	policy    ApprovalPolicy
+	timeout   time.Duration
+	mu        sync.Mutex
+	pending   map[string]chan approvalDecision
+	listeners []func(db.Approval)
+}
+
+type approvalDecision struct {
+	approved bool
+	by       string
+}
+
+func NewApprovalManager(store *db.Store, policy ApprovalPolicy, timeout time.Duration) *ApprovalManager {
+	if timeout <= 0 {
+		timeout = DefaultApprovalTimeout
+	}
+	// Approvals left over from a previous process can no longer be resumed
+	if err := store.ExpirePendingApprovals(); err != nil {
+		fmt.Printf("[Approvals]: Could not expire old approvals: %v\n", err)
+	}
+	return &ApprovalManager{
+		store:   store,
+		policy:  policy,
+		timeout: timeout,
+		pending: make(map[string]chan approvalDecision),
+	}
+}
+
+// OnRequest registers fn to be called whenever a tool call starts waiting for approval.
+func (m *ApprovalManager) OnRequest(fn func(db.Approval)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+// Requires reports whether calling tool with input needs approval.
+func (m *ApprovalManager) Requires(tool, input string) bool {
+	return m.policy.Requires(tool, input)
+}
+
+// Pending returns the approvals still waiting for a decision.
+func (m *ApprovalManager) Pending() ([]db.Approval, error) {
+	return m.store.GetPendingApprovals()
+}
+
+// Decide approves or denies a pending approval on behalf of by.
+func (m *ApprovalManager) Decide(id string, approve bool, by string) error {
+	m.mu.Lock()
+	ch, ok := m.pending[id]
+	delete(m.pending, id)
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("approval %s is not pending", id)
+	}
+
+	ch <- approvalDecision{approved: approve, by: by}
+	return nil
+}
+
+// wait parks a tool call until it is decided, the timeout passes or ctx is done.
+// It reports whether the call may run and, if not, the reason to give the model.
+func (m *ApprovalManager) wait(ctx context.Context, sessionID, tool, input string, emit EventHandler) (bool, string) {
+	a := db.Approval{ID: uuid.New().String()[:8], SessionID: sessionID, Tool: tool, Input: input, Status: "pending", CreatedAt: time.Now()}
+	if err := m.store.SaveApproval(a); err != nil {
+		return false, fmt.Sprintf("Not executed: could not request approval: %v", err)
+	}
+
+	ch := make(chan approvalDecision, 1)
+	m.mu.Lock()
+	m.pending[a.ID] = ch
+	listeners := append([]func(db.Approval){}, m.listeners...)
+	m.mu.Unlock()
+
+	fmt.Printf("[Approvals]: %s (session %s) waiting for approval of %s %s\n", a.ID, sessionID, tool, input)
+	if emit != nil {
+		emit(Event{Type: EventApproval, ID: a.ID, Tool: tool, Input: input})
+	}
+	for _, fn := range listeners {
+		go fn(a)
+	}
+
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+
+	select {
+	case d := <-ch:
+		status := "denied"
+		if d.approved {
+			status = "approved"
+		}
+		m.store.DecideApproval(a.ID, status, d.by)
+		fmt.Printf("[Approvals]: %s %s by %s\n", a.ID, status, d.by)
+		if !d.approved {
+			return false, fmt.Sprintf("Not executed: the user denied running %s.", tool)
+		}
+		return true, ""
+	case <-timer.C:
+		m.expire(a.ID)
+		return false, fmt.Sprintf("Not executed: no approval for %s within %s.", tool, m.timeout)
+	case <-ctx.Done():
+		m.expire(a.ID)
+		return false, fmt.Sprintf("Not executed: %v", ctx.Err())
+	}
+}
+
+func (m *ApprovalManager) expire(id string) {
+	m.mu.Lock()
+	delete(m.pending, id)
+	m.mu.Unlock()
+	m.store.DecideApproval(id, "expired", "")
+}
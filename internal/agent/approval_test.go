@@ -0,0 +1,53 @@
+package agent
+
+import "testing"
+
+func TestDefaultApprovalPolicy(t *testing.T) {
+	policy, err := ParseApprovalPolicy(DefaultApprovalPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		tool, input string
+		want        bool
+	}{
+		{"email", `{"action": "send", "to": "a@example.com"}`, true},
+		{"email", `{"Action": "send"}`, true},
+		{"email", `{"ACTION": "SEND"}`, true},
+		{"email", `{"action": "\u0073end"}`, true},
+		{"email", `{"action": "check", "action": "send"}`, true},
+		{"email", `{"action": "send", "action": "check"}`, true},
+		{"email", `{"action": " send "}`, true},
+		{"email", `{"action": "check"}`, false},
+		{"email", `{"action": "check", "body": "please send"}`, false},
+		{"exec", `ls`, true},
+		{"time", ``, false},
+	}
+	for _, tt := range tests {
+		if got := policy.Requires(tt.tool, tt.input); got != tt.want {
+			t.Errorf("Requires(%q, %s) = %v, want %v", tt.tool, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestApprovalPolicyPatterns(t *testing.T) {
+	policy, err := ParseApprovalPolicy(`exec=rm\s;write_file.path=^/etc/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		tool, input string
+		want        bool
+	}{
+		{"exec", "rm -rf /tmp/x", true},
+		{"exec", "ls", false},
+		{"write_file", `{"path": "/etc/hosts", "content": ""}`, true},
+		{"write_file", `{"Path": "/etc/hosts"}`, true},
+		{"write_file", `{"path": "notes.txt", "content": "/etc/"}`, false},
+	}
+	for _, tt := range tests {
+		if got := policy.Requires(tt.tool, tt.input); got != tt.want {
+			t.Errorf("Requires(%q, %s) = %v, want %v", tt.tool, tt.input, got, tt.want)
+		}
+	}
+}
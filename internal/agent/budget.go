@@ -0,0 +1,270 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
+	"github.com/pyromancer/idony/internal/tools/base"
+)
+
+const (
+	// DefaultContextWindow is used for models without a configured window.
+	DefaultContextWindow = 8192
+	// DefaultContextReserve is the part of the window kept free for the reply.
+	DefaultContextReserve = 1024
+
+	memoryShare = 10 // Max percent of the usable window spent on memories
+	memoryLimit = 10 // Max number of memories in the system prompt
+	toolShare   = 30 // Max percent of the usable window spent on tool docs
+)
+
+// BudgetReport describes how a request was fitted into the context window.
+type BudgetReport struct {
+	Window             int      // Context window of the model in tokens
+	Used               int      // Estimated tokens of the final request
+	DroppedMemories    int      // Memories left out of the system prompt
+	DroppedTools       []string // Tools left out of the request
+	SummarizedMessages int      // Old messages replaced by a summary
+	DroppedMessages    int      // Old messages removed without a summary
+}
+
+// Trimmed reports whether anything had to be left out.
+func (r BudgetReport) Trimmed() bool {
+	return r.DroppedMemories > 0 || len(r.DroppedTools) > 0 || r.SummarizedMessages > 0 || r.DroppedMessages > 0
+}
+
+func (r BudgetReport) String() string {
+	parts := []string{fmt.Sprintf("using ~%d of %d tokens", r.Used, r.Window)}
+	if r.SummarizedMessages > 0 {
+		parts = append(parts, fmt.Sprintf("summarized %d old messages", r.SummarizedMessages))
+	}
+	if r.DroppedMessages > 0 {
+		parts = append(parts, fmt.Sprintf("dropped %d old messages", r.DroppedMessages))
+	}
+	if r.DroppedMemories > 0 {
+		parts = append(parts, fmt.Sprintf("dropped %d memories", r.DroppedMemories))
+	}
+	if len(r.DroppedTools) > 0 {
+		parts = append(parts, fmt.Sprintf("dropped tools: %s", strings.Join(r.DroppedTools, ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ContextLimits holds the context window configured per model.
+type ContextLimits struct {
+	Windows map[string]int // Window in tokens per model; "" is the default for all models
+	Reserve int            // Tokens kept free for the model's reply
+}
+
+// ParseContextLimits reads CONTEXT_WINDOW (default), CONTEXT_WINDOW_<model>
+// and CONTEXT_RESERVE from the given settings.
+func ParseContextLimits(settings map[string]string) ContextLimits {
+	limits := ContextLimits{Windows: make(map[string]int), Reserve: DefaultContextReserve}
+	for k, v := range settings {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 0 {
+			continue
+		}
+		switch {
+		case k == "CONTEXT_WINDOW":
+			limits.Windows[""] = n
+		case strings.HasPrefix(k, "CONTEXT_WINDOW_"):
+			limits.Windows[strings.TrimPrefix(k, "CONTEXT_WINDOW_")] = n
+		case k == "CONTEXT_RESERVE":
+			limits.Reserve = n
+		}
+	}
+	return limits
+}
+
+// Window returns the context window for model, trying the exact name, then the
+// name without its tag (llama3.1:8b -> llama3.1), then the default.
+func (l ContextLimits) Window(model string) int {
+	if w, ok := l.Windows[model]; ok && w > 0 {
+		return w
+	}
+	if i := strings.Index(model, ":"); i != -1 {
+		if w, ok := l.Windows[model[:i]]; ok && w > 0 {
+			return w
+		}
+	}
+	if w, ok := l.Windows[""]; ok && w > 0 {
+		return w
+	}
+	return DefaultContextWindow
+}
+
+// SetContextLimits sets the context windows used for budgeting requests.
+func (a *Agent) SetContextLimits(limits ContextLimits) {
+	a.limits = limits
+}
+
+// recallMemories returns the memories for the run's turn: the ones most
+// relevant to the user's input if there is a memory index, else the newest.
+// They are looked up once per turn.
+func (a *Agent) recallMemories(ctx context.Context, r *run) []db.Memory {
+	if r.recalled {
+		return r.memories
+	}
+	r.recalled = true
+	var err error
+	if a.memories != nil && r.turnStart < len(r.history) {
+		r.memories, err = a.memories.Recall(ctx, r.history[r.turnStart].Content, memoryLimit)
+	} else {
+		r.memories, err = a.store.SearchMemories("", memoryLimit)
+	}
+	if err != nil {
+		fmt.Printf("[Agent]: Could not load memories: %v\n", err)
+	}
+	return r.memories
+}
+
+// prepareContext builds the messages and tool definitions for the next request,
+// fitting memories, tool docs and history into the model's context window.
+// Old turns that do not fit are summarized (or dropped) from the history for good.
+func (a *Agent) prepareContext(ctx context.Context, r *run, native bool) ([]llm.Message, []llm.ToolDefinition, BudgetReport) {
+	model := r.model
+	report := BudgetReport{Window: a.limits.Window(model)}
+	if n := r.client.GetOptions().NumCtx; n > 0 {
+		// The model was asked for a window of its own
+		report.Window = n
+	}
+	usable := report.Window - a.limits.Reserve
+	if usable < report.Window/2 {
+		usable = report.Window / 2
+	}
+
+	// Memories, most relevant first, up to their share of the window
+	var memories []string
+	if a.store != nil {
+		found := a.recallMemories(ctx, r)
+		spent := 0
+		for i, m := range found {
+			line := fmt.Sprintf("- [%s] %s", m.Type, m.Content)
+			cost := llm.EstimateTokens(model, line)
+			if spent+cost > usable*memoryShare/100 {
+				report.DroppedMemories = len(found) - i
+				break
+			}
+			memories = append(memories, line)
+			spent += cost
+		}
+	}
+
+	// Tools in a stable order, up to their share of the window
+	names := a.offeredTools(ctx, r)
+	var toolDocs []string
+	var defs []llm.ToolDefinition
+	spent := 0
+	for i, name := range names {
+		description, parameters := toolDoc(a.tools[name], name)
+		var cost int
+		if native {
+			def := llm.NewToolDefinition(name, description, parameters)
+			cost = llm.EstimateTools(model, []llm.ToolDefinition{def})
+			if spent+cost > usable*toolShare/100 {
+				report.DroppedTools = names[i:]
+				break
+			}
+			defs = append(defs, def)
+		} else {
+			doc := fmt.Sprintf("- %s: %s", name, description)
+			cost = llm.EstimateTokens(model, doc)
+			if spent+cost > usable*toolShare/100 {
+				report.DroppedTools = names[i:]
+				break
+			}
+			toolDocs = append(toolDocs, doc)
+		}
+		spent += cost
+	}
+
+	prompt := a.buildSystemPrompt(native, memories, toolDocs)
+	system := llm.Message{Role: "system", Content: withSummary(prompt, r.summary)}
+	fixed := llm.EstimateMessages(model, []llm.Message{system}) + llm.EstimateTools(model, defs)
+
+	// History gets whatever is left
+	if a.fitHistory(ctx, r, usable-fixed, &report) {
+		system.Content = withSummary(prompt, r.summary)
+		fixed = llm.EstimateMessages(model, []llm.Message{system}) + llm.EstimateTools(model, defs)
+	}
+
+	messages := append([]llm.Message{system}, r.history...)
+	report.Used = fixed + llm.EstimateMessages(model, r.history)
+	return messages, defs, report
+}
+
+// toolDoc returns the description and parameters of a tool, or of more_tools,
+// which the agent handles itself.
+func toolDoc(t base.Tool, name string) (string, map[string]interface{}) {
+	if t == nil && name == moreToolsName {
+		return moreToolsDescription, map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"query": map[string]interface{}{"type": "string"}},
+			"required":   []string{"query"},
+		}
+	}
+	return t.Description(), toolParameters(t)
+}
+
+// fitHistory shrinks the history to budget tokens. The current turn is always
+// kept; older turns are cut at a user message so tool calls stay paired with
+// their results, and the part that was cut is folded into the run's summary,
+// which replaces it in the store too. It reports whether the summary changed.
+func (a *Agent) fitHistory(ctx context.Context, r *run, budget int, report *BudgetReport) bool {
+	model := r.model
+	if llm.EstimateMessages(model, r.history) <= budget || r.turnStart <= 0 {
+		return false
+	}
+
+	older := r.history[:r.turnStart]
+	current := r.history[r.turnStart:]
+	// Leave room for the summary itself
+	remaining := budget - llm.EstimateMessages(model, current) - budget/10
+
+	cut := len(older)
+	for i := 1; i < len(older); i++ {
+		if older[i].Role != "user" {
+			continue
+		}
+		if llm.EstimateMessages(model, older[i:]) <= remaining {
+			cut = i
+			break
+		}
+	}
+
+	// The summary goes into the system prompt rather than the middle of the
+	// history, where many chat templates drop or reject system messages.
+	segment := older[:cut]
+	if r.summary != "" {
+		segment = append([]llm.Message{{Role: "summary", Content: r.summary}}, segment...)
+	}
+	changed := false
+	summary, err := llm.Summarize(ctx, r.client, segment)
+	if err != nil || strings.TrimSpace(summary) == "" {
+		fmt.Printf("[Agent]: Could not summarize old history, dropping it: %v\n", err)
+		report.DroppedMessages += cut
+	} else {
+		r.summary = strings.TrimSpace(summary)
+		a.saveSummary(r.session, r.history, cut, r.summary)
+		changed = true
+		report.SummarizedMessages += cut
+	}
+	kept := append([]llm.Message(nil), older[cut:]...)
+
+	r.turnStart = len(kept)
+	r.history = append(kept, current...)
+	return changed
+}
+
+// withSummary appends the summary of the cut turns to the system prompt.
+func withSummary(prompt, summary string) string {
+	if summary == "" {
+		return prompt
+	}
+	return prompt + "\n\nSummary of the earlier conversation:\n" + summary
+}
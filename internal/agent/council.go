@@ -4,29 +4,72 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math/rand"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pyromancer/idony/internal/db"
 	"github.com/pyromancer/idony/internal/llm"
+	"github.com/pyromancer/idony/internal/tools/base"
+)
+
+// DefaultCouncilRounds is the number of discussion rounds of a council that does not set its own.
+const DefaultCouncilRounds = 2
+
+const (
+	maxCouncilRounds   = 10
+	maxCouncilOptions  = 5
+	councilTurnTimeout = 5 * time.Minute
+)
+
+// Speaking orders of a council. In a parallel round every member answers the
+// transcript of the previous rounds without seeing the others' contributions.
+const (
+	CouncilOrderSequential = "sequential"
+	CouncilOrderRandom     = "random"
+	CouncilOrderParallel   = "parallel"
 )
 
 type CouncilManager struct {
-	client     *llm.OllamaClient
+	client     llm.Provider
 	store      *db.Store
 	subManager *SubAgentManager
+	stepLimits StepLimits
 }
 
-func NewCouncilManager(client *llm.OllamaClient, store *db.Store, subManager *SubAgentManager) *CouncilManager {
+func NewCouncilManager(client llm.Provider, store *db.Store, subManager *SubAgentManager) *CouncilManager {
 	return &CouncilManager{
 		client:     client,
 		store:      store,
 		subManager: subManager,
+		stepLimits: DefaultCouncilStepLimits,
 	}
 }
 
-func (m *CouncilManager) RunCouncilSession(ctx context.Context, councilName, problem string) (string, error) {
+// SetStepLimits bounds the number of steps and repeated tool calls per council turn.
+func (m *CouncilManager) SetStepLimits(limits StepLimits) {
+	m.stepLimits = limits
+}
+
+// councilVote is a member's structured vote.
+type councilVote struct {
+	Option string `json:"option"`
+	Reason string `json:"reason"`
+}
+
+// councilTally is the outcome of a vote.
+type councilTally struct {
+	Options []string
+	Votes   map[string][]string // Option -> members who voted for it
+	Winners []string            // More than one on a tie
+}
+
+// RunCouncilSession starts a council session on problem in the background and
+// returns its ID. If the council votes, members choose between options, or
+// between options proposed during the discussion if none are given.
+func (m *CouncilManager) RunCouncilSession(ctx context.Context, councilName, problem string, options []string) (string, error) {
 	council, err := m.store.GetCouncil(councilName)
 	if err != nil {
 		return "", err
@@ -50,68 +93,421 @@ func (m *CouncilManager) RunCouncilSession(ctx context.Context, councilName, pro
 
 	id := uuid.New().String()[:8]
 	sessionTitle := fmt.Sprintf("Council '%s' Session: %s", councilName, id)
-	err = m.store.SaveSubAgent(id, sessionTitle, "running", "", "")
+	err = m.store.SaveSubAgent(db.SubAgentTask{ID: id, Prompt: sessionTitle, Status: "running", Origin: base.SessionID(ctx)})
 	if err != nil {
 		return "", err
 	}
 
-	go m.executeCouncilSession(id, councilName, members, problem)
+	go m.executeCouncilSession(id, council, members, problem, options, priorityOf(ctx))
 
 	return id, nil
 }
 
-func (m *CouncilManager) executeCouncilSession(id, councilName string, members []*db.SubAgentDefinition, problem string) {
+func (m *CouncilManager) executeCouncilSession(id string, council *db.Council, members []*db.SubAgentDefinition, problem string, options []string, priority Priority) {
+	councilName := council.Name
 	fmt.Printf("\n[Council %s]: Session Started - %s\n", councilName, problem)
+	// Member and moderator turns wait for a worker of the sub-agent pool at the session's priority
+	sessionCtx, done := m.subManager.runs.start(WithPriority(context.Background(), priority), id, "council", "council:"+councilName, problem)
+	defer done()
+
+	rounds := council.Rounds
+	if rounds <= 0 {
+		rounds = DefaultCouncilRounds
+	}
+
+	// Every member turn is a step, then the vote and the verdict
+	steps := rounds*len(members) + 1
+	if council.Voting {
+		steps++
+	}
+	finished := 0
+	stepDone := func() {
+		finished++
+		m.store.UpdateSubAgentProgress(id, finished*100/steps)
+	}
 
 	var transcript []string
 	transcript = append(transcript, fmt.Sprintf("Council Problem: %s", problem))
+	cancelled := func() {
+		fmt.Printf("\n[Council %s]: Session Cancelled\n", councilName)
+		m.store.UpdateSubAgent(id, "cancelled", strings.Join(transcript, "\n\n---\n\n"))
+		m.subManager.jobFinished(id, "council", "cancelled", transcript[len(transcript)-1])
+	}
 
-	// We'll do 2 rounds of discussion
-	for round := 1; round <= 2; round++ {
-		for _, member := range members {
-			// Update status with progress
-			progress := (round-1)*100/2 + (100 / (2 * len(members)))
-			m.store.UpdateSubAgentProgress(id, progress)
-
-			// Construct a specialized prompt for the member
-			memberPrompt := fmt.Sprintf("You are participating in a council meeting called '%s'.\n"+
-				"The problem we are solving is: %s\n\n"+
-				"Current Discussion Transcript:\n%s\n\n"+
-				"Provide your thoughts or solutions based on your unique personality and expertise.",
-				councilName, problem, strings.Join(transcript, "\n\n"))
-
-			// Create temporary agent for this turn
-			subAgent := &Agent{
-				client:      m.client,
-				tools:       m.subManager.tools,
-				store:       nil,
-				personality: member.Personality,
-				model:       member.Model,
+	consensus := ""
+	for round := 1; round <= rounds; round++ {
+		speakers := speakingOrder(council.Order, members)
+		if council.Order == CouncilOrderParallel {
+			transcript = append(transcript, m.parallelRound(sessionCtx, councilName, problem, transcript, speakers, round, rounds, stepDone)...)
+		} else {
+			for _, member := range speakers {
+				if sessionCtx.Err() != nil {
+					break
+				}
+				if contribution, ok := m.memberTurn(sessionCtx, councilName, problem, transcript, member, round, rounds); ok {
+					transcript = append(transcript, contribution)
+				}
+				stepDone()
 			}
+		}
 
-			fmt.Printf("[Council %s] Member '%s' is thinking...\n", councilName, member.Name)
-			
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-			response, err := subAgent.Run(ctx, memberPrompt)
-			cancel()
+		if sessionCtx.Err() != nil {
+			cancelled()
+			return
+		}
 
-			if err != nil {
-				log.Printf("Error in council turn for %s: %v", member.Name, err)
-				continue
+		if council.StopOnConsensus && round < rounds {
+			if position, ok := m.checkConsensus(sessionCtx, problem, transcript); ok {
+				fmt.Printf("[Council %s]: Consensus reached after round %d\n", councilName, round)
+				consensus = position
+				finished += (rounds - round) * len(members)
+				break
 			}
+		}
+	}
 
-			contribution := fmt.Sprintf("[%s]: %s", member.Name, response)
-			transcript = append(transcript, contribution)
+	var tally *councilTally
+	if council.Voting {
+		tally = m.vote(sessionCtx, councilName, problem, transcript, members, options)
+		stepDone()
+		if sessionCtx.Err() != nil {
+			cancelled()
+			return
 		}
 	}
 
-	finalResult := strings.Join(transcript, "\n\n---\n\n")
-	m.store.UpdateSubAgent(id, "completed", finalResult)
+	decision := m.verdict(sessionCtx, council, problem, transcript, tally, consensus)
+	stepDone()
+	if sessionCtx.Err() != nil {
+		cancelled()
+		return
+	}
+
+	m.store.UpdateSubAgent(id, "completed", formatCouncilResult(decision, tally, consensus, transcript))
+	m.subManager.jobFinished(id, "council", "completed", decision)
 	fmt.Printf("\n[Council %s]: Session Completed\n", councilName)
 }
 
-func (m *CouncilManager) DefineCouncil(name string, members []string) error {
-	return m.store.SaveCouncil(name, strings.Join(members, ","))
+// speakingOrder returns the members in the order they speak in a round.
+func speakingOrder(order string, members []*db.SubAgentDefinition) []*db.SubAgentDefinition {
+	speakers := append([]*db.SubAgentDefinition(nil), members...)
+	if order == CouncilOrderRandom {
+		rand.Shuffle(len(speakers), func(i, j int) { speakers[i], speakers[j] = speakers[j], speakers[i] })
+	}
+	return speakers
+}
+
+// memberTurn has a member contribute to the discussion. It reports false if
+// the member failed to answer.
+func (m *CouncilManager) memberTurn(ctx context.Context, councilName, problem string, transcript []string, member *db.SubAgentDefinition, round, rounds int) (string, bool) {
+	// Construct a specialized prompt for the member
+	memberPrompt := fmt.Sprintf("You are participating in a council meeting called '%s'.\n"+
+		"The problem we are solving is: %s\n\n"+
+		"Current Discussion Transcript:\n%s\n\n"+
+		"This is round %d of %d. Provide your thoughts or solutions based on your unique personality and expertise.",
+		councilName, problem, strings.Join(transcript, "\n\n"), round, rounds)
+
+	// Create temporary agent for this turn
+	subAgent := m.subManager.newAgent(member.Personality, member.Model, member.Options, member.Fallbacks, m.subManager.tools, m.stepLimits)
+	subAgent.name = member.Name
+
+	fmt.Printf("[Council %s] Member '%s' is thinking...\n", councilName, member.Name)
+
+	response, err := m.turn(ctx, func(ctx context.Context) (string, error) {
+		return subAgent.RunStream(ctx, "council:"+councilName, memberPrompt, nil, nil)
+	})
+
+	if err != nil {
+		log.Printf("Error in council turn for %s: %v", member.Name, err)
+		return "", false
+	}
+	return fmt.Sprintf("[%s]: %s", member.Name, response), true
+}
+
+// turn runs one LLM call of a session once the sub-agent pool has a worker for
+// it, bounded by councilTurnTimeout.
+func (m *CouncilManager) turn(ctx context.Context, fn func(context.Context) (string, error)) (string, error) {
+	return m.subManager.runPooled(ctx, priorityOf(ctx), councilTurnTimeout, fn)
+}
+
+// parallelRound has all members answer the same transcript at once. Their
+// contributions are returned in speaking order.
+func (m *CouncilManager) parallelRound(ctx context.Context, councilName, problem string, transcript []string, speakers []*db.SubAgentDefinition, round, rounds int, stepDone func()) []string {
+	type turn struct {
+		index        int
+		contribution string
+		ok           bool
+	}
+	results := make(chan turn, len(speakers))
+	for i, member := range speakers {
+		go func(i int, member *db.SubAgentDefinition) {
+			contribution, ok := m.memberTurn(ctx, councilName, problem, transcript, member, round, rounds)
+			results <- turn{i, contribution, ok}
+		}(i, member)
+	}
+
+	contributions := make([]string, len(speakers))
+	for range speakers {
+		t := <-results
+		if t.ok {
+			contributions[t.index] = t.contribution
+		}
+		stepDone()
+	}
+
+	var spoken []string
+	for _, c := range contributions {
+		if c != "" {
+			spoken = append(spoken, c)
+		}
+	}
+	return spoken
+}
+
+var councilConsensusSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"consensus": map[string]interface{}{"type": "boolean"},
+		"position":  map[string]interface{}{"type": "string"},
+	},
+	"required": []string{"consensus", "position"},
+}
+
+// checkConsensus asks whether the members agree, and on what.
+func (m *CouncilManager) checkConsensus(ctx context.Context, problem string, transcript []string) (string, bool) {
+	prompt := fmt.Sprintf("Below is the transcript of a discussion between AI agents about this problem: %s\n\n%s\n\n"+
+		"Do all members now support the same answer? Set consensus to true only if every member agrees; "+
+		"position is that shared answer in one or two sentences, or empty.",
+		problem, strings.Join(transcript, "\n\n"))
+
+	var out struct {
+		Consensus bool   `json:"consensus"`
+		Position  string `json:"position"`
+	}
+	_, err := m.turn(ctx, func(ctx context.Context) (string, error) {
+		return llm.GenerateStructured(ctx, m.client, []llm.Message{{Role: "user", Content: prompt}}, llm.Structured{Schema: councilConsensusSchema}, &out)
+	})
+	if err != nil {
+		log.Printf("Error checking council consensus: %v", err)
+		return "", false
+	}
+	return out.Position, out.Consensus && strings.TrimSpace(out.Position) != ""
+}
+
+var councilOptionsSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"options": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+	},
+	"required": []string{"options"},
+}
+
+// proposedOptions extracts the distinct options put forward in the discussion.
+func (m *CouncilManager) proposedOptions(ctx context.Context, problem string, transcript []string) ([]string, error) {
+	prompt := fmt.Sprintf("Below is the transcript of a discussion between AI agents about this problem: %s\n\n%s\n\n"+
+		"List the distinct options the members proposed, 2 to %d of them, each as a short self-contained statement.",
+		problem, strings.Join(transcript, "\n\n"), maxCouncilOptions)
+
+	var out struct {
+		Options []string `json:"options"`
+	}
+	_, err := m.turn(ctx, func(ctx context.Context) (string, error) {
+		return llm.GenerateStructured(ctx, m.client, []llm.Message{{Role: "user", Content: prompt}}, llm.Structured{
+			Schema: councilOptionsSchema,
+			Validate: func() error {
+				if len(out.Options) < 2 || len(out.Options) > maxCouncilOptions {
+					return fmt.Errorf("give between 2 and %d options, got %d", maxCouncilOptions, len(out.Options))
+				}
+				return nil
+			},
+		}, &out)
+	})
+	return out.Options, err
+}
+
+// vote has every member choose one of the options. It returns nil if there is
+// nothing to vote on.
+func (m *CouncilManager) vote(ctx context.Context, councilName, problem string, transcript []string, members []*db.SubAgentDefinition, options []string) *councilTally {
+	if len(options) == 0 {
+		proposed, err := m.proposedOptions(ctx, problem, transcript)
+		if err != nil {
+			log.Printf("Error collecting options for council %s: %v", councilName, err)
+			return nil
+		}
+		options = proposed
+	}
+	if len(options) < 2 {
+		return nil
+	}
+
+	tally := &councilTally{Options: options, Votes: make(map[string][]string)}
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"option": map[string]interface{}{"type": "string", "enum": options},
+			"reason": map[string]interface{}{"type": "string"},
+		},
+		"required": []string{"option", "reason"},
+	}
+	prompt := fmt.Sprintf("You took part in a council meeting called '%s' about this problem: %s\n\nTranscript:\n%s\n\n"+
+		"Vote for exactly one of these options and give a one-sentence reason:\n- %s",
+		councilName, problem, strings.Join(transcript, "\n\n"), strings.Join(options, "\n- "))
+
+	for _, member := range members {
+		if ctx.Err() != nil {
+			break
+		}
+		client := m.client.WithOptions(parseOptions(member.Options))
+		if member.Model != "" {
+			client = client.WithModel(member.Model)
+		}
+		if fallbacks := parseFallbacks(member.Fallbacks); fallbacks != nil {
+			client = llm.WithFallbacks(client, fallbacks)
+		}
+		var v councilVote
+		_, err := m.turn(ctx, func(ctx context.Context) (string, error) {
+			return llm.GenerateStructured(ctx, client, []llm.Message{
+				{Role: "system", Content: member.Personality},
+				{Role: "user", Content: prompt},
+			}, llm.Structured{
+				Schema: schema,
+				Validate: func() error {
+					for _, o := range options {
+						if v.Option == o {
+							return nil
+						}
+					}
+					return fmt.Errorf("option must be one of the listed options, got %q", v.Option)
+				},
+			}, &v)
+		})
+		if err != nil {
+			log.Printf("Error getting the vote of %s: %v", member.Name, err)
+			continue
+		}
+		fmt.Printf("[Council %s] Member '%s' votes for: %s\n", councilName, member.Name, v.Option)
+		tally.Votes[v.Option] = append(tally.Votes[v.Option], member.Name)
+	}
+
+	best := 0
+	for _, o := range options {
+		switch n := len(tally.Votes[o]); {
+		case n > best:
+			best = n
+			tally.Winners = []string{o}
+		case n == best && n > 0:
+			tally.Winners = append(tally.Winners, o)
+		}
+	}
+	return tally
+}
+
+// verdict sums up the session into a concise decision. The moderator, if the
+// council has one, weighs the discussion, vote and consensus; without one, a
+// clear vote or consensus is the decision.
+func (m *CouncilManager) verdict(ctx context.Context, council *db.Council, problem string, transcript []string, tally *councilTally, consensus string) string {
+	var moderator *db.SubAgentDefinition
+	if council.Moderator != "" {
+		moderator, _ = m.store.GetSubAgentDefinition(council.Moderator)
+		if moderator == nil {
+			log.Printf("Moderator '%s' of council %s not found", council.Moderator, council.Name)
+		}
+	}
+
+	if moderator == nil {
+		if tally != nil && len(tally.Winners) == 1 {
+			return tally.Winners[0]
+		}
+		if consensus != "" {
+			return consensus
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "A council of AI agents discussed this problem: %s\n\nTranscript:\n%s\n\n", problem, strings.Join(transcript, "\n\n"))
+	if tally != nil {
+		fmt.Fprintf(&b, "Vote results:\n%s\n", formatCouncilTally(tally))
+	}
+	if consensus != "" {
+		fmt.Fprintf(&b, "The members reached consensus: %s\n\n", consensus)
+	}
+	b.WriteString("Give the council's final decision in a few sentences: what was decided and the main reasons. Do not repeat the discussion.")
+
+	var decision string
+	var err error
+	if moderator != nil {
+		fmt.Printf("[Council %s] Moderator '%s' is writing the verdict...\n", council.Name, moderator.Name)
+		mod := m.subManager.newAgent(moderator.Personality, moderator.Model, moderator.Options, moderator.Fallbacks, map[string]base.Tool{}, m.stepLimits)
+		mod.name = moderator.Name
+		decision, err = m.turn(ctx, func(ctx context.Context) (string, error) {
+			return mod.RunStream(ctx, "council:"+council.Name, b.String(), nil, nil)
+		})
+	} else {
+		decision, err = m.turn(ctx, func(ctx context.Context) (string, error) {
+			return m.client.GenerateResponse(ctx, []llm.Message{{Role: "user", Content: b.String()}})
+		})
+	}
+	if err != nil || strings.TrimSpace(decision) == "" {
+		log.Printf("Error writing the verdict of council %s: %v", council.Name, err)
+		// The last contribution usually sums up the discussion
+		return transcript[len(transcript)-1]
+	}
+	return decision
+}
+
+func formatCouncilTally(tally *councilTally) string {
+	var b strings.Builder
+	options := append([]string(nil), tally.Options...)
+	sort.SliceStable(options, func(i, j int) bool { return len(tally.Votes[options[i]]) > len(tally.Votes[options[j]]) })
+	for _, o := range options {
+		voters := tally.Votes[o]
+		fmt.Fprintf(&b, "- %s: %d", o, len(voters))
+		if len(voters) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(voters, ", "))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// formatCouncilResult puts the decision first, then the vote and the full transcript.
+func formatCouncilResult(decision string, tally *councilTally, consensus string, transcript []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Decision:\n%s\n\n", decision)
+	if tally != nil {
+		fmt.Fprintf(&b, "Votes:\n%s\n", formatCouncilTally(tally))
+	}
+	if consensus != "" {
+		fmt.Fprintf(&b, "Consensus: %s\n\n", consensus)
+	}
+	b.WriteString("Transcript:\n\n")
+	b.WriteString(strings.Join(transcript, "\n\n---\n\n"))
+	return b.String()
+}
+
+// DefineCouncil creates or replaces a council after checking its settings.
+func (m *CouncilManager) DefineCouncil(council db.Council) error {
+	if council.Name == "" || council.Members == "" {
+		return fmt.Errorf("name and members are required")
+	}
+	if council.Rounds < 0 || council.Rounds > maxCouncilRounds {
+		return fmt.Errorf("rounds must be between 1 and %d", maxCouncilRounds)
+	}
+	switch council.Order {
+	case "", CouncilOrderSequential, CouncilOrderRandom, CouncilOrderParallel:
+	default:
+		return fmt.Errorf("invalid speaking order %q: use sequential, random or parallel", council.Order)
+	}
+	if council.Moderator != "" {
+		def, err := m.store.GetSubAgentDefinition(council.Moderator)
+		if err != nil {
+			return err
+		}
+		if def == nil {
+			return fmt.Errorf("moderator '%s' is not a defined sub-agent", council.Moderator)
+		}
+	}
+	return m.store.SaveCouncil(council)
 }
 
 func (m *CouncilManager) ListCouncils() ([]db.Council, error) {
@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pyromancer/idony/internal/db"
+)
+
+// DefaultAgentMessageMaxDepth is how many messages a conversation between
+// agents may follow from its first one before further messages are refused.
+const DefaultAgentMessageMaxDepth = 6
+
+// inboxPollInterval is how often the dispatcher looks for messages written
+// without going through it, e.g. by another process sharing the database.
+const inboxPollInterval = 5 * time.Second
+
+type inboxMessageKey struct{}
+
+// Inbox delivers messages between agents. A message to an agent that has a
+// sub-agent definition activates that agent with the message and its
+// conversation as input; its answer goes back to the sender as a reply.
+// Messages to "main" and to unknown agents stay unread for check_inbox.
+//
+// Answers to replies are not sent automatically, so two agents do not keep
+// answering each other; an agent that wants to go on replies with send_message.
+// Conversations are also cut off after a maximum number of messages.
+type Inbox struct {
+	store      *db.Store
+	subManager *SubAgentManager
+	maxDepth   int
+	wake       chan struct{}
+}
+
+func NewInbox(store *db.Store, subManager *SubAgentManager) *Inbox {
+	return &Inbox{
+		store:      store,
+		subManager: subManager,
+		maxDepth:   DefaultAgentMessageMaxDepth,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// SetMaxDepth bounds the number of messages a conversation between agents may have.
+func (i *Inbox) SetMaxDepth(n int) {
+	if n > 0 {
+		i.maxDepth = n
+	}
+}
+
+// Send stores a message from one agent to another and wakes the dispatcher.
+// Messages sent while an agent handles a message continue its conversation.
+func (i *Inbox) Send(ctx context.Context, from, to, content string, inReplyTo int) (int, error) {
+	to = strings.TrimSpace(to)
+	if to == "" {
+		return 0, fmt.Errorf("recipient is required")
+	}
+	if to == from {
+		return 0, fmt.Errorf("agent '%s' cannot send a message to itself", from)
+	}
+
+	msg := db.AgentMessage{From: from, To: to, Content: content, InReplyTo: inReplyTo}
+	if inReplyTo > 0 {
+		parent, err := i.store.GetAgentMessage(inReplyTo)
+		if err != nil {
+			return 0, err
+		}
+		if parent == nil {
+			return 0, fmt.Errorf("message %d not found", inReplyTo)
+		}
+		msg.Depth = parent.Depth + 1
+	}
+	if handling, ok := ctx.Value(inboxMessageKey{}).(db.AgentMessage); ok {
+		if inReplyTo == 0 {
+			msg.ThreadID = handling.ThreadID
+		}
+		if handling.Depth+1 > msg.Depth {
+			msg.Depth = handling.Depth + 1
+		}
+	}
+	if msg.Depth >= i.maxDepth {
+		return 0, fmt.Errorf("the conversation has reached its limit of %d messages; answer without messaging other agents", i.maxDepth)
+	}
+
+	id, err := i.store.SaveAgentMessage(msg)
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case i.wake <- struct{}{}:
+	default:
+	}
+	return id, nil
+}
+
+// Start dispatches new messages until ctx is done. Messages written before it
+// starts are left to check_inbox.
+func (i *Inbox) Start(ctx context.Context) {
+	last, err := i.store.LastAgentMessageID()
+	if err != nil {
+		fmt.Printf("[Inbox]: Could not read agent messages: %v\n", err)
+		return
+	}
+
+	ticker := time.NewTicker(inboxPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-i.wake:
+		}
+
+		msgs, err := i.store.GetAgentMessagesAfter(last)
+		if err != nil {
+			fmt.Printf("[Inbox]: Could not read agent messages: %v\n", err)
+			continue
+		}
+		for _, msg := range msgs {
+			last = msg.ID
+			if msg.Read {
+				continue
+			}
+			def, err := i.store.GetSubAgentDefinition(msg.To)
+			if err != nil || def == nil {
+				continue
+			}
+			if err := i.store.MarkAgentMessageRead(msg.ID); err != nil {
+				fmt.Printf("[Inbox]: Could not mark message %d read: %v\n", msg.ID, err)
+				continue
+			}
+			go i.dispatch(ctx, msg)
+		}
+	}
+}
+
+// dispatch runs the recipient of msg on it and sends its answer back as a reply.
+func (i *Inbox) dispatch(ctx context.Context, msg db.AgentMessage) {
+	thread, err := i.store.GetAgentThread(msg.ThreadID)
+	if err != nil {
+		// The message itself is enough to go on
+		fmt.Printf("[Inbox]: Could not load thread %d: %v\n", msg.ThreadID, err)
+	}
+	autoReply := msg.InReplyTo == 0
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "You received message #%d from agent '%s'.\n\n", msg.ID, msg.From)
+	if len(thread) > 1 {
+		sb.WriteString("Conversation so far:\n")
+		for _, m := range thread {
+			if m.ID == msg.ID {
+				continue
+			}
+			fmt.Fprintf(&sb, "#%d %s -> %s: %s\n", m.ID, m.From, m.To, m.Content)
+		}
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(&sb, "Message:\n%s\n\n", msg.Content)
+	if autoReply {
+		fmt.Fprintf(&sb, "Your final answer is sent to '%s' as the reply.", msg.From)
+	} else {
+		fmt.Fprintf(&sb, "This answers an earlier message. Your final answer is not sent anywhere; to reply, use send_message with in_reply_to %d.", msg.ID)
+	}
+
+	// Messages between agents are background work
+	ctx = context.WithValue(WithPriority(ctx, PriorityScheduled), inboxMessageKey{}, msg)
+	sessionID := fmt.Sprintf("inbox:%s:%d", msg.To, msg.ThreadID)
+	fmt.Printf("[Inbox]: Delivering message %d from %s to %s\n", msg.ID, msg.From, msg.To)
+	answer, err := i.subManager.RunNamed(ctx, msg.To, sessionID, sb.String())
+	if err != nil {
+		fmt.Printf("[Inbox]: Agent %s failed on message %d: %v\n", msg.To, msg.ID, err)
+		return
+	}
+	if !autoReply || strings.TrimSpace(answer) == "" {
+		return
+	}
+	if _, err := i.Send(ctx, msg.To, msg.From, answer, msg.ID); err != nil {
+		fmt.Printf("[Inbox]: Not replying to message %d: %v\n", msg.ID, err)
+	}
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Default step limits for the main agent, sub-agents and council members.
+var (
+	DefaultStepLimits         = StepLimits{MaxSteps: 20, MaxRepeats: 3, MaxParallelTools: 4}
+	DefaultSubAgentStepLimits = StepLimits{MaxSteps: 15, MaxRepeats: 3, MaxParallelTools: 4}
+	DefaultCouncilStepLimits  = StepLimits{MaxSteps: 8, MaxRepeats: 2, MaxParallelTools: 2}
+)
+
+// StepLimits bounds a single agent run.
+type StepLimits struct {
+	MaxSteps         int // LLM round trips per run
+	MaxRepeats       int // Identical tool calls (same tool and input) allowed per run
+	MaxParallelTools int // Tool calls of one step executed at the same time
+}
+
+// ParseStepLimits reads <prefix>MAX_STEPS, <prefix>MAX_REPEATED_CALLS and
+// <prefix>MAX_PARALLEL_TOOLS from the given settings, falling back to def for
+// missing or invalid values.
+func ParseStepLimits(settings map[string]string, prefix string, def StepLimits) StepLimits {
+	limits := def
+	if n, err := strconv.Atoi(strings.TrimSpace(settings[prefix+"MAX_STEPS"])); err == nil && n > 0 {
+		limits.MaxSteps = n
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(settings[prefix+"MAX_REPEATED_CALLS"])); err == nil && n > 0 {
+		limits.MaxRepeats = n
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(settings[prefix+"MAX_PARALLEL_TOOLS"])); err == nil && n > 0 {
+		limits.MaxParallelTools = n
+	}
+	return limits
+}
+
+// AbortReason identifies why a run was stopped before producing an answer.
+type AbortReason string
+
+const (
+	AbortStepLimit    AbortReason = "step_limit"
+	AbortRepeatedCall AbortReason = "repeated_call"
+)
+
+// AbortError is returned by Run when the agent loop is stopped by its limits.
+type AbortError struct {
+	Reason AbortReason
+	Tool   string // Tool that was called repeatedly, for AbortRepeatedCall
+	Steps  int    // Steps taken before aborting
+}
+
+func (e *AbortError) Error() string {
+	switch e.Reason {
+	case AbortStepLimit:
+		return fmt.Sprintf("step limit reached after %d steps", e.Steps)
+	case AbortRepeatedCall:
+		return fmt.Sprintf("repeated call to %s", e.Tool)
+	}
+	return string(e.Reason)
+}
+
+// runGuard enforces StepLimits over one run of the agent loop.
+type runGuard struct {
+	limits StepLimits
+	steps  int
+	calls  map[string]int
+}
+
+func newRunGuard(limits StepLimits) *runGuard {
+	return &runGuard{limits: limits, calls: make(map[string]int)}
+}
+
+// step records an LLM round trip and fails once the step budget is spent.
+func (g *runGuard) step() error {
+	if g.limits.MaxSteps > 0 && g.steps >= g.limits.MaxSteps {
+		return &AbortError{Reason: AbortStepLimit, Steps: g.steps}
+	}
+	g.steps++
+	return nil
+}
+
+// call records a tool call and fails when the same call was made too often.
+func (g *runGuard) call(tool, input string) error {
+	key := tool + "\x00" + strings.TrimSpace(input)
+	g.calls[key]++
+	if g.limits.MaxRepeats > 0 && g.calls[key] > g.limits.MaxRepeats {
+		return &AbortError{Reason: AbortRepeatedCall, Tool: tool, Steps: g.steps}
+	}
+	return nil
+}
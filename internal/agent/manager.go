@@ -2,6 +2,7 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -15,35 +16,185 @@ import (
 )
 
 type SubAgentManager struct {
-	client *llm.OllamaClient
-	store  *db.Store
-	tools  map[string]base.Tool
-	mu     sync.Mutex
+	client     llm.Provider
+	store      *db.Store
+	tools      map[string]base.Tool
+	limits     ContextLimits
+	stepLimits StepLimits
+	approvals  *ApprovalManager
+	runs       *RunRegistry
+	pool       *workerPool
+	notifier   *Notifier     // Optional; reports finished jobs to the session that started them
+	timeout    time.Duration // Run timeout for definitions without their own
+	mu         sync.Mutex
+	live       map[string]*liveSubAgent // Sub-agents with a turn running or queued, by ID
+	settingsMu sync.Mutex               // Guards model and baseURL
+	model      string                   // Set with SetModel; used by agents without a model of their own
+	baseURL    string                   // Set with SetBaseURL; "" keeps the client's
 }
 
-func NewSubAgentManager(client *llm.OllamaClient, store *db.Store, tools map[string]base.Tool) *SubAgentManager {
+// DefaultSubAgentTimeout bounds a sub-agent run when its definition sets no timeout.
+const DefaultSubAgentTimeout = 10 * time.Minute
+
+// liveSubAgent is a sub-agent with turns in progress. Its turns share the agent,
+// so a follow-up sent while it is busy waits in the agent's session queue, and
+// the run handle, so cancelling the sub-agent stops them all.
+type liveSubAgent struct {
+	agent *Agent
+	ctx   context.Context
+	done  func()
+	turns int
+	turn  sync.Mutex // Held by the turn in progress
+}
+
+// subAgentSession is the session a sub-agent's conversation is persisted in.
+func subAgentSession(id string) string {
+	return "subagent:" + id
+}
+
+func NewSubAgentManager(client llm.Provider, store *db.Store, tools map[string]base.Tool) *SubAgentManager {
 	return &SubAgentManager{
-		client: client,
-		store:  store,
-		tools:  tools,
+		client:     client,
+		store:      store,
+		tools:      tools,
+		limits:     ContextLimits{Reserve: DefaultContextReserve},
+		stepLimits: DefaultSubAgentStepLimits,
+		runs:       NewRunRegistry(),
+		pool:       newWorkerPool(DefaultMaxConcurrentSubAgents),
+		timeout:    DefaultSubAgentTimeout,
+		live:       make(map[string]*liveSubAgent),
+	}
+}
+
+// SetModel sets the model of sub-agents and council members whose definition
+// names none, e.g. after MODEL changed in the config. Running ones keep theirs.
+func (m *SubAgentManager) SetModel(model string) {
+	m.settingsMu.Lock()
+	defer m.settingsMu.Unlock()
+	m.model = model
+}
+
+// SetBaseURL points sub-agents and council members started from now on at a
+// different server.
+func (m *SubAgentManager) SetBaseURL(url string) {
+	m.settingsMu.Lock()
+	defer m.settingsMu.Unlock()
+	m.baseURL = url
+}
+
+// SetRuns shares a run registry (usually the main agent's) so sub-agents and
+// councils are listed and cancelled together with chat runs.
+func (m *SubAgentManager) SetRuns(runs *RunRegistry) {
+	m.runs = runs
+}
+
+// Cancel stops a running sub-agent, council session or swarm, the runs listed
+// as sub-agent tasks. Other runs of a shared registry, e.g. chat turns, are
+// left alone.
+func (m *SubAgentManager) Cancel(id string) error {
+	return m.runs.cancelKind(id, "subagent", "council", "swarm")
+}
+
+// SetMaxConcurrent sets how many sub-agent runs may be in progress at the same
+// time. Further runs are queued by priority. Call it before starting any.
+func (m *SubAgentManager) SetMaxConcurrent(n int) {
+	m.pool = newWorkerPool(n)
+}
+
+// SetTimeout sets the run timeout of sub-agents whose definition has none.
+func (m *SubAgentManager) SetTimeout(d time.Duration) {
+	if d > 0 {
+		m.timeout = d
+	}
+}
+
+// SetNotifier reports finished sub-agents, councils and swarms to the sessions that started them.
+func (m *SubAgentManager) SetNotifier(n *Notifier) {
+	m.notifier = n
+}
+
+// jobFinished passes a finished job to the notifier, if there is one.
+func (m *SubAgentManager) jobFinished(id, kind, status, summary string) {
+	if m.notifier != nil {
+		go m.notifier.jobFinished(id, kind, status, summary)
+	}
+}
+
+// PoolStats returns the number of sub-agent runs in progress and waiting for a worker.
+func (m *SubAgentManager) PoolStats() (running, queued int) {
+	return m.pool.stats()
+}
+
+// runTimeout returns the timeout of a definition's runs, or the default.
+func (m *SubAgentManager) runTimeout(spec string) time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(spec)); err == nil && d > 0 {
+		return d
+	}
+	return m.timeout
+}
+
+// SetStepLimits bounds the number of steps and repeated tool calls per sub-agent run.
+func (m *SubAgentManager) SetStepLimits(limits StepLimits) {
+	m.stepLimits = limits
+}
+
+// SetContextLimits sets the context windows used by sub-agents and council members.
+func (m *SubAgentManager) SetContextLimits(limits ContextLimits) {
+	m.limits = limits
+}
+
+// SetApprovals makes sub-agents and council members ask for approval like the main agent.
+func (m *SubAgentManager) SetApprovals(approvals *ApprovalManager) {
+	m.approvals = approvals
+}
+
+// newAgent creates a throwaway agent for a sub-agent or council member. Its
+// history is not persisted, but its runs are traced like the main agent's.
+func (m *SubAgentManager) newAgent(personality, model, options, fallbacks string, tools map[string]base.Tool, stepLimits StepLimits) *Agent {
+	m.settingsMu.Lock()
+	if model == "" {
+		model = m.model
 	}
+	baseURL := m.baseURL
+	m.settingsMu.Unlock()
+
+	a := NewAgent(m.client, nil)
+	a.tools = tools
+	a.personality = personality
+	a.model = model
+	a.baseURL = baseURL
+	a.options = parseOptions(options)
+	a.fallbacks = parseFallbacks(fallbacks)
+	a.limits = m.limits
+	a.stepLimits = stepLimits
+	a.approvals = m.approvals
+	a.traces = m.store
+	return a
 }
 
+// Spawn starts a sub-agent with the default personality, model and tools. Model
+// options set with llm.WithCallOptions apply to it.
 func (m *SubAgentManager) Spawn(ctx context.Context, prompt string, images []string) (string, error) {
+	ctx, callOptions := llm.TakeCallOptions(ctx)
+	options := callOptions.String()
 	id := uuid.New().String()[:8] // Short ID for convenience
-	err := m.store.SaveSubAgent(id, prompt, "running", "", "")
+	err := m.store.SaveSubAgent(db.SubAgentTask{ID: id, Prompt: prompt, Status: "queued", Origin: base.SessionID(ctx), Options: options})
 	if err != nil {
 		return "", err
 	}
 
 	// Run in background with default personality and model
-	fmt.Printf("[SubAgentManager]: Spawning generic sub-agent %s for prompt: %s (Images: %d)\n", id, prompt, len(images))
-	go m.runSubAgent(id, prompt, images, "", "", nil)
+	priority := priorityOf(ctx)
+	fmt.Printf("[SubAgentManager]: Spawning generic sub-agent %s for prompt: %s (Images: %d, Priority: %s)\n", id, prompt, len(images), priority)
+	go m.runSubAgent(id, prompt, images, "", "", "", options, "", nil, priority, m.timeout)
 
 	return id, nil
 }
 
+// SpawnNamed starts a sub-agent from its definition. Model options set with
+// llm.WithCallOptions override those of the definition.
 func (m *SubAgentManager) SpawnNamed(ctx context.Context, agentName, prompt string, images []string) (string, error) {
+	ctx, callOptions := llm.TakeCallOptions(ctx)
 	def, err := m.store.GetSubAgentDefinition(agentName)
 	if err != nil {
 		return "", err
@@ -51,64 +202,175 @@ func (m *SubAgentManager) SpawnNamed(ctx context.Context, agentName, prompt stri
 	if def == nil {
 		return "", fmt.Errorf("sub-agent definition for '%s' not found", agentName)
 	}
+	options := parseOptions(def.Options).Merge(callOptions).String()
 
 	id := uuid.New().String()[:8]
-	err = m.store.SaveSubAgent(id, fmt.Sprintf("[%s]: %s", agentName, prompt), "running", def.Model, def.Personality)
+	err = m.store.SaveSubAgent(db.SubAgentTask{
+		ID:          id,
+		Prompt:      fmt.Sprintf("[%s]: %s", agentName, prompt),
+		Status:      "queued",
+		Model:       def.Model,
+		Personality: def.Personality,
+		Tools:       def.Tools,
+		Timeout:     def.Timeout,
+		Origin:      base.SessionID(ctx),
+		Options:     options,
+		Fallbacks:   def.Fallbacks,
+	})
 	if err != nil {
 		return "", err
 	}
 
-	// Filter tools if specified
-	var allowedTools map[string]base.Tool
-	if def.Tools != "" && def.Tools != "*" {
-		allowedTools = make(map[string]base.Tool)
-		toolList := strings.Split(def.Tools, ",")
-		for _, tn := range toolList {
-			tn = strings.TrimSpace(tn)
-			if t, ok := m.tools[tn]; ok {
-				allowedTools[tn] = t
-			}
+	allowedTools := m.definitionTools(def)
+
+	priority := priorityOf(ctx)
+	fmt.Printf("[SubAgentManager]: Spawning named sub-agent %s (%s) for prompt: %s (Images: %d, Priority: %s)\n", id, agentName, prompt, len(images), priority)
+	go m.runSubAgent(id, prompt, images, def.Name, def.Personality, def.Model, options, def.Fallbacks, allowedTools, priority, m.runTimeout(def.Timeout))
+
+	return id, nil
+}
+
+// definitionTools returns the tools a sub-agent definition may use.
+func (m *SubAgentManager) definitionTools(def *db.SubAgentDefinition) map[string]base.Tool {
+	return m.toolSet(def.Tools)
+}
+
+// toolSet resolves a comma-separated list of tool names; "" and "*" mean all tools.
+func (m *SubAgentManager) toolSet(names string) map[string]base.Tool {
+	if names == "" || names == "*" {
+		return m.tools
+	}
+	allowedTools := make(map[string]base.Tool)
+	for _, tn := range strings.Split(names, ",") {
+		tn = strings.TrimSpace(tn)
+		if t, ok := m.tools[tn]; ok {
+			allowedTools[tn] = t
 		}
-	} else {
-		allowedTools = m.tools
 	}
+	return allowedTools
+}
+
+// RunNamed runs a defined sub-agent on prompt and waits for its answer. Unlike
+// SpawnNamed it does not record a sub-agent task; it is meant for workflows
+// that track their own progress. The run still waits for a worker of the pool.
+func (m *SubAgentManager) RunNamed(ctx context.Context, agentName, sessionID, prompt string) (string, error) {
+	def, err := m.store.GetSubAgentDefinition(agentName)
+	if err != nil {
+		return "", err
+	}
+	if def == nil {
+		return "", fmt.Errorf("sub-agent definition for '%s' not found", agentName)
+	}
+	subAgent := m.newAgent(def.Personality, def.Model, def.Options, def.Fallbacks, m.definitionTools(def), m.stepLimits)
+	subAgent.name = def.Name
+	return m.runPooled(ctx, priorityOf(ctx), m.runTimeout(def.Timeout), func(ctx context.Context) (string, error) {
+		return subAgent.RunStream(ctx, sessionID, prompt, nil, nil)
+	})
+}
 
-	fmt.Printf("[SubAgentManager]: Spawning named sub-agent %s (%s) for prompt: %s (Images: %d)\n", id, agentName, prompt, len(images))
-	go m.runSubAgent(id, prompt, images, def.Personality, def.Model, allowedTools)
+// runPooled runs fn once the pool has a worker for it, bounded by timeout.
+func (m *SubAgentManager) runPooled(ctx context.Context, priority Priority, timeout time.Duration, fn func(context.Context) (string, error)) (string, error) {
+	release, err := m.pool.acquire(ctx, priority)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			err = ErrRunCancelled
+		}
+		return "", err
+	}
+	defer release()
 
-	return id, nil
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	result, err := fn(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	return result, err
 }
 
-func (m *SubAgentManager) runSubAgent(id, prompt string, images []string, personality, model string, tools map[string]base.Tool) {
+func (m *SubAgentManager) runSubAgent(id, prompt string, images []string, name, personality, model, options, fallbacks string, tools map[string]base.Tool, priority Priority, timeout time.Duration) {
 	fmt.Printf("[SubAgent %s]: Starting runSubAgent (Model: %s, Personality: %s)\n", id, model, personality)
 	// Create a fresh agent for this task
 	if tools == nil {
 		tools = m.tools
 	}
 
-	subAgent := &Agent{
-		client:      m.client,
-		tools:       tools,
-		store:       nil, 
-		personality: personality,
-		model:       model,
+	subAgent := m.newAgent(personality, model, options, fallbacks, tools, m.stepLimits)
+	subAgent.name = name
+	// Keep the conversation so the sub-agent can be sent follow-ups
+	subAgent.history = m.store
+
+	fmt.Printf("[SubAgent %s]: Queued with %d images\n", id, len(images))
+	m.runTurn(id, subAgent, prompt, images, priority, timeout)
+}
+
+// Continue sends a follow-up message to a sub-agent, which answers it in the
+// background with its earlier conversation as context. A sub-agent that is
+// still running answers once its current turn is done.
+func (m *SubAgentManager) Continue(ctx context.Context, id, prompt string, images []string) error {
+	task, err := m.store.GetSubAgent(id)
+	if err != nil {
+		return err
+	}
+	if task == nil {
+		return fmt.Errorf("sub-agent %s not found", id)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
+	m.mu.Lock()
+	l, isLive := m.live[id]
+	m.mu.Unlock()
+
+	var subAgent *Agent
+	if isLive {
+		subAgent = l.agent
+	} else {
+		// Councils, swarms and sub-agents from before histories were kept have no conversation
+		msgs, err := m.store.LoadLastMessages(subAgentSession(id), 1)
+		if err != nil {
+			return err
+		}
+		if len(msgs) == 0 {
+			return fmt.Errorf("sub-agent %s has no conversation to continue", id)
+		}
+		subAgent = m.newAgent(task.Personality, task.Model, task.Options, task.Fallbacks, m.toolSet(task.Tools), m.stepLimits)
+		subAgent.history = m.store
+	}
 
-	var result string
-	var err error
-	if len(images) > 0 {
-		fmt.Printf("[SubAgent %s]: Using RunVision with %d images\n", id, len(images))
-		result, err = subAgent.RunVision(ctx, prompt, images)
+	// The answer is reported to whoever asked the follow-up. A live sub-agent
+	// keeps its status; it is reopened when its current turn is over.
+	if isLive {
+		err = m.store.SetSubAgentOrigin(id, base.SessionID(ctx))
 	} else {
-		fmt.Printf("[SubAgent %s]: Using standard Run\n", id, prompt)
-		result, err = subAgent.Run(ctx, prompt)
+		err = m.store.ReopenSubAgent(id, base.SessionID(ctx))
 	}
+	if err != nil {
+		return err
+	}
+	priority := priorityOf(ctx)
+	fmt.Printf("[SubAgentManager]: Follow-up for sub-agent %s: %s (Images: %d, Priority: %s)\n", id, prompt, len(images), priority)
+	go m.runTurn(id, subAgent, prompt, images, priority, m.runTimeout(task.Timeout))
+	return nil
+}
+
+// runTurn runs one turn of a sub-agent's conversation once the pool has a
+// worker for it, and records its result.
+func (m *SubAgentManager) runTurn(id string, subAgent *Agent, prompt string, images []string, priority Priority, timeout time.Duration) {
+	l := m.acquireLive(id, subAgent, prompt)
+
+	// Turns of a sub-agent run in order; only the one in progress holds a worker
+	l.turn.Lock()
+	result, err := m.runPooled(l.ctx, priority, timeout, func(ctx context.Context) (string, error) {
+		m.store.StartSubAgent(id)
+		// Each turn is traced as its own run of the sub-agent's session
+		return l.agent.RunStream(ctx, subAgentSession(id), prompt, images, nil)
+	})
+	l.turn.Unlock()
 
 	status := "completed"
-	if err != nil {
+	if errors.Is(err, ErrRunCancelled) {
+		fmt.Printf("[SubAgent %s]: Cancelled.\n", id)
+		status = "cancelled"
+	} else if err != nil {
 		fmt.Printf("[SubAgent %s]: Run failed with error: %v\n", id, err)
 		status = "failed"
 		result = fmt.Sprintf("Error: %v", err)
@@ -120,6 +382,38 @@ func (m *SubAgentManager) runSubAgent(id, prompt string, images []string, person
 	if err != nil {
 		log.Printf("Error updating sub-agent %s in DB: %v", id, err)
 	}
+	if m.releaseLive(id) > 0 {
+		// A follow-up is waiting for its turn
+		m.store.ReopenSubAgent(id, "")
+	}
+	m.jobFinished(id, "subagent", status, result)
+}
+
+// acquireLive registers a turn of a sub-agent, creating its run handle for the first one.
+func (m *SubAgentManager) acquireLive(id string, subAgent *Agent, label string) *liveSubAgent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.live[id]
+	if !ok {
+		ctx, done := m.runs.start(context.Background(), id, "subagent", subAgentSession(id), label)
+		l = &liveSubAgent{agent: subAgent, ctx: ctx, done: done}
+		m.live[id] = l
+	}
+	l.turns++
+	return l
+}
+
+// releaseLive ends a turn of a sub-agent and returns how many are still pending.
+func (m *SubAgentManager) releaseLive(id string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.live[id]
+	l.turns--
+	if l.turns == 0 {
+		delete(m.live, id)
+		l.done()
+	}
+	return l.turns
 }
 
 func (m *SubAgentManager) List() ([]db.SubAgentTask, error) {
@@ -130,8 +424,28 @@ func (m *SubAgentManager) ListDefinitions() ([]db.SubAgentDefinition, error) {
 	return m.store.GetSubAgentDefinitions()
 }
 
-func (m *SubAgentManager) DefineAgent(name, personality, tools, model string) error {
-	return m.store.SaveSubAgentDefinition(name, personality, tools, model)
+func (m *SubAgentManager) DefineAgent(def db.SubAgentDefinition) error {
+	return saveAgentDefinition(m.store, def)
+}
+
+// parseOptions reads the model options stored with a definition or task. They
+// are checked when saved, so invalid ones are only logged and ignored.
+func parseOptions(options string) llm.Options {
+	opts, err := llm.ParseOptions(options)
+	if err != nil {
+		fmt.Printf("[SubAgentManager]: Ignoring invalid model options %s: %v\n", options, err)
+	}
+	return opts
+}
+
+// parseFallbacks reads the fallback chain stored with a definition or task.
+// It returns nil, keeping the client's own chain, if none is set.
+func parseFallbacks(fallbacks string) []llm.Route {
+	routes, err := llm.ParseRoutes(fallbacks)
+	if err != nil {
+		fmt.Printf("[SubAgentManager]: Ignoring invalid fallbacks %s: %v\n", fallbacks, err)
+	}
+	return routes
 }
 
 func (m *SubAgentManager) GetAvailableTools() []string {
@@ -0,0 +1,25 @@
+package agent
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCancelListedRuns(t *testing.T) {
+	m := &SubAgentManager{runs: NewRunRegistry()}
+	for _, kind := range []string{"subagent", "council", "swarm"} {
+		ctx, done := m.runs.start(context.Background(), kind+"-1", kind, kind+":"+kind+"-1", "goal")
+		if err := m.Cancel(kind + "-1"); err != nil {
+			t.Errorf("cancel %s run: %v", kind, err)
+		} else if ctx.Err() == nil {
+			t.Errorf("%s run was not cancelled", kind)
+		}
+		done()
+	}
+
+	ctx, done := m.runs.start(context.Background(), "chat-1", "chat", "web:1", "hello")
+	defer done()
+	if err := m.Cancel("chat-1"); err == nil || ctx.Err() != nil {
+		t.Errorf("cancelled a chat turn: %v", err)
+	}
+}
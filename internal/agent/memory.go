@@ -0,0 +1,242 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
+)
+
+// DefaultMemoryHalfLife is the age at which a memory's recency bonus has halved.
+const DefaultMemoryHalfLife = 30 * 24 * time.Hour
+
+const (
+	// memoryEmbedBatch is how many memories are embedded per request.
+	memoryEmbedBatch = 32
+	// memoryEmbedRetry is how long to search by keywords alone after embedding failed.
+	memoryEmbedRetry = 10 * time.Minute
+)
+
+// MemoryWeights blends other signals into the similarity of a memory to the
+// query. Both are 0 by default, which ranks by similarity alone.
+type MemoryWeights struct {
+	Recency    float64       // Weight of how recently the memory was saved
+	Importance float64       // Weight of the memory's importance
+	HalfLife   time.Duration // Age at which the recency bonus has halved
+}
+
+// MemoryIndex ranks long-term memories by semantic similarity to a query.
+// Memories are embedded with the backend's embedding endpoint when saved, and
+// those saved before (or with another embedding model) are embedded in the
+// background by Watch. Searches fall back to keyword matching if embedding
+// fails, and keep to it for a while so a missing embedding model does not slow
+// down every turn.
+type MemoryIndex struct {
+	store      *db.Store
+	embedder   llm.Provider // Bound to the embedding model
+	weights    MemoryWeights
+	minScore   float64
+	mu         sync.Mutex // Serializes backfills
+	retryMu    sync.Mutex // Guards embedAfter
+	embedAfter time.Time  // Embedding failed; keywords only until then
+}
+
+func NewMemoryIndex(store *db.Store, embedder llm.Provider) *MemoryIndex {
+	return &MemoryIndex{
+		store:    store,
+		embedder: embedder,
+		weights:  MemoryWeights{HalfLife: DefaultMemoryHalfLife},
+	}
+}
+
+// SetWeights sets how much recency and importance count besides similarity.
+func (x *MemoryIndex) SetWeights(w MemoryWeights) {
+	if w.HalfLife <= 0 {
+		w.HalfLife = DefaultMemoryHalfLife
+	}
+	x.weights = w
+}
+
+// SetMinScore drops memories scoring below min from search results.
+func (x *MemoryIndex) SetMinScore(min float64) {
+	x.minScore = min
+}
+
+// embedModel names the model embeddings are computed with, so memories are
+// embedded again when it changes.
+func (x *MemoryIndex) embedModel() string {
+	return x.embedder.GetModel()
+}
+
+// embedding reports whether embeddings may be requested, i.e. the last attempt
+// did not fail less than memoryEmbedRetry ago.
+func (x *MemoryIndex) embedding() bool {
+	x.retryMu.Lock()
+	defer x.retryMu.Unlock()
+	return !time.Now().Before(x.embedAfter)
+}
+
+// embedFailed keeps embeddings off for memoryEmbedRetry.
+func (x *MemoryIndex) embedFailed() {
+	x.retryMu.Lock()
+	defer x.retryMu.Unlock()
+	x.embedAfter = time.Now().Add(memoryEmbedRetry)
+}
+
+// Remember saves a memory and embeds it. A memory that cannot be embedded yet
+// is still saved, and embedded by a later backfill.
+func (x *MemoryIndex) Remember(ctx context.Context, m db.Memory) (int, error) {
+	id, err := x.store.SaveMemory(m)
+	if err != nil {
+		return 0, err
+	}
+	m.ID = id
+	if !x.embedding() {
+		return id, nil
+	}
+	if err := x.embed(ctx, []db.Memory{m}); err != nil {
+		fmt.Printf("[Memory]: Could not embed memory %d: %v\n", id, err)
+	}
+	return id, nil
+}
+
+// Recall returns up to limit memories ranked by relevance to query. An empty
+// query returns the newest memories.
+func (x *MemoryIndex) Recall(ctx context.Context, query string, limit int) ([]db.Memory, error) {
+	if query == "" || !x.embedding() {
+		return x.store.SearchMemories(query, limit)
+	}
+
+	vectors, err := x.embedder.Embed(ctx, []string{query})
+	if err != nil || len(vectors) == 0 {
+		fmt.Printf("[Memory]: Falling back to keyword search for %s, could not embed query: %v\n", memoryEmbedRetry, err)
+		if ctx.Err() == nil {
+			x.embedFailed()
+		}
+		return x.store.SearchMemories(query, limit)
+	}
+	q := toFloat32(vectors[0])
+
+	memories, err := x.store.GetEmbeddedMemories(x.embedModel())
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ranked := memories[:0]
+	for _, m := range memories {
+		m.Score = x.score(q, m, now)
+		if m.Score >= x.minScore {
+			ranked = append(ranked, m)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked, nil
+}
+
+// score is the cosine similarity of the memory to the query plus the weighted
+// recency (1 when new, halving every half-life) and importance (0 to 1).
+func (x *MemoryIndex) score(query []float32, m db.Memory, now time.Time) float64 {
+	score := cosine(query, m.Embedding)
+	if x.weights.Recency > 0 {
+		age := now.Sub(m.CreatedAt)
+		score += x.weights.Recency * math.Pow(0.5, float64(age)/float64(x.weights.HalfLife))
+	}
+	if x.weights.Importance > 0 {
+		score += x.weights.Importance * float64(m.Importance-1) / 4
+	}
+	return score
+}
+
+// Watch runs Backfill now and then every interval until ctx is done, so
+// searches only have to embed the query.
+func (x *MemoryIndex) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if x.embedding() {
+			if err := x.Backfill(ctx); err != nil {
+				fmt.Printf("[Memory]: Could not embed stored memories (is EMBED_MODEL an embedding model?): %v\n", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Backfill embeds the memories that have no embedding from the current model.
+func (x *MemoryIndex) Backfill(ctx context.Context) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for {
+		pending, err := x.store.GetUnembeddedMemories(x.embedModel(), memoryEmbedBatch)
+		if err != nil || len(pending) == 0 {
+			return err
+		}
+		if err := x.embed(ctx, pending); err != nil {
+			return err
+		}
+	}
+}
+
+func (x *MemoryIndex) embed(ctx context.Context, memories []db.Memory) error {
+	texts := make([]string, len(memories))
+	for i, m := range memories {
+		texts[i] = m.Content
+		if m.Tags != "" {
+			texts[i] += "\nTags: " + m.Tags
+		}
+	}
+	vectors, err := x.embedder.Embed(ctx, texts)
+	if err != nil {
+		if ctx.Err() == nil {
+			x.embedFailed()
+		}
+		return err
+	}
+	if len(vectors) != len(memories) {
+		return fmt.Errorf("got %d embeddings for %d memories", len(vectors), len(memories))
+	}
+	model := x.embedModel()
+	for i, m := range memories {
+		if err := x.store.SetMemoryEmbedding(m.ID, model, toFloat32(vectors[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toFloat32(v []float64) []float32 {
+	out := make([]float32, len(v))
+	for i, f := range v {
+		out[i] = float32(f)
+	}
+	return out
+}
+
+// cosine returns the cosine similarity of two vectors, or 0 if their sizes differ.
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,323 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
+)
+
+// maxMeshTasks bounds the size of a mesh plan, subtasks included.
+const maxMeshTasks = 20
+
+// meshResultLimit is how much of each earlier result is passed on to later tasks.
+const meshResultLimit = 1500
+
+// MeshManager runs "mesh" workflows: the LLM decomposes a goal into a project
+// with a task tree in the planner, then the tasks are executed in order by the
+// main agent or the sub-agent assigned to them, each seeing the results of the
+// tasks before it. Task and project statuses are updated as the workflow runs.
+type MeshManager struct {
+	agent      *Agent
+	subManager *SubAgentManager
+	store      *db.Store
+}
+
+func NewMeshManager(agent *Agent, subManager *SubAgentManager, store *db.Store) *MeshManager {
+	return &MeshManager{agent: agent, subManager: subManager, store: store}
+}
+
+// meshPlan is the decomposition of a goal requested from the LLM.
+type meshPlan struct {
+	Tasks []meshStep `json:"tasks"`
+}
+
+type meshStep struct {
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Agent       string     `json:"agent,omitempty"`
+	Subtasks    []meshStep `json:"subtasks,omitempty"`
+}
+
+// meshStepSchema describes a task with up to depth levels of subtasks.
+func meshStepSchema(depth int) map[string]interface{} {
+	props := map[string]interface{}{
+		"title":       map[string]interface{}{"type": "string"},
+		"description": map[string]interface{}{"type": "string"},
+		"agent":       map[string]interface{}{"type": "string"},
+	}
+	if depth > 0 {
+		props["subtasks"] = map[string]interface{}{"type": "array", "items": meshStepSchema(depth - 1)}
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+		"required":   []string{"title", "description"},
+	}
+}
+
+var meshPlanSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"tasks": map[string]interface{}{"type": "array", "items": meshStepSchema(1)},
+	},
+	"required": []string{"tasks"},
+}
+
+// meshTask is a task of a running workflow.
+type meshTask struct {
+	db.Task
+	children []*meshTask
+}
+
+// meshWorkflow is the state of one workflow run.
+type meshWorkflow struct {
+	projectID string
+	goal      string
+	tasks     []*meshTask // Top-level tasks, in order
+	all       []*meshTask // Every task, in execution order
+	done      []*meshTask // Completed leaf tasks, in the order they finished
+}
+
+// StartMesh plans and runs a workflow for goal in the background. It returns
+// the ID of the project tracking it, which is also the handle to cancel it.
+func (m *MeshManager) StartMesh(ctx context.Context, goal string) (string, error) {
+	goal = strings.TrimSpace(goal)
+	if goal == "" {
+		return "", fmt.Errorf("goal is required")
+	}
+
+	id := uuid.New().String()[:8]
+	name := goal
+	if len(name) > 60 {
+		name = name[:57] + "..."
+	}
+	if err := m.store.SaveProject(db.Project{ID: id, Name: name, Description: goal, Status: "planning"}); err != nil {
+		return "", err
+	}
+
+	// Registered before returning, so the ID can be cancelled right away
+	ctx, done := m.agent.Runs().start(context.Background(), id, "mesh", "mesh:"+id, goal)
+	go func() {
+		defer done()
+		m.run(ctx, id, goal)
+	}()
+	return id, nil
+}
+
+// CancelMesh stops the workflow of the given project. Tasks not yet started
+// are skipped.
+func (m *MeshManager) CancelMesh(id string) error {
+	return m.agent.Runs().cancelKind(id, "mesh")
+}
+
+func (m *MeshManager) run(ctx context.Context, projectID, goal string) {
+
+	fmt.Printf("[Mesh %s]: Planning: %s\n", projectID, goal)
+	plan, err := m.plan(ctx, goal)
+	if err != nil {
+		fmt.Printf("[Mesh %s]: Planning failed: %v\n", projectID, err)
+		m.store.UpdateProjectStatus(projectID, meshStatus(ctx, err))
+		return
+	}
+
+	w := &meshWorkflow{projectID: projectID, goal: goal}
+	w.tasks = m.saveSteps(w, "", plan.Tasks)
+	fmt.Printf("[Mesh %s]: Running %d tasks\n", projectID, len(w.all))
+	m.store.UpdateProjectStatus(projectID, "running")
+
+	err = m.execute(ctx, w, w.tasks)
+	if err != nil {
+		// Tasks after the one that stopped the workflow are not run
+		for _, t := range w.all {
+			if t.Status == "pending" {
+				m.setTask(w, t, "skipped", "")
+			}
+		}
+	}
+	status := meshStatus(ctx, err)
+	m.store.UpdateProjectStatus(projectID, status)
+	fmt.Printf("[Mesh %s]: Workflow %s\n", projectID, status)
+}
+
+// plan asks the LLM to decompose goal into tasks.
+func (m *MeshManager) plan(ctx context.Context, goal string) (*meshPlan, error) {
+	defs, _ := m.store.GetSubAgentDefinitions()
+	agents := make(map[string]bool)
+	var agentList strings.Builder
+	for _, d := range defs {
+		agents[d.Name] = true
+		personality := d.Personality
+		if len(personality) > 120 {
+			personality = personality[:117] + "..."
+		}
+		fmt.Fprintf(&agentList, "- %s: %s\n", d.Name, personality)
+	}
+	if agentList.Len() == 0 {
+		agentList.WriteString("(none defined)\n")
+	}
+
+	prompt := fmt.Sprintf("Decompose the following goal into a plan of concrete tasks that are executed one after another.\n"+
+		"GOAL: %s\n\n"+
+		"Each task is carried out by an AI agent with tools (web search, files, shell, email, ...) that sees the results of the tasks before it. "+
+		"Keep the plan short, usually 2 to 8 tasks, and use \"subtasks\" only to group closely related steps.\n\n"+
+		"Leave \"agent\" empty for the main assistant, or set it to one of these specialised sub-agents when a task fits them:\n%s\n"+
+		"Reply with JSON: {\"tasks\": [{\"title\": \"...\", \"description\": \"what to do and what to deliver\", \"agent\": \"\", \"subtasks\": []}]}",
+		goal, agentList.String())
+
+	var plan meshPlan
+	client := m.agent.client.WithModel(m.agent.Model())
+	_, err := llm.GenerateStructured(ctx, client, []llm.Message{{Role: "user", Content: prompt}}, llm.Structured{
+		Schema: meshPlanSchema,
+		Validate: func() error {
+			if len(plan.Tasks) == 0 {
+				return fmt.Errorf("the plan has no tasks")
+			}
+			if n := countSteps(plan.Tasks); n > maxMeshTasks {
+				return fmt.Errorf("the plan has %d tasks, at most %d are allowed", n, maxMeshTasks)
+			}
+			return checkStepAgents(plan.Tasks, agents)
+		},
+	}, &plan)
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func countSteps(steps []meshStep) int {
+	n := len(steps)
+	for _, s := range steps {
+		n += countSteps(s.Subtasks)
+	}
+	return n
+}
+
+func checkStepAgents(steps []meshStep, agents map[string]bool) error {
+	for _, s := range steps {
+		if s.Agent != "" && s.Agent != "main" && !agents[s.Agent] {
+			return fmt.Errorf("agent %q of task %q does not exist", s.Agent, s.Title)
+		}
+		if err := checkStepAgents(s.Subtasks, agents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveSteps stores the planned tasks in the planner and returns them as a tree.
+func (m *MeshManager) saveSteps(w *meshWorkflow, parentID string, steps []meshStep) []*meshTask {
+	var tasks []*meshTask
+	for _, s := range steps {
+		t := &meshTask{Task: db.Task{
+			ID:            uuid.New().String()[:8],
+			ProjectID:     w.projectID,
+			ParentID:      parentID,
+			Title:         s.Title,
+			Description:   s.Description,
+			Status:        "pending",
+			AssignedAgent: s.Agent,
+		}}
+		if err := m.store.SaveTask(t.Task); err != nil {
+			fmt.Printf("[Mesh %s]: Could not save task %q: %v\n", w.projectID, s.Title, err)
+		}
+		w.all = append(w.all, t)
+		t.children = m.saveSteps(w, t.ID, s.Subtasks)
+		tasks = append(tasks, t)
+	}
+	return tasks
+}
+
+// execute runs tasks in order, depth first, and stops at the first one that
+// fails. A task with subtasks is done when all of them are; only leaf tasks are
+// handed to an agent.
+func (m *MeshManager) execute(ctx context.Context, w *meshWorkflow, tasks []*meshTask) error {
+	for _, t := range tasks {
+		if ctx.Err() != nil {
+			return ErrRunCancelled
+		}
+		m.setTask(w, t, "in_progress", "")
+
+		var result string
+		var err error
+		if len(t.children) > 0 {
+			err = m.execute(ctx, w, t.children)
+			result = fmt.Sprintf("%d subtasks completed.", len(t.children))
+		} else {
+			result, err = m.runTask(ctx, w, t)
+		}
+		if err != nil {
+			m.setTask(w, t, meshStatus(ctx, err), fmt.Sprintf("Error: %v", err))
+			return err
+		}
+
+		m.setTask(w, t, "completed", result)
+		if len(t.children) == 0 {
+			w.done = append(w.done, t)
+		}
+	}
+	return nil
+}
+
+// runTask hands a leaf task to its agent, with the goal, the plan and the
+// results of the tasks completed so far.
+func (m *MeshManager) runTask(ctx context.Context, w *meshWorkflow, t *meshTask) (string, error) {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "You are carrying out one task of a larger workflow.\nGOAL: %s\n\nPLAN:\n", w.goal)
+	for _, other := range w.all {
+		indent := ""
+		if other.ParentID != "" {
+			indent = "  "
+		}
+		fmt.Fprintf(&prompt, "%s- %s [%s]\n", indent, other.Title, other.Status)
+	}
+	if len(w.done) > 0 {
+		prompt.WriteString("\nRESULTS OF EARLIER TASKS:\n")
+		for _, d := range w.done {
+			result := d.Result
+			if len(result) > meshResultLimit {
+				result = result[:meshResultLimit] + "..."
+			}
+			fmt.Fprintf(&prompt, "### %s\n%s\n\n", d.Title, result)
+		}
+	}
+	fmt.Fprintf(&prompt, "\nYOUR TASK: %s\n%s\n\nComplete only this task and reply with its result.", t.Title, t.Description)
+
+	sessionID := "mesh:" + w.projectID
+	fmt.Printf("[Mesh %s]: Task %q (agent: %s)\n", w.projectID, t.Title, agentLabel(t.AssignedAgent))
+	if t.AssignedAgent == "" || t.AssignedAgent == "main" {
+		return m.agent.RunStream(ctx, sessionID, prompt.String(), nil, nil)
+	}
+	return m.subManager.RunNamed(ctx, t.AssignedAgent, sessionID, prompt.String())
+}
+
+func (m *MeshManager) setTask(w *meshWorkflow, t *meshTask, status, result string) {
+	t.Status = status
+	t.Result = result
+	if err := m.store.UpdateTaskStatus(t.ID, status, result); err != nil {
+		fmt.Printf("[Mesh %s]: Could not update task %q: %v\n", w.projectID, t.Title, err)
+	}
+}
+
+// meshStatus maps the outcome of a workflow, or of one of its tasks, to a status.
+func meshStatus(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return "completed"
+	case errors.Is(err, ErrRunCancelled) || ctx.Err() != nil:
+		return "cancelled"
+	default:
+		return "failed"
+	}
+}
+
+func agentLabel(name string) string {
+	if name == "" {
+		return "main"
+	}
+	return name
+}
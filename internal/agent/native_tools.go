@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pyromancer/idony/internal/tools/base"
+)
+
+// toolParameters returns a JSON Schema for the tool's arguments. MCP tools provide
+// one directly; built-in tools have it derived from their UI schema.
+func toolParameters(t base.Tool) map[string]interface{} {
+	if js, ok := t.(base.JSONSchemaTool); ok {
+		if schema := js.InputSchema(); len(schema) > 0 {
+			return schema
+		}
+	}
+
+	ui := t.Schema()
+	props := map[string]interface{}{}
+	var required []string
+
+	if actions := schemaList(ui["actions"]); len(actions) > 0 {
+		var names []string
+		for _, action := range actions {
+			name, _ := action["name"].(string)
+			names = append(names, name)
+			for _, f := range schemaList(action["fields"]) {
+				addSchemaField(props, nil, f)
+			}
+		}
+		props["action"] = map[string]interface{}{"type": "string", "enum": names}
+		required = append(required, "action")
+	} else {
+		for _, f := range schemaList(ui["fields"]) {
+			required = addSchemaField(props, required, f)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addSchemaField adds a UI schema field as a JSON Schema property and returns the
+// updated list of required properties.
+func addSchemaField(props map[string]interface{}, required []string, f map[string]interface{}) []string {
+	name, _ := f["name"].(string)
+	if name == "" {
+		return required
+	}
+	prop := map[string]interface{}{"type": "string"}
+	if f["type"] == "image_list" {
+		prop = map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}
+	}
+	desc, _ := f["label"].(string)
+	if hint, ok := f["hint"].(string); ok && hint != "" {
+		desc = strings.TrimSpace(desc + " (e.g. " + hint + ")")
+	}
+	if desc != "" {
+		prop["description"] = desc
+	}
+	if opts, ok := f["options"].([]string); ok {
+		prop["enum"] = opts
+	}
+	props[name] = prop
+	if req, _ := f["required"].(bool); req {
+		required = append(required, name)
+	}
+	return required
+}
+
+// schemaList normalizes a list of UI schema objects, which may be typed or
+// decoded from JSON.
+func schemaList(v interface{}) []map[string]interface{} {
+	switch list := v.(type) {
+	case []map[string]interface{}:
+		return list
+	case []interface{}:
+		var out []map[string]interface{}
+		for _, item := range list {
+			if m, ok := item.(map[string]interface{}); ok {
+				out = append(out, m)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// toolInput turns native tool call arguments into the string input expected by
+// Tool.Execute. Tools taking a single "input" field receive its plain value;
+// everything else receives the arguments as JSON, like the PWA tool forms send.
+func toolInput(args json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(args, &s); err == nil {
+		return s
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(args, &obj); err != nil {
+		return string(args)
+	}
+	if len(obj) == 0 {
+		return ""
+	}
+	if raw, ok := obj["input"]; ok && len(obj) == 1 {
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return s
+		}
+		return string(raw)
+	}
+	return string(args)
+}
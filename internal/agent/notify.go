@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
+)
+
+// notifySummaryLimit is how much of a job's result goes into its notification.
+const notifySummaryLimit = 1500
+
+// Notifier tells the session that started a sub-agent, council or swarm when
+// it finishes. The notification is stored for clients that poll (PWA, TUI),
+// passed to listeners that push (Telegram) and added to the session's
+// conversation. With follow-ups enabled the main agent also takes a turn on the
+// result, e.g. to finish the task it delegated; its reply is delivered the same way.
+type Notifier struct {
+	agent     *Agent
+	store     *db.Store
+	followUp  bool
+	mu        sync.Mutex
+	listeners []func(db.Notification)
+}
+
+func NewNotifier(agent *Agent, store *db.Store) *Notifier {
+	return &Notifier{agent: agent, store: store}
+}
+
+// SetFollowUp makes the main agent continue reasoning when a job it started finishes.
+func (n *Notifier) SetFollowUp(enabled bool) {
+	n.followUp = enabled
+}
+
+// OnNotify registers fn to be called for every notification.
+func (n *Notifier) OnNotify(fn func(db.Notification)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.listeners = append(n.listeners, fn)
+}
+
+// jobFinished notifies the session that started the job, if any. Jobs started
+// by other background work (workflows, swarms, sub-agents, agent messages) are not reported,
+// since there is nobody to tell.
+func (n *Notifier) jobFinished(jobID, kind, status, summary string) {
+	task, err := n.store.GetSubAgent(jobID)
+	if err != nil || task == nil || !notifiable(task.Origin) {
+		return
+	}
+	if len(summary) > notifySummaryLimit {
+		summary = summary[:notifySummaryLimit] + "..."
+	}
+
+	note := db.Notification{SessionID: task.Origin, JobID: jobID, Kind: kind, Status: status, Summary: summary}
+	n.deliver(note)
+
+	text := fmt.Sprintf("[Background job finished] The %s %s you started is %s.\nResult:\n%s", kind, jobID, status, summary)
+	if !n.followUp {
+		if err := n.agent.Notify(context.Background(), task.Origin, text); err != nil {
+			fmt.Printf("[Notifier]: Could not add notification to session %s: %v\n", task.Origin, err)
+		}
+		return
+	}
+
+	prompt := text + "\n\nContinue with the task you delegated, using this result where it helps. If nothing is left to do, briefly tell the user the outcome."
+	reply, err := n.agent.RunStream(context.Background(), task.Origin, prompt, nil, nil)
+	if err != nil {
+		fmt.Printf("[Notifier]: Follow-up on %s %s failed: %v\n", kind, jobID, err)
+		return
+	}
+	n.deliver(db.Notification{SessionID: task.Origin, JobID: jobID, Kind: "reply", Status: status, Summary: reply})
+}
+
+func (n *Notifier) deliver(note db.Notification) {
+	id, err := n.store.SaveNotification(note)
+	if err != nil {
+		fmt.Printf("[Notifier]: Could not save notification for %s: %v\n", note.SessionID, err)
+	}
+	note.ID = id
+	note.Channel = db.SessionChannel(note.SessionID)
+
+	n.mu.Lock()
+	listeners := append([]func(db.Notification){}, n.listeners...)
+	n.mu.Unlock()
+	for _, fn := range listeners {
+		go fn(note)
+	}
+}
+
+// notifiable reports whether a job started from the session can be reported
+// back to it: sessions of background work have no one listening.
+func notifiable(origin string) bool {
+	switch db.SessionChannel(origin) {
+	case "subagent", "council", "swarm", "mesh", "inbox":
+		return false
+	}
+	return origin != ""
+}
+
+// Notify adds a message to a session's conversation, e.g. the result of a job
+// that finished in the background, so the agent knows about it on the next
+// turn. It waits for a turn in progress in the session to end first.
+func (a *Agent) Notify(ctx context.Context, sessionID, content string) error {
+	s := a.session(sessionID)
+	release, err := a.queue.acquire(ctx, s.id)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	s.history = append(s.history, llm.Message{Role: "system", Content: content})
+	a.saveMessage(s, "system", content)
+	return nil
+}
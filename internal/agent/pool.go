@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/tools/base"
+)
+
+// DefaultMaxConcurrentSubAgents is the number of sub-agent runs that may use the LLM at the same time.
+const DefaultMaxConcurrentSubAgents = 2
+
+// Priority orders sub-agent runs waiting for a worker. Lower values go first.
+type Priority int
+
+const (
+	PriorityInteractive Priority = iota // Started by a user from a client or chat
+	PriorityScheduled                   // Started by the scheduler
+	PriorityWebhook                     // Started by a webhook
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityScheduled:
+		return "scheduled"
+	case PriorityWebhook:
+		return "webhook"
+	}
+	return "interactive"
+}
+
+type priorityKey struct{}
+
+// WithPriority sets the priority of sub-agents started under ctx.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityOf returns the priority set with WithPriority or, failing that, the
+// one of the channel of the session the sub-agent is started from.
+func priorityOf(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	switch db.SessionChannel(base.SessionID(ctx)) {
+	case "schedule":
+		return PriorityScheduled
+	case "webhook":
+		return PriorityWebhook
+	}
+	return PriorityInteractive
+}
+
+// workerPool bounds the number of sub-agent runs in progress. Runs beyond the
+// limit wait in a priority queue, first by priority, then in arrival order.
+type workerPool struct {
+	mu      sync.Mutex
+	max     int
+	running int
+	seq     uint64
+	waiting jobQueue
+}
+
+type poolJob struct {
+	priority Priority
+	seq      uint64
+	start    chan struct{} // Closed when the job is given a worker
+	started  bool
+	gaveUp   bool
+}
+
+func newWorkerPool(max int) *workerPool {
+	if max <= 0 {
+		max = DefaultMaxConcurrentSubAgents
+	}
+	return &workerPool{max: max}
+}
+
+// acquire blocks until a worker is free for a run of the given priority, or ctx
+// is done. The returned function must be called when the run is over.
+func (p *workerPool) acquire(ctx context.Context, priority Priority) (func(), error) {
+	p.mu.Lock()
+	if p.running < p.max && p.waiting.Len() == 0 {
+		p.running++
+		p.mu.Unlock()
+		return p.releaser(), nil
+	}
+	p.seq++
+	job := &poolJob{priority: priority, seq: p.seq, start: make(chan struct{})}
+	heap.Push(&p.waiting, job)
+	p.mu.Unlock()
+
+	select {
+	case <-job.start:
+		return p.releaser(), nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		started := job.started
+		job.gaveUp = true
+		p.mu.Unlock()
+		if started {
+			// The worker was handed over just as ctx ended
+			p.releaser()()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (p *workerPool) releaser() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.running--
+			p.dispatch()
+		})
+	}
+}
+
+// dispatch hands free workers to the waiting jobs. p.mu must be held.
+func (p *workerPool) dispatch() {
+	for p.running < p.max && p.waiting.Len() > 0 {
+		job := heap.Pop(&p.waiting).(*poolJob)
+		if job.gaveUp {
+			continue
+		}
+		job.started = true
+		p.running++
+		close(job.start)
+	}
+}
+
+// stats returns the number of running and waiting runs.
+func (p *workerPool) stats() (running, waiting int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, job := range p.waiting {
+		if !job.gaveUp {
+			waiting++
+		}
+	}
+	return p.running, waiting
+}
+
+// jobQueue implements heap.Interface over the waiting jobs.
+type jobQueue []*poolJob
+
+func (q jobQueue) Len() int { return len(q) }
+func (q jobQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority < q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+func (q jobQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *jobQueue) Push(x interface{}) { *q = append(*q, x.(*poolJob)) }
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	job := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return job
+}
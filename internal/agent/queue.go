@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultMaxConcurrentRuns is the number of sessions that may run at the same time.
+const DefaultMaxConcurrentRuns = 2
+
+// runQueue orders agent runs. Turns of the same session run one at a time, since
+// each builds on the history of the previous one, while up to maxParallel
+// different sessions run concurrently.
+type runQueue struct {
+	slots    chan struct{}           // One token per run allowed in parallel
+	mu       sync.Mutex              // Guards the fields below
+	sessions map[string]*sessionLock // Locks of the sessions with runs waiting or running
+	waiting  int
+	running  int
+}
+
+// sessionLock lets one run of a session in at a time. It is dropped from the
+// queue when the last run holding or waiting for it is done.
+type sessionLock struct {
+	ch   chan struct{} // Buffered with capacity 1
+	refs int
+}
+
+func newRunQueue(maxParallel int) *runQueue {
+	if maxParallel <= 0 {
+		maxParallel = DefaultMaxConcurrentRuns
+	}
+	return &runQueue{
+		slots:    make(chan struct{}, maxParallel),
+		sessions: make(map[string]*sessionLock),
+	}
+}
+
+// acquire blocks until a run in the session may start, or ctx is done. The
+// returned function must be called when the run is over.
+func (q *runQueue) acquire(ctx context.Context, sessionID string) (func(), error) {
+	q.mu.Lock()
+	lock, ok := q.sessions[sessionID]
+	if !ok {
+		lock = &sessionLock{ch: make(chan struct{}, 1)}
+		q.sessions[sessionID] = lock
+	}
+	lock.refs++
+	q.waiting++
+	q.mu.Unlock()
+
+	// Take the session first so a queued turn does not hold a parallel slot
+	select {
+	case lock.ch <- struct{}{}:
+	case <-ctx.Done():
+		q.done(sessionID, lock, false)
+		return nil, ctx.Err()
+	}
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		<-lock.ch
+		q.done(sessionID, lock, false)
+		return nil, ctx.Err()
+	}
+
+	q.mu.Lock()
+	q.waiting--
+	q.running++
+	q.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-q.slots
+			<-lock.ch
+			q.done(sessionID, lock, true)
+		})
+	}, nil
+}
+
+// done updates the counters when a run finishes or gives up waiting, and drops
+// the session's lock once no other run holds or waits for it.
+func (q *runQueue) done(sessionID string, lock *sessionLock, started bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if lock.refs--; lock.refs == 0 {
+		delete(q.sessions, sessionID)
+	}
+	if started {
+		q.running--
+	} else {
+		q.waiting--
+	}
+}
+
+// stats returns the number of running and waiting runs.
+func (q *runQueue) stats() (running, waiting int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running, q.waiting
+}
@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunQueueDropsIdleSessions(t *testing.T) {
+	q := newRunQueue(2)
+	const sessions, perSession = 4, 5
+	var inSession [sessions]atomic.Int32
+	var wg sync.WaitGroup
+	for s := 0; s < sessions; s++ {
+		for i := 0; i < perSession; i++ {
+			wg.Add(1)
+			go func(s, i int) {
+				defer wg.Done()
+				ctx := context.Background()
+				if i == 0 {
+					// Some waiters give up
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithTimeout(ctx, time.Millisecond)
+					defer cancel()
+				}
+				release, err := q.acquire(ctx, fmt.Sprint("session-", s))
+				if err != nil {
+					return
+				}
+				if n := inSession[s].Add(1); n > 1 {
+					t.Errorf("session %d has %d runs at once", s, n)
+				}
+				time.Sleep(2 * time.Millisecond)
+				inSession[s].Add(-1)
+				release()
+			}(s, i)
+		}
+	}
+	wg.Wait()
+
+	if running, waiting := q.stats(); running != 0 || waiting != 0 {
+		t.Errorf("got %d running and %d waiting, want none", running, waiting)
+	}
+	if len(q.sessions) != 0 {
+		t.Errorf("%d session locks left after all runs ended", len(q.sessions))
+	}
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
+)
+
+// run is the execution context of a single turn. It carries its own provider
+// bound to the run's model, the attached images and a snapshot of the session
+// history, so concurrent runs in different sessions share no mutable state.
+type run struct {
+	id        string
+	session   *session
+	client    llm.Provider // Provider bound to model
+	model     string
+	answered  string // Model that answered the last generation, if it fell back from model
+	images    []string
+	history   []llm.Message // Working copy of the session history
+	summary   string        // Working copy of the session summary
+	turnStart int           // Index in history of the turn's user message
+	traces    *db.Store     // Optional; where the run's steps are recorded
+	step      int           // Number of the loop iteration in progress
+	memories  []db.Memory   // Memories for the turn, looked up on the first step
+	recalled  bool
+	tools     []string // Names of the tools offered, picked on the first step; guarded by toolsMu
+	toolsMu   sync.Mutex
+	started   time.Time
+}
+
+// newRun snapshots the session for a new turn. The run queue guarantees that no
+// other turn of the same session is in progress. options are those of the call,
+// layered over the agent's.
+func (a *Agent) newRun(id string, s *session, images []string, options llm.Options) *run {
+	model := a.Model()
+	client := a.runClient(model).WithOptions(a.options.Merge(options))
+	if a.fallbacks != nil {
+		client = llm.WithFallbacks(client, a.fallbacks)
+	}
+	return &run{
+		id:      id,
+		session: s,
+		client:  client,
+		model:   model,
+		images:  images,
+		history: append([]llm.Message(nil), s.history...),
+		summary: s.summary,
+		traces:  a.traces,
+		started: time.Now(),
+	}
+}
+
+// generationContext returns ctx set up to note which model answers the
+// run's next generation.
+func (r *run) generationContext(ctx context.Context) context.Context {
+	r.answered = ""
+	return llm.OnServed(ctx, func(route llm.Route) {
+		if route.Model != r.model || route.BaseURL != "" {
+			r.answered = route.String()
+		}
+	})
+}
+
+// answeredBy returns the model that answered the last generation.
+func (r *run) answeredBy() string {
+	if r.answered != "" {
+		return r.answered
+	}
+	return r.model
+}
+
+// commit stores the history of the finished run back into its session.
+func (r *run) commit() {
+	r.session.history = r.history
+	r.session.summary = r.summary
+}
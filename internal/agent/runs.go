@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrRunCancelled is returned by RunStream when the run was cancelled through its handle.
+var ErrRunCancelled = errors.New("run cancelled")
+
+// RunInfo describes a run in progress.
+type RunInfo struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"` // "chat", "subagent", "council", "mesh" or "swarm"
+	SessionID string    `json:"session_id"`
+	Label     string    `json:"label"` // The prompt, shortened
+	StartedAt time.Time `json:"started_at"`
+}
+
+type runHandle struct {
+	info   RunInfo
+	cancel context.CancelFunc
+}
+
+// RunRegistry keeps a cancellable handle for every run in progress, so turns,
+// sub-agents and councils can be stopped from the API, clients and tools.
+type RunRegistry struct {
+	mu   sync.Mutex
+	runs map[string]*runHandle
+}
+
+func NewRunRegistry() *RunRegistry {
+	return &RunRegistry{runs: make(map[string]*runHandle)}
+}
+
+// start registers a run and returns its context, which is cancelled by Cancel,
+// and a function that must be called when the run is over.
+func (r *RunRegistry) start(parent context.Context, id, kind, sessionID, label string) (context.Context, func()) {
+	if len(label) > 80 {
+		label = label[:77] + "..."
+	}
+	ctx, cancel := context.WithCancel(parent)
+	r.mu.Lock()
+	r.runs[id] = &runHandle{
+		info:   RunInfo{ID: id, Kind: kind, SessionID: sessionID, Label: label, StartedAt: time.Now()},
+		cancel: cancel,
+	}
+	r.mu.Unlock()
+
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.runs, id)
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// Cancel stops the run with the given ID.
+func (r *RunRegistry) Cancel(id string) error {
+	return r.cancelKind(id)
+}
+
+// cancelKind stops the run with the given ID if it is of one of the given
+// kinds, or of any kind if none are given.
+func (r *RunRegistry) cancelKind(id string, kinds ...string) error {
+	r.mu.Lock()
+	h, ok := r.runs[id]
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("run %s is not running", id)
+	}
+	if len(kinds) > 0 && !slices.Contains(kinds, h.info.Kind) {
+		return fmt.Errorf("run %s is a %s run, not a %s", id, h.info.Kind, strings.Join(kinds, " or "))
+	}
+	fmt.Printf("[Runs]: Cancelling %s %s\n", h.info.Kind, id)
+	h.cancel()
+	return nil
+}
+
+// CancelSession stops every run of a session and returns how many were stopped.
+func (r *RunRegistry) CancelSession(sessionID string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, h := range r.runs {
+		if h.info.SessionID == sessionID {
+			h.cancel()
+			n++
+		}
+	}
+	return n
+}
+
+// List returns the runs in progress, oldest first.
+func (r *RunRegistry) List() []RunInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]RunInfo, 0, len(r.runs))
+	for _, h := range r.runs {
+		list = append(list, h.info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.Before(list[j].StartedAt) })
+	return list
+}
+
+type runIDKey struct{}
+
+// withRunID makes the next RunStream under ctx use id instead of a fresh run ID,
+// so e.g. a sub-agent's trace is found under the sub-agent's ID.
+func withRunID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, id)
+}
+
+// takeRunID returns the run ID requested through withRunID, or a new one, and
+// a context that no longer carries it so nested runs get their own.
+func takeRunID(ctx context.Context) (context.Context, string) {
+	if id, ok := ctx.Value(runIDKey{}).(string); ok && id != "" {
+		return withRunID(ctx, ""), id
+	}
+	return ctx, uuid.New().String()[:8]
+}
@@ -74,16 +74,19 @@ func (s *Scheduler) schedule(ctx context.Context, task db.ScheduledTask) {
 
 func (s *Scheduler) executeTask(ctx context.Context, task db.ScheduledTask) {
 	fmt.Printf("\n[Scheduler]: Running scheduled task: %s (Target: %s/%s)\n", task.Prompt, task.TargetType, task.TargetName)
+	// Sub-agents started by the task wait behind interactive ones
+	ctx = WithPriority(ctx, PriorityScheduled)
 
 	var err error
 	switch task.TargetType {
 	case "subagent":
 		_, err = s.subManager.SpawnNamed(ctx, task.TargetName, task.Prompt, nil)
 	case "council":
-		_, err = s.councilManager.RunCouncilSession(ctx, task.TargetName, task.Prompt)
+		_, err = s.councilManager.RunCouncilSession(ctx, task.TargetName, task.Prompt, nil)
 	default:
-		// Default is "main"
-		_, err = s.agent.Run(ctx, fmt.Sprintf("[Scheduled Task]: %s", task.Prompt))
+		// Default is "main", each task in its own session
+		sessionID := fmt.Sprintf("schedule:%d", task.ID)
+		_, err = s.agent.RunStream(ctx, sessionID, fmt.Sprintf("[Scheduled Task]: %s", task.Prompt), nil, nil)
 	}
 
 	if err != nil {
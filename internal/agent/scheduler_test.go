@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm/llmtest"
+)
+
+func TestExecuteTaskConcurrent(t *testing.T) {
+	store, err := db.NewStore(filepath.Join(t.TempDir(), "idony.db") + "?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.DB.Close() })
+
+	client := llmtest.New("task done")
+	a := NewAgent(client, store)
+	sm := NewSubAgentManager(client, store, a.GetTools())
+	s := NewScheduler(a, store, sm, NewCouncilManager(client, store, sm))
+
+	const tasks, runsPerTask = 4, 3
+	var wg sync.WaitGroup
+	for id := 1; id <= tasks; id++ {
+		// The same recurring task can fire again while its last run is going
+		for i := 0; i < runsPerTask; i++ {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				s.executeTask(context.Background(), db.ScheduledTask{
+					ID:         id,
+					Type:       "recurring",
+					Schedule:   "0 * * * * *",
+					Prompt:     fmt.Sprintf("task %d", id),
+					TargetType: "main",
+				})
+			}(id)
+		}
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < tasks; i++ {
+			a.SetModel(fmt.Sprintf("model-%d", i))
+			a.SetBaseURL(fmt.Sprintf("http://host-%d", i))
+		}
+	}()
+	wg.Wait()
+
+	if got := client.Calls(); got < tasks*runsPerTask {
+		t.Errorf("got %d generations, want at least %d", got, tasks*runsPerTask)
+	}
+	for id := 1; id <= tasks; id++ {
+		history, err := store.LoadLastMessages(fmt.Sprintf("schedule:%d", id), 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 2*runsPerTask {
+			t.Errorf("task %d has %d messages, want %d", id, len(history), 2*runsPerTask)
+		}
+	}
+}
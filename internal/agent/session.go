@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
+)
+
+// DefaultSession is the session used when a caller does not name one.
+const DefaultSession = db.DefaultSessionID
+
+// historyLoadLimit is the number of stored messages restored when a session is opened.
+const historyLoadLimit = 20
+
+// summaryPrefix starts the stored message holding the summary of the turns
+// cut from a session's history, as written by /compact and fitHistory.
+const summaryPrefix = "Summary of previous conversation:"
+
+// session is the conversation state of one session. Its history is only
+// touched by the run holding the session's place in the run queue.
+type session struct {
+	id      string
+	history []llm.Message
+	summary string // Summary of the turns cut from history to fit the context window
+}
+
+// session returns the state of the given session, restoring its history from the
+// store the first time it is used.
+func (a *Agent) session(id string) *session {
+	if id == "" {
+		id = DefaultSession
+	}
+	a.sessionsMu.Lock()
+	defer a.sessionsMu.Unlock()
+
+	if s, ok := a.sessions[id]; ok {
+		return s
+	}
+	s := &session{id: id}
+	a.loadHistory(s)
+	a.sessions[id] = s
+	return s
+}
+
+// DropSession forgets the in-memory state of a session, e.g. after it was deleted.
+func (a *Agent) DropSession(id string) {
+	a.sessionsMu.Lock()
+	defer a.sessionsMu.Unlock()
+	delete(a.sessions, id)
+}
+
+func (a *Agent) loadHistory(s *session) {
+	if a.history == nil {
+		return
+	}
+	if err := a.history.EnsureSession(s.id); err != nil {
+		fmt.Printf("Warning: Could not create session %s: %v\n", s.id, err)
+	}
+	msgs, err := a.history.LoadLastMessages(s.id, historyLoadLimit)
+	if err != nil {
+		fmt.Printf("Warning: Could not load history from DB: %v\n", err)
+		return
+	}
+	for _, m := range msgs {
+		if m.Role == "system" && strings.HasPrefix(m.Content, summaryPrefix) {
+			// Summaries go into the system prompt, as system messages
+			// inside the history are dropped by many templates
+			s.summary = strings.TrimSpace(strings.TrimPrefix(m.Content, summaryPrefix))
+			continue
+		}
+		s.history = append(s.history, llm.Message{Role: m.Role, Content: m.Content})
+	}
+}
+
+// saveMessage persists a message of the session when the agent keeps its history.
+func (a *Agent) saveMessage(s *session, role, content string) {
+	if a.history != nil {
+		a.history.SaveMessage(s.id, role, content)
+	}
+}
+
+// saveSummary replaces the stored messages of history[:cut] with summary, so
+// a reload restores the summary instead of the turns it covers. Messages the
+// agent does not store, like tool results, are skipped by walking the stored
+// ones back from the newest alongside history.
+func (a *Agent) saveSummary(s *session, history []llm.Message, cut int, summary string) {
+	if a.history == nil {
+		return
+	}
+	stored, err := a.history.LoadLastMessages(s.id, len(history)+1)
+	if err != nil {
+		fmt.Printf("Warning: Could not save history summary: %v\n", err)
+		return
+	}
+	var ids []int
+	for i, j := len(history)-1, len(stored)-1; i >= 0 && j >= 0; {
+		m := stored[j]
+		switch {
+		case m.Role == "system" && strings.HasPrefix(m.Content, summaryPrefix):
+			// Replaced by the new summary
+			ids = append(ids, m.ID)
+			j--
+		case m.Role == history[i].Role && (m.Content == history[i].Content || m.Content == "[Image Attached] "+history[i].Content):
+			if i < cut {
+				ids = append(ids, m.ID)
+			}
+			i--
+			j--
+		default:
+			i--
+		}
+	}
+	if err := a.history.DeleteMessages(ids); err != nil {
+		fmt.Printf("Warning: Could not save history summary: %v\n", err)
+		return
+	}
+	if err := a.history.SaveMessage(s.id, "system", summaryPrefix+" "+summary); err != nil {
+		fmt.Printf("Warning: Could not save history summary: %v\n", err)
+	}
+}
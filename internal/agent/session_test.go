@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
+	"github.com/pyromancer/idony/internal/llm/llmtest"
+)
+
+func TestHistorySummarySurvivesReload(t *testing.T) {
+	store, err := db.NewStore(filepath.Join(t.TempDir(), "idony.db") + "?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.DB.Close() })
+
+	const id = "web:reload"
+	if err := store.EnsureSession(id); err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("words to fill the window ", 40)
+	for i := 0; i < 4; i++ {
+		store.SaveMessage(id, "user", fmt.Sprintf("question %d %s", i, long))
+		store.SaveMessage(id, "assistant", fmt.Sprintf("answer %d %s", i, long))
+	}
+	store.SaveMessage(id, "user", "current question")
+
+	client := llmtest.New("the earlier turns, summarized")
+	a := NewAgent(client, store)
+	s := a.session(id)
+	r := a.newRun("run", s, nil, llm.Options{})
+	r.turnStart = len(r.history) - 1
+	var report BudgetReport
+	if !a.fitHistory(context.Background(), r, 800, &report) {
+		t.Fatal("history was not summarized")
+	}
+	if r.turnStart == 0 {
+		t.Fatal("all older turns were summarized, want some kept")
+	}
+	r.commit()
+
+	reloaded := NewAgent(client, store).session(id)
+	if reloaded.summary != s.summary {
+		t.Errorf("reloaded summary %q, want %q", reloaded.summary, s.summary)
+	}
+	if len(reloaded.history) != len(s.history) {
+		t.Fatalf("reloaded %d messages, want the %d kept", len(reloaded.history), len(s.history))
+	}
+	for i, m := range reloaded.history {
+		if m.Role != s.history[i].Role || m.Content != s.history[i].Content {
+			t.Errorf("message %d is %s %.20q, want %s %.20q", i, m.Role, m.Content, s.history[i].Role, s.history[i].Content)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// EventType identifies the kind of event emitted by RunStream.
+type EventType string
+
+const (
+	EventThought     EventType = "thought"     // The model's reasoning for the current step
+	EventToolCall    EventType = "tool_call"   // A tool is about to be executed
+	EventObservation EventType = "observation" // The result of a tool execution
+	EventToken       EventType = "token"       // A chunk of the final answer as it is generated
+	EventReset       EventType = "reset"       // The tokens sent so far were not the final answer; discard them
+	EventFinal       EventType = "final"       // The complete final answer
+	EventError       EventType = "error"       // The run failed
+	EventAborted     EventType = "aborted"     // The run was stopped by its step or repeat limits
+	EventContext     EventType = "context"     // History, memories or tools were trimmed to fit the context window
+	EventApproval    EventType = "approval"    // A tool call is waiting for approval; ID identifies it
+	EventStarted     EventType = "started"     // The run was registered; ID is its handle for cancelling
+	EventCancelled   EventType = "cancelled"   // The run was cancelled
+)
+
+// Event is a single streamed update from an agent run.
+type Event struct {
+	Type    EventType `json:"type"`
+	Content string    `json:"content,omitempty"`
+	Tool    string    `json:"tool,omitempty"`
+	Input   string    `json:"input,omitempty"`
+	ID      string    `json:"id,omitempty"`
+}
+
+// EventHandler receives events from a streaming run. It is called synchronously
+// from the agent loop, so it should not block for long.
+type EventHandler func(Event)
+
+// finalStreamer turns raw LLM deltas into final-answer token events.
+// The model answers in a <json> block, so only the decoded contents of the
+// "final" field are forwarded. Replies that are plain text are forwarded as-is.
+type finalStreamer struct {
+	emit    EventHandler
+	buf     strings.Builder
+	decided bool
+	plain   bool
+	sent    int
+}
+
+func newFinalStreamer(emit EventHandler) *finalStreamer {
+	return &finalStreamer{emit: emit}
+}
+
+// Write consumes the next chunk of the raw model output.
+func (s *finalStreamer) Write(delta string) {
+	s.buf.WriteString(delta)
+	text := s.buf.String()
+
+	if !s.decided {
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" {
+			return
+		}
+		s.decided = true
+		s.plain = !strings.HasPrefix(trimmed, "<") && !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "`")
+		if s.plain {
+			s.emit(Event{Type: EventToken, Content: text})
+			s.sent = len(text)
+			return
+		}
+	}
+
+	if s.plain {
+		s.emit(Event{Type: EventToken, Content: delta})
+		s.sent += len(delta)
+		return
+	}
+
+	final, ok := partialJSONString(text, "final")
+	if !ok || len(final) <= s.sent {
+		return
+	}
+	s.emit(Event{Type: EventToken, Content: final[s.sent:]})
+	s.sent = len(final)
+}
+
+// Reset discards everything received so far, e.g. before a repair attempt or
+// when the streamed step turned out to call tools. Clients are told to drop
+// the tokens they were sent.
+func (s *finalStreamer) Reset() {
+	if s.sent > 0 {
+		s.emit(Event{Type: EventReset})
+	}
+	s.buf.Reset()
+	s.decided = false
+	s.plain = false
+	s.sent = 0
+}
+
+// partialJSONString finds the string value of key in a possibly incomplete JSON
+// document and returns the part of it that has been decoded so far.
+func partialJSONString(s, key string) (string, bool) {
+	needle := "\"" + key + "\""
+	idx := -1
+	for from := 0; ; {
+		i := strings.Index(s[from:], needle)
+		if i == -1 {
+			break
+		}
+		i += from
+		// Skip escaped occurrences inside other string values.
+		if i == 0 || s[i-1] != '\\' {
+			idx = i
+			break
+		}
+		from = i + len(needle)
+	}
+	if idx == -1 {
+		return "", false
+	}
+
+	rest := strings.TrimLeft(s[idx+len(needle):], " \t\r\n")
+	if !strings.HasPrefix(rest, ":") {
+		return "", false
+	}
+	rest = strings.TrimLeft(rest[1:], " \t\r\n")
+	if !strings.HasPrefix(rest, "\"") {
+		return "", false
+	}
+	rest = rest[1:]
+
+	var out strings.Builder
+	for i := 0; i < len(rest); {
+		c := rest[i]
+		switch {
+		case c == '"':
+			return out.String(), true
+		case c == '\\':
+			if i+1 >= len(rest) {
+				return out.String(), true // Incomplete escape, wait for more input
+			}
+			switch rest[i+1] {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 'r':
+				out.WriteByte('\r')
+			case 'b':
+				out.WriteByte('\b')
+			case 'f':
+				out.WriteByte('\f')
+			case 'u':
+				if i+6 > len(rest) {
+					return out.String(), true
+				}
+				var r rune
+				for _, h := range rest[i+2 : i+6] {
+					r <<= 4
+					switch {
+					case h >= '0' && h <= '9':
+						r |= h - '0'
+					case h >= 'a' && h <= 'f':
+						r |= h - 'a' + 10
+					case h >= 'A' && h <= 'F':
+						r |= h - 'A' + 10
+					}
+				}
+				out.WriteRune(r)
+				i += 6
+				continue
+			default:
+				out.WriteByte(rest[i+1])
+			}
+			i += 2
+		default:
+			r, size := utf8.DecodeRuneInString(rest[i:])
+			if r == utf8.RuneError && size <= 1 && !utf8.FullRuneInString(rest[i:]) {
+				return out.String(), true // Incomplete multi-byte character
+			}
+			out.WriteString(rest[i : i+size])
+			i += size
+		}
+	}
+	return out.String(), true
+}
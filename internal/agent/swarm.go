@@ -0,0 +1,381 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
+	"github.com/pyromancer/idony/internal/tools/base"
+)
+
+// maxSwarmTasks bounds the number of sub-tasks a coordinator may plan.
+const maxSwarmTasks = 12
+
+// maxSwarmRevisions is how often a reviewed output may be sent back for revision.
+const maxSwarmRevisions = 2
+
+// SwarmManager runs swarms: teams of sub-agents playing roles such as coder,
+// reviewer and tester. A coordinator splits a goal into sub-tasks for the roles;
+// independent sub-tasks run in parallel, outputs flow to the sub-tasks that
+// depend on them, and a sub-task with a reviewer is revised until the reviewer
+// approves it or the revision budget is spent.
+type SwarmManager struct {
+	client     llm.Provider
+	store      *db.Store
+	subManager *SubAgentManager
+}
+
+func NewSwarmManager(client llm.Provider, store *db.Store, subManager *SubAgentManager) *SwarmManager {
+	return &SwarmManager{client: client, store: store, subManager: subManager}
+}
+
+// swarmPlan is the coordinator's assignment of a goal's sub-tasks to roles.
+type swarmPlan struct {
+	Tasks []swarmTask `json:"tasks"`
+}
+
+type swarmTask struct {
+	ID        string   `json:"id"`
+	Role      string   `json:"role"`
+	Task      string   `json:"task"`
+	DependsOn []string `json:"depends_on,omitempty"`
+	ReviewBy  string   `json:"review_by,omitempty"`
+}
+
+var swarmPlanSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"tasks": map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"id":         map[string]interface{}{"type": "string"},
+					"role":       map[string]interface{}{"type": "string"},
+					"task":       map[string]interface{}{"type": "string"},
+					"depends_on": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+					"review_by":  map[string]interface{}{"type": "string"},
+				},
+				"required": []string{"id", "role", "task"},
+			},
+		},
+	},
+	"required": []string{"tasks"},
+}
+
+// DefineSwarm creates or replaces a swarm. members maps roles to sub-agent definitions.
+func (m *SwarmManager) DefineSwarm(name string, members map[string]string) error {
+	if name == "" || len(members) == 0 {
+		return fmt.Errorf("name and members are required")
+	}
+	roles := make([]string, 0, len(members))
+	for role, agentName := range members {
+		def, err := m.store.GetSubAgentDefinition(agentName)
+		if err != nil {
+			return err
+		}
+		if def == nil {
+			return fmt.Errorf("sub-agent definition for '%s' not found", agentName)
+		}
+		if strings.ContainsAny(role, ",=") {
+			return fmt.Errorf("invalid role name %q", role)
+		}
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	pairs := make([]string, len(roles))
+	for i, role := range roles {
+		pairs[i] = role + "=" + members[role]
+	}
+	return m.store.SaveSwarm(name, strings.Join(pairs, ","))
+}
+
+func (m *SwarmManager) ListSwarms() ([]db.Swarm, error) {
+	return m.store.GetSwarms()
+}
+
+func (m *SwarmManager) DeleteSwarm(name string) error {
+	return m.store.DeleteSwarm(name)
+}
+
+// RunSwarm starts a swarm on goal in the background. Like councils, the run is
+// tracked as a sub-agent task whose ID is returned.
+func (m *SwarmManager) RunSwarm(ctx context.Context, swarmName, goal string) (string, error) {
+	swarm, err := m.store.GetSwarm(swarmName)
+	if err != nil {
+		return "", err
+	}
+	if swarm == nil {
+		return "", fmt.Errorf("swarm '%s' not found", swarmName)
+	}
+	if len(swarm.Roles()) == 0 {
+		return "", fmt.Errorf("swarm '%s' has no members", swarmName)
+	}
+
+	id := uuid.New().String()[:8]
+	if err := m.store.SaveSubAgent(db.SubAgentTask{ID: id, Prompt: fmt.Sprintf("Swarm '%s' Session: %s", swarmName, goal), Status: "running", Origin: base.SessionID(ctx)}); err != nil {
+		return "", err
+	}
+
+	go m.executeSwarm(id, swarm, goal)
+	return id, nil
+}
+
+func (m *SwarmManager) executeSwarm(id string, swarm *db.Swarm, goal string) {
+	fmt.Printf("\n[Swarm %s]: Session %s started - %s\n", swarm.Name, id, goal)
+	ctx, done := m.subManager.runs.start(context.Background(), id, "swarm", "swarm:"+id, goal)
+	defer done()
+
+	roles := make(map[string]string)
+	for _, member := range swarm.Roles() {
+		roles[member.Role] = member.Agent
+	}
+
+	plan, err := m.plan(ctx, swarm, goal)
+	if err != nil {
+		m.finish(ctx, id, swarm.Name, fmt.Sprintf("Coordinator failed to plan: %v", err), err)
+		return
+	}
+
+	var transcript []string
+	outputs := make(map[string]string)
+	pending := plan.Tasks
+	for len(pending) > 0 {
+		// Every sub-task whose inputs are ready runs in this wave
+		var ready, waiting []swarmTask
+		for _, t := range pending {
+			if inputsReady(t, outputs) {
+				ready = append(ready, t)
+			} else {
+				waiting = append(waiting, t)
+			}
+		}
+
+		inputs := make([]string, len(ready))
+		for i, t := range ready {
+			inputs[i] = taskInputs(t, outputs, plan)
+		}
+
+		logs := make([][]string, len(ready))
+		results := make([]string, len(ready))
+		errs := make([]error, len(ready))
+		var wg sync.WaitGroup
+		for i, t := range ready {
+			wg.Add(1)
+			go func(i int, t swarmTask) {
+				defer wg.Done()
+				results[i], logs[i], errs[i] = m.runTask(ctx, id, roles, goal, t, inputs[i])
+			}(i, t)
+		}
+		wg.Wait()
+
+		for i, t := range ready {
+			transcript = append(transcript, logs[i]...)
+			if errs[i] != nil {
+				transcript = append(transcript, fmt.Sprintf("[%s] %s failed: %v", t.Role, t.ID, errs[i]))
+				m.finish(ctx, id, swarm.Name, strings.Join(transcript, "\n\n---\n\n"), errs[i])
+				return
+			}
+			outputs[t.ID] = results[i]
+		}
+		m.store.UpdateSubAgentProgress(id, len(outputs)*100/len(plan.Tasks))
+		pending = waiting
+	}
+
+	final, err := m.synthesize(ctx, goal, plan, outputs)
+	if err != nil {
+		// The outputs are still worth keeping; the last one is usually the most complete
+		final = outputs[plan.Tasks[len(plan.Tasks)-1].ID]
+	}
+	result := final + "\n\n---\n\nSwarm transcript:\n\n" + strings.Join(transcript, "\n\n---\n\n")
+	m.finish(ctx, id, swarm.Name, result, nil)
+}
+
+// plan asks the coordinator to split goal into sub-tasks for the swarm's roles.
+func (m *SwarmManager) plan(ctx context.Context, swarm *db.Swarm, goal string) (*swarmPlan, error) {
+	roles := make(map[string]bool)
+	var team strings.Builder
+	for _, member := range swarm.Roles() {
+		roles[member.Role] = true
+		personality := ""
+		if def, _ := m.store.GetSubAgentDefinition(member.Agent); def != nil {
+			personality = def.Personality
+			if len(personality) > 120 {
+				personality = personality[:117] + "..."
+			}
+		}
+		fmt.Fprintf(&team, "- %s (agent %s): %s\n", member.Role, member.Agent, personality)
+	}
+
+	prompt := fmt.Sprintf("You coordinate a team of AI agents. Split the goal into sub-tasks and assign each to a role of the team.\n"+
+		"GOAL: %s\n\nTEAM:\n%s\n"+
+		"Rules:\n"+
+		"- Give every sub-task a short unique id (t1, t2, ...).\n"+
+		"- List in \"depends_on\" the sub-tasks whose output a sub-task needs. Sub-tasks without dependencies run in parallel.\n"+
+		"- Set \"review_by\" to a role that should critique the output (e.g. the reviewer for the coder's work); the author then revises it.\n"+
+		"- Use at most %d sub-tasks.\n"+
+		"Reply with JSON: {\"tasks\": [{\"id\": \"t1\", \"role\": \"...\", \"task\": \"...\", \"depends_on\": [], \"review_by\": \"\"}]}",
+		goal, team.String(), maxSwarmTasks)
+
+	var plan swarmPlan
+	_, err := llm.GenerateStructured(ctx, m.client, []llm.Message{{Role: "user", Content: prompt}}, llm.Structured{
+		Schema:   swarmPlanSchema,
+		Validate: func() error { return checkSwarmPlan(plan, roles) },
+	}, &plan)
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// checkSwarmPlan rejects plans with unknown roles, dangling or circular dependencies.
+func checkSwarmPlan(plan swarmPlan, roles map[string]bool) error {
+	if len(plan.Tasks) == 0 {
+		return fmt.Errorf("the plan has no tasks")
+	}
+	if len(plan.Tasks) > maxSwarmTasks {
+		return fmt.Errorf("the plan has %d tasks, at most %d are allowed", len(plan.Tasks), maxSwarmTasks)
+	}
+	ids := make(map[string]bool)
+	for _, t := range plan.Tasks {
+		if t.ID == "" || ids[t.ID] {
+			return fmt.Errorf("task ids must be unique and non-empty, got %q", t.ID)
+		}
+		ids[t.ID] = true
+		if !roles[t.Role] {
+			return fmt.Errorf("task %s is assigned to unknown role %q", t.ID, t.Role)
+		}
+		if t.ReviewBy != "" && (!roles[t.ReviewBy] || t.ReviewBy == t.Role) {
+			return fmt.Errorf("task %s must be reviewed by another role of the team, not %q", t.ID, t.ReviewBy)
+		}
+	}
+	for _, t := range plan.Tasks {
+		for _, dep := range t.DependsOn {
+			if !ids[dep] {
+				return fmt.Errorf("task %s depends on unknown task %q", t.ID, dep)
+			}
+		}
+	}
+
+	// Resolve the waves as executeSwarm will, to catch cycles
+	done := make(map[string]string)
+	for len(done) < len(plan.Tasks) {
+		progressed := false
+		for _, t := range plan.Tasks {
+			if _, ok := done[t.ID]; !ok && inputsReady(t, done) {
+				done[t.ID] = ""
+				progressed = true
+			}
+		}
+		if !progressed {
+			return fmt.Errorf("the task dependencies are circular")
+		}
+	}
+	return nil
+}
+
+func inputsReady(t swarmTask, outputs map[string]string) bool {
+	for _, dep := range t.DependsOn {
+		if _, ok := outputs[dep]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// taskInputs formats the outputs a sub-task depends on.
+func taskInputs(t swarmTask, outputs map[string]string, plan *swarmPlan) string {
+	if len(t.DependsOn) == 0 {
+		return ""
+	}
+	roleOf := make(map[string]string)
+	for _, other := range plan.Tasks {
+		roleOf[other.ID] = other.Role
+	}
+	var b strings.Builder
+	b.WriteString("INPUTS FROM YOUR TEAM:\n")
+	for _, dep := range t.DependsOn {
+		fmt.Fprintf(&b, "### %s (by the %s)\n%s\n\n", dep, roleOf[dep], outputs[dep])
+	}
+	return b.String()
+}
+
+// runTask has the role's agent carry out a sub-task and, if the sub-task has a
+// reviewer, routes the output through review and revision. It returns the
+// final output and the transcript of the exchange.
+func (m *SwarmManager) runTask(ctx context.Context, id string, roles map[string]string, goal string, t swarmTask, inputs string) (string, []string, error) {
+	sessionID := "swarm:" + id
+	var log []string
+
+	fmt.Printf("[Swarm %s]: %s working on %s\n", id, t.Role, t.ID)
+	prompt := fmt.Sprintf("You are the %s of a team working on this goal: %s\n\n%sYOUR TASK (%s): %s\n\nReply with your complete work.",
+		t.Role, goal, inputs, t.ID, t.Task)
+	output, err := m.subManager.RunNamed(ctx, roles[t.Role], sessionID, prompt)
+	if err != nil {
+		return "", log, err
+	}
+	log = append(log, fmt.Sprintf("[%s] %s: %s\n%s", t.Role, t.ID, t.Task, output))
+	if t.ReviewBy == "" {
+		return output, log, nil
+	}
+
+	for revision := 1; revision <= maxSwarmRevisions; revision++ {
+		fmt.Printf("[Swarm %s]: %s reviewing %s\n", id, t.ReviewBy, t.ID)
+		reviewPrompt := fmt.Sprintf("You are the %s of a team working on this goal: %s\n\n"+
+			"Review the %s's work on this task: %s\n\nWORK:\n%s\n\n"+
+			"If it is correct and complete, reply with APPROVED on the first line. "+
+			"Otherwise list the concrete problems to fix.",
+			t.ReviewBy, goal, t.Role, t.Task, output)
+		review, err := m.subManager.RunNamed(ctx, roles[t.ReviewBy], sessionID, reviewPrompt)
+		if err != nil {
+			return "", log, err
+		}
+		log = append(log, fmt.Sprintf("[%s -> %s] review of %s:\n%s", t.ReviewBy, t.Role, t.ID, review))
+		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(review)), "APPROVED") {
+			break
+		}
+
+		fmt.Printf("[Swarm %s]: %s revising %s (revision %d)\n", id, t.Role, t.ID, revision)
+		revisePrompt := fmt.Sprintf("You are the %s of a team working on this goal: %s\n\n"+
+			"YOUR TASK (%s): %s\n\nYOUR PREVIOUS WORK:\n%s\n\nFEEDBACK FROM THE %s:\n%s\n\n"+
+			"Revise your work to address the feedback and reply with the complete revised version.",
+			t.Role, goal, t.ID, t.Task, output, strings.ToUpper(t.ReviewBy), review)
+		output, err = m.subManager.RunNamed(ctx, roles[t.Role], sessionID, revisePrompt)
+		if err != nil {
+			return "", log, err
+		}
+		log = append(log, fmt.Sprintf("[%s] %s revision %d:\n%s", t.Role, t.ID, revision, output))
+	}
+	return output, log, nil
+}
+
+// synthesize has the coordinator combine the outputs into the swarm's answer.
+func (m *SwarmManager) synthesize(ctx context.Context, goal string, plan *swarmPlan, outputs map[string]string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "You coordinated a team of AI agents working on this goal: %s\n\nTheir results:\n\n", goal)
+	for _, t := range plan.Tasks {
+		fmt.Fprintf(&b, "### %s (%s): %s\n%s\n\n", t.ID, t.Role, t.Task, outputs[t.ID])
+	}
+	b.WriteString("Combine the results into the final answer for the user. Keep the deliverables (code, text) complete.")
+	return m.client.GenerateResponse(ctx, []llm.Message{{Role: "user", Content: b.String()}})
+}
+
+func (m *SwarmManager) finish(ctx context.Context, id, swarmName, result string, err error) {
+	status := "completed"
+	switch {
+	case errors.Is(err, ErrRunCancelled) || (err != nil && ctx.Err() != nil):
+		status = "cancelled"
+	case err != nil:
+		status = "failed"
+	}
+	m.store.UpdateSubAgent(id, status, result)
+	m.subManager.jobFinished(id, "swarm", status, result)
+	fmt.Printf("\n[Swarm %s]: Session %s %s\n", swarmName, id, status)
+}
@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyromancer/idony/internal/db"
+)
+
+// toolCall is a tool invocation requested by the model, in either protocol.
+type toolCall struct {
+	name  string
+	input string
+}
+
+// calls returns the tool invocations of a text-protocol reply, from "tool" and "tools".
+func (tp ThoughtProcess) calls() []toolCall {
+	var calls []toolCall
+	if tp.Tool != "" {
+		calls = append(calls, toolCall{name: tp.Tool, input: thoughtInput(tp.Input)})
+	}
+	for _, c := range tp.Tools {
+		if c.Tool != "" {
+			calls = append(calls, toolCall{name: c.Tool, input: thoughtInput(c.Input)})
+		}
+	}
+	return calls
+}
+
+// thoughtInput turns a text-protocol input into the string passed to the tool:
+// strings are unquoted, anything else is kept as JSON.
+func thoughtInput(raw json.RawMessage) string {
+	input := string(raw)
+	if strings.HasPrefix(input, "\"") && strings.HasSuffix(input, "\"") {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			input = s
+		}
+	}
+	return input
+}
+
+// runTools executes the tool calls of one step. The guard sees the calls in the
+// order they were requested; once it rejects one, that call and the ones after it
+// are not run. The others run concurrently, at most MaxParallelTools at a time.
+// Results are returned in the order of calls, whatever order the tools finish in.
+func (a *Agent) runTools(ctx context.Context, r *run, calls []toolCall, guard *runGuard, emit EventHandler) ([]string, error) {
+	results := make([]string, len(calls))
+	var abortErr error
+	var runnable []int
+	for i, c := range calls {
+		if abortErr == nil {
+			abortErr = guard.call(c.name, c.input)
+		}
+		if abortErr != nil {
+			results[i] = fmt.Sprintf("Not executed: %v", abortErr)
+			continue
+		}
+		if c.name == moreToolsName && a.selector != nil {
+			results[i] = a.moreTools(ctx, r, c.input)
+			continue
+		}
+		if _, ok := a.tools[c.name]; !ok {
+			results[i] = fmt.Sprintf("Error: Tool '%s' not found.", c.name)
+			r.record(db.RunStep{Kind: "tool", Tool: c.name, Input: c.input, Error: results[i], StartedAt: time.Now()})
+			continue
+		}
+		runnable = append(runnable, i)
+	}
+
+	switch len(runnable) {
+	case 0:
+		return results, abortErr
+	case 1:
+		i := runnable[0]
+		results[i] = a.executeTool(ctx, r, a.tools[calls[i].name], calls[i].input, emit)
+		return results, abortErr
+	}
+
+	parallel := a.stepLimits.MaxParallelTools
+	if parallel <= 0 {
+		parallel = 1
+	}
+	fmt.Printf("[Agent]: Running %d tool calls, %d at a time\n", len(runnable), parallel)
+	emit = syncEmit(emit)
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for _, i := range runnable {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = a.executeTool(ctx, r, a.tools[calls[i].name], calls[i].input, emit)
+		}(i)
+	}
+	wg.Wait()
+	return results, abortErr
+}
+
+// syncEmit serializes the events of tools running concurrently, since handlers
+// are not required to be safe for concurrent use.
+func syncEmit(emit EventHandler) EventHandler {
+	if emit == nil {
+		return nil
+	}
+	var mu sync.Mutex
+	return func(ev Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		emit(ev)
+	}
+}
+
+// observations formats the results of a text-protocol step as a single history
+// message, labelling each result with its call when there are several.
+func observations(calls []toolCall, results []string) string {
+	if len(calls) == 1 {
+		return fmt.Sprintf("Observation: %s", results[0])
+	}
+	parts := make([]string, len(calls))
+	for i, c := range calls {
+		parts[i] = fmt.Sprintf("Observation %d (%s): %s", i+1, c.name, results[i])
+	}
+	return strings.Join(parts, "\n\n")
+}
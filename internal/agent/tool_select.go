@@ -0,0 +1,271 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
+	"github.com/pyromancer/idony/internal/tools/base"
+)
+
+// DefaultToolLimit is how many tools besides the core ones are offered per request.
+const DefaultToolLimit = 12
+
+// DefaultCoreTools are offered on every request, whatever it is about.
+var DefaultCoreTools = []string{"get_time", "help", "recall", "remember", "subagent"}
+
+// moreToolsName is the tool the model calls to find tools it was not offered.
+const moreToolsName = "more_tools"
+
+const (
+	moreToolsDescription = "Finds more tools when none of the available ones fits the task. Input: {\"query\": \"what you need a tool for\"}. The tools found can be called from the next step on."
+	moreToolsResults     = 8
+	// toolEmbedRetry is how long to rank by keywords alone after embedding failed.
+	toolEmbedRetry = 10 * time.Minute
+)
+
+// ToolSelector picks the tools relevant to a request, so the prompt does not
+// carry the docs of every registered tool. Tools are ranked by the keywords
+// they share with the request and, if the backend can embed, by semantic
+// similarity. The selected tools are returned in name order, which keeps the
+// prompt stable across steps for prompt caching.
+type ToolSelector struct {
+	embedder   llm.Provider // Optional; nil ranks by keywords alone
+	limit      int
+	core       map[string]bool
+	mu         sync.Mutex
+	vectors    map[string][]float32 // Embeddings by tool name and description
+	embedAfter time.Time            // Embedding failed; keywords only until then
+}
+
+func NewToolSelector(embedder llm.Provider, limit int, core []string) *ToolSelector {
+	if limit <= 0 {
+		limit = DefaultToolLimit
+	}
+	s := &ToolSelector{
+		embedder: embedder,
+		limit:    limit,
+		core:     make(map[string]bool),
+		vectors:  make(map[string][]float32),
+	}
+	for _, name := range core {
+		if name = strings.TrimSpace(name); name != "" {
+			s.core[name] = true
+		}
+	}
+	return s
+}
+
+// Select returns the core tools and the tools most relevant to query, sorted
+// by name. If there are few tools, all of them are returned.
+func (s *ToolSelector) Select(ctx context.Context, query string, tools map[string]base.Tool) []string {
+	var selected, candidates []string
+	for name := range tools {
+		if s.core[name] {
+			selected = append(selected, name)
+		} else {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) > s.limit {
+		candidates = s.rank(ctx, query, candidates, tools)[:s.limit]
+	}
+	selected = append(selected, candidates...)
+	sort.Strings(selected)
+	return selected
+}
+
+// Search returns up to n of the tools not in exclude, most relevant to query first.
+func (s *ToolSelector) Search(ctx context.Context, query string, tools map[string]base.Tool, exclude map[string]bool, n int) []string {
+	var candidates []string
+	for name := range tools {
+		if !exclude[name] {
+			candidates = append(candidates, name)
+		}
+	}
+	ranked := s.rank(ctx, query, candidates, tools)
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// rank orders the candidates by relevance to query, most relevant first.
+func (s *ToolSelector) rank(ctx context.Context, query string, candidates []string, tools map[string]base.Tool) []string {
+	sort.Strings(candidates)
+	words := keywords(query)
+	similarity := s.similarities(ctx, query, candidates, tools)
+
+	scores := make(map[string]float64, len(candidates))
+	for _, name := range candidates {
+		scores[name] = keywordScore(words, name, tools[name].Description()) + similarity[name]
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return scores[candidates[i]] > scores[candidates[j]] })
+	return candidates
+}
+
+// keywordScore is the share of the query's keywords found in the tool's name
+// and description. Words of the name count double.
+func keywordScore(words []string, name, description string) float64 {
+	if len(words) == 0 {
+		return 0
+	}
+	nameWords := make(map[string]bool)
+	for _, w := range keywords(strings.ReplaceAll(name, "_", " ")) {
+		nameWords[w] = true
+	}
+	descWords := make(map[string]bool)
+	for _, w := range keywords(description) {
+		descWords[w] = true
+	}
+
+	var hits float64
+	for _, w := range words {
+		switch {
+		case nameWords[w]:
+			hits += 2
+		case descWords[w]:
+			hits++
+		}
+	}
+	return hits / float64(2*len(words))
+}
+
+// keywords splits text into lower-case words of three letters or more.
+func keywords(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var words []string
+	for _, f := range fields {
+		if len(f) >= 3 {
+			words = append(words, f)
+		}
+	}
+	return words
+}
+
+// similarities returns the cosine similarity of each candidate to query, or
+// nothing if the backend cannot embed.
+func (s *ToolSelector) similarities(ctx context.Context, query string, candidates []string, tools map[string]base.Tool) map[string]float64 {
+	if s.embedder == nil || query == "" {
+		return nil
+	}
+	// The cached vectors are copied out so the lock is not held while embedding
+	s.mu.Lock()
+	if time.Now().Before(s.embedAfter) {
+		s.mu.Unlock()
+		return nil
+	}
+	texts := []string{query}
+	var missing []string
+	cached := make(map[string][]float32, len(candidates))
+	for _, name := range candidates {
+		key := toolText(name, tools[name])
+		if v, ok := s.vectors[key]; ok {
+			cached[key] = v
+		} else {
+			missing = append(missing, key)
+			texts = append(texts, key)
+		}
+	}
+	s.mu.Unlock()
+
+	vectors, err := s.embedder.Embed(ctx, texts)
+	if err != nil || len(vectors) != len(texts) {
+		fmt.Printf("[Agent]: Selecting tools by keywords only, could not embed: %v\n", err)
+		s.mu.Lock()
+		s.embedAfter = time.Now().Add(toolEmbedRetry)
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Lock()
+	for i, key := range missing {
+		cached[key] = toFloat32(vectors[i+1])
+		s.vectors[key] = cached[key]
+	}
+	s.mu.Unlock()
+
+	q := toFloat32(vectors[0])
+	similarity := make(map[string]float64, len(candidates))
+	for _, name := range candidates {
+		similarity[name] = cosine(q, cached[toolText(name, tools[name])])
+	}
+	return similarity
+}
+
+func toolText(name string, t base.Tool) string {
+	return name + ": " + t.Description()
+}
+
+// offeredTools returns the names of the tools offered in the run, sorted. With
+// a selector they are picked on the first step from the user's input, and grow
+// with the tools the model finds through more_tools.
+func (a *Agent) offeredTools(ctx context.Context, r *run) []string {
+	if a.selector == nil {
+		names := make([]string, 0, len(a.tools))
+		for name := range a.tools {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	r.toolsMu.Lock()
+	defer r.toolsMu.Unlock()
+	if r.tools == nil {
+		query := ""
+		if r.turnStart < len(r.history) {
+			query = r.history[r.turnStart].Content
+		}
+		selected := a.selector.Select(ctx, query, a.tools)
+		if len(selected) < len(a.tools) {
+			selected = append(selected, moreToolsName)
+			sort.Strings(selected)
+		}
+		r.tools = selected
+	}
+	return append([]string(nil), r.tools...)
+}
+
+// moreTools finds tools for the model's query and offers them from the next step on.
+func (a *Agent) moreTools(ctx context.Context, r *run, input string) string {
+	var req struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal([]byte(input), &req); err != nil || req.Query == "" {
+		req.Query = input
+	}
+
+	r.toolsMu.Lock()
+	offered := make(map[string]bool, len(r.tools))
+	for _, name := range r.tools {
+		offered[name] = true
+	}
+	r.toolsMu.Unlock()
+
+	found := a.selector.Search(ctx, req.Query, a.tools, offered, moreToolsResults)
+	if len(found) == 0 {
+		return "No other tools are available."
+	}
+
+	r.toolsMu.Lock()
+	r.tools = append(r.tools, found...)
+	sort.Strings(r.tools)
+	r.toolsMu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("These tools are now available:\n")
+	for _, name := range found {
+		fmt.Fprintf(&b, "- %s: %s\n", name, a.tools[name].Description())
+	}
+	r.record(db.RunStep{Kind: "tool", Tool: moreToolsName, Input: input, Output: b.String(), StartedAt: time.Now()})
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
+)
+
+// record appends a step to the run's trace. Tracing is best effort: a step that
+// cannot be stored is logged and the run goes on.
+func (r *run) record(st db.RunStep) {
+	if r.traces == nil {
+		return
+	}
+	st.RunID = r.id
+	st.SessionID = r.session.id
+	st.Step = r.step
+	if err := r.traces.SaveRunStep(st); err != nil {
+		fmt.Printf("[Agent]: Could not record %s step of run %s: %v\n", st.Kind, r.id, err)
+	}
+}
+
+// recordLLM records a model call with the model that answered it. Only the
+// newest prompt message is kept as its input; the rest of the prompt is the
+// history recorded by earlier steps.
+func (r *run) recordLLM(messages []llm.Message, start time.Time, output string, err error) {
+	st := db.RunStep{Kind: "llm", Model: r.answeredBy(), Output: output, StartedAt: start, Duration: time.Since(start)}
+	if len(messages) > 0 {
+		last := messages[len(messages)-1]
+		st.Input = fmt.Sprintf("[%d messages] %s: %s", len(messages), last.Role, last.Content)
+	}
+	if err != nil {
+		st.Error = err.Error()
+	}
+	r.record(st)
+}
+
+// recordOutcome records how the run ended, timed from its start.
+func (r *run) recordOutcome(result string, err error) {
+	st := db.RunStep{Kind: "final", Model: r.answeredBy(), Output: result, StartedAt: r.started, Duration: time.Since(r.started)}
+	var abortErr *AbortError
+	switch {
+	case errors.As(err, &abortErr):
+		st.Kind = "aborted"
+	case errors.Is(err, ErrRunCancelled):
+		st.Kind = "cancelled"
+	case err != nil:
+		st.Kind = "error"
+	}
+	if err != nil {
+		st.Error = err.Error()
+	}
+	r.record(st)
+}
+
+// replySummary renders a native tool-calling reply as text for the trace.
+func replySummary(reply llm.Message) string {
+	var b strings.Builder
+	b.WriteString(reply.Content)
+	for _, call := range reply.ToolCalls {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "-> %s %s", call.Function.Name, toolInput(call.Function.Arguments))
+	}
+	return b.String()
+}
@@ -31,6 +31,7 @@ func (c *Config) Reload(filePath string) error {
 
 	// Set hardcoded defaults here if necessary, or let tools handle their own defaults
 	c.settings["MODEL"] = "llama3.1"
+	c.settings["LLM_PROVIDER"] = "ollama"
 	c.settings["OLLAMA_URL"] = "http://localhost:11434"
 	c.settings["SWARMUI_PATH"] = "/home/pyromancer/swarmconnector/swarmui"
 	c.settings["SWARMUI_URL"] = "http://localhost:7801"
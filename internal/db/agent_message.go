@@ -0,0 +1,174 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// AgentMessage is a message between agents. Replies point at the message they
+// answer; every message of a conversation shares the ID of its first message.
+type AgentMessage struct {
+	ID        int
+	From      string
+	To        string
+	Content   string
+	InReplyTo int // 0 for the first message of a conversation
+	ThreadID  int
+	Depth     int // Number of messages this one follows from
+	Read      bool
+	CreatedAt time.Time
+}
+
+// AgentThread summarises a conversation between agents.
+type AgentThread struct {
+	ThreadID     int
+	Participants string // Comma-separated agent names
+	Subject      string // The first message, shortened
+	Messages     int
+	LastAt       time.Time
+}
+
+const agentMessageColumns = "id, COALESCE(from_agent, ''), COALESCE(to_agent, ''), COALESCE(content, ''), COALESCE(in_reply_to, 0), COALESCE(thread_id, id), COALESCE(depth, 0), read, created_at"
+
+func scanAgentMessage(row interface{ Scan(...interface{}) error }) (AgentMessage, error) {
+	var m AgentMessage
+	err := row.Scan(&m.ID, &m.From, &m.To, &m.Content, &m.InReplyTo, &m.ThreadID, &m.Depth, &m.Read, &m.CreatedAt)
+	return m, err
+}
+
+// SaveAgentMessage stores a message and returns its ID. ThreadID and Depth are
+// taken from the message replied to, if any.
+func (s *Store) SaveAgentMessage(m AgentMessage) (int, error) {
+	if m.InReplyTo > 0 {
+		parent, err := s.GetAgentMessage(m.InReplyTo)
+		if err != nil {
+			return 0, err
+		}
+		if parent != nil {
+			m.ThreadID = parent.ThreadID
+			if parent.Depth+1 > m.Depth {
+				m.Depth = parent.Depth + 1
+			}
+		}
+	}
+
+	res, err := s.DB.Exec("INSERT INTO agent_messages (from_agent, to_agent, content, in_reply_to, thread_id, depth) VALUES (?, ?, ?, ?, ?, ?)",
+		m.From, m.To, m.Content, m.InReplyTo, m.ThreadID, m.Depth)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if m.ThreadID == 0 {
+		// A new conversation is named after its first message
+		_, err = s.DB.Exec("UPDATE agent_messages SET thread_id = id WHERE id = ?", id)
+	}
+	return int(id), err
+}
+
+func (s *Store) GetAgentMessage(id int) (*AgentMessage, error) {
+	m, err := scanAgentMessage(s.DB.QueryRow("SELECT "+agentMessageColumns+" FROM agent_messages WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetAgentMessagesAfter returns the messages with an ID above afterID, oldest first.
+func (s *Store) GetAgentMessagesAfter(afterID int) ([]AgentMessage, error) {
+	return s.queryAgentMessages("SELECT "+agentMessageColumns+" FROM agent_messages WHERE id > ? ORDER BY id", afterID)
+}
+
+// GetUnreadAgentMessages returns the unread messages of an agent, oldest first.
+func (s *Store) GetUnreadAgentMessages(to string) ([]AgentMessage, error) {
+	return s.queryAgentMessages("SELECT "+agentMessageColumns+" FROM agent_messages WHERE to_agent = ? AND read = 0 ORDER BY id", to)
+}
+
+// GetAgentThread returns the messages of a conversation, oldest first.
+func (s *Store) GetAgentThread(threadID int) ([]AgentMessage, error) {
+	return s.queryAgentMessages("SELECT "+agentMessageColumns+" FROM agent_messages WHERE COALESCE(thread_id, id) = ? ORDER BY id", threadID)
+}
+
+func (s *Store) queryAgentMessages(query string, args ...interface{}) ([]AgentMessage, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []AgentMessage
+	for rows.Next() {
+		m, err := scanAgentMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+func (s *Store) MarkAgentMessageRead(id int) error {
+	_, err := s.DB.Exec("UPDATE agent_messages SET read = 1 WHERE id = ?", id)
+	return err
+}
+
+// LastAgentMessageID returns the ID of the newest message, or 0 if there is none.
+func (s *Store) LastAgentMessageID() (int, error) {
+	var id int
+	err := s.DB.QueryRow("SELECT COALESCE(MAX(id), 0) FROM agent_messages").Scan(&id)
+	return id, err
+}
+
+// ListAgentThreads returns the most recently active conversations between agents.
+func (s *Store) ListAgentThreads(limit int) ([]AgentThread, error) {
+	rows, err := s.DB.Query(`SELECT t.thread, COUNT(*), MAX(m.created_at),
+		(SELECT COALESCE(content, '') FROM agent_messages WHERE id = t.thread)
+		FROM (SELECT id, COALESCE(thread_id, id) AS thread FROM agent_messages) t
+		JOIN agent_messages m ON m.id = t.id
+		GROUP BY t.thread ORDER BY MAX(m.id) DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var threads []AgentThread
+	for rows.Next() {
+		var t AgentThread
+		var lastAt string
+		if err := rows.Scan(&t.ThreadID, &t.Messages, &lastAt, &t.Subject); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t.LastAt, _ = time.Parse("2006-01-02 15:04:05", lastAt)
+		if len(t.Subject) > 80 {
+			t.Subject = t.Subject[:77] + "..."
+		}
+		threads = append(threads, t)
+	}
+	rows.Close()
+
+	// Participants in the order they joined
+	for i := range threads {
+		msgs, err := s.GetAgentThread(threads[i].ThreadID)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		var names []string
+		for _, m := range msgs {
+			for _, name := range []string{m.From, m.To} {
+				if !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+		threads[i].Participants = strings.Join(names, ",")
+	}
+	return threads, nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Approval is a tool call waiting for (or decided by) a human.
+type Approval struct {
+	ID        string
+	SessionID string
+	Tool      string
+	Input     string
+	Status    string // "pending", "approved", "denied", "expired"
+	DecidedBy string
+	CreatedAt time.Time
+}
+
+func (s *Store) SaveApproval(a Approval) error {
+	_, err := s.DB.Exec("INSERT INTO approvals (id, session_id, tool, input, status) VALUES (?, ?, ?, ?, 'pending')",
+		a.ID, a.SessionID, a.Tool, a.Input)
+	return err
+}
+
+// DecideApproval records the outcome of a pending approval.
+func (s *Store) DecideApproval(id, status, decidedBy string) error {
+	_, err := s.DB.Exec("UPDATE approvals SET status = ?, decided_by = ?, decided_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'pending'",
+		status, decidedBy, id)
+	return err
+}
+
+func (s *Store) GetApproval(id string) (*Approval, error) {
+	var a Approval
+	err := s.DB.QueryRow("SELECT id, COALESCE(session_id, ''), tool, COALESCE(input, ''), status, COALESCE(decided_by, ''), created_at FROM approvals WHERE id = ?", id).
+		Scan(&a.ID, &a.SessionID, &a.Tool, &a.Input, &a.Status, &a.DecidedBy, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &a, err
+}
+
+func (s *Store) GetPendingApprovals() ([]Approval, error) {
+	rows, err := s.DB.Query("SELECT id, COALESCE(session_id, ''), tool, COALESCE(input, ''), status, COALESCE(decided_by, ''), created_at FROM approvals WHERE status = 'pending' ORDER BY created_at ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var approvals []Approval
+	for rows.Next() {
+		var a Approval
+		if err := rows.Scan(&a.ID, &a.SessionID, &a.Tool, &a.Input, &a.Status, &a.DecidedBy, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, a)
+	}
+	return approvals, nil
+}
+
+// ExpirePendingApprovals marks every pending approval as expired. The runs
+// waiting for them do not survive a restart.
+func (s *Store) ExpirePendingApprovals() error {
+	_, err := s.DB.Exec("UPDATE approvals SET status = 'expired', decided_at = CURRENT_TIMESTAMP WHERE status = 'pending'")
+	return err
+}
@@ -10,6 +10,7 @@ import (
 
 type Message struct {
 	ID        int
+	SessionID string
 	Role      string
 	Content   string
 	Timestamp time.Time
@@ -32,8 +33,16 @@ func NewStore(dbPath string) (*Store, error) {
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		role TEXT NOT NULL,
 		content TEXT NOT NULL,
+		session_id TEXT DEFAULT 'main',
 		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		channel TEXT NOT NULL, -- "main", "api", "pwa", "tui", "telegram", "schedule", "webhook"
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
 	CREATE TABLE IF NOT EXISTS scheduled_tasks (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		task_type TEXT NOT NULL, -- "one-shot" or "recurring"
@@ -50,11 +59,16 @@ func NewStore(dbPath string) (*Store, error) {
 	CREATE TABLE IF NOT EXISTS sub_agents (
 		id TEXT PRIMARY KEY,
 		prompt TEXT NOT NULL,
-		status TEXT NOT NULL, -- "running", "completed", "failed"
+		status TEXT NOT NULL, -- "queued", "running", "completed", "failed", "cancelled"
 		progress INTEGER DEFAULT 0,
 		result TEXT,
 		model TEXT,
 		personality TEXT,
+		tools TEXT, -- Comma-separated tool names, empty for all
+		timeout TEXT, -- Optional run timeout, e.g. "30m"
+		origin TEXT, -- Session the job was started from, notified when it finishes
+		options TEXT, -- Model options as a JSON object
+		fallbacks TEXT, -- Comma-separated models to fall back to, e.g. "llama3.1:8b,qwen2.5@http://gpu2:11434"
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		finished_at DATETIME
 	);
@@ -62,11 +76,25 @@ func NewStore(dbPath string) (*Store, error) {
 		name TEXT PRIMARY KEY,
 		personality TEXT NOT NULL,
 		tools TEXT NOT NULL, -- Comma-separated list of tool names
-		model TEXT           -- Optional model override
+		model TEXT,          -- Optional model override
+		timeout TEXT,        -- Optional run timeout, e.g. "30m"
+		options TEXT,        -- Optional model options as a JSON object, e.g. {"temperature": 0.2}
+		fallbacks TEXT,      -- Optional comma-separated models to fall back to, in order
+		source TEXT          -- File the definition was loaded from, if any
 	);
 	CREATE TABLE IF NOT EXISTS councils (
 		name TEXT PRIMARY KEY,
-		members TEXT NOT NULL -- Comma-separated list of sub-agent names
+		members TEXT NOT NULL, -- Comma-separated list of sub-agent names
+		rounds INTEGER DEFAULT 0,            -- Discussion rounds; 0 for the default
+		speaking_order TEXT,                 -- "sequential", "random" or "parallel"
+		moderator TEXT,                      -- Optional sub-agent that delivers the verdict
+		voting BOOLEAN DEFAULT 0,            -- Members vote on the proposed options
+		stop_on_consensus BOOLEAN DEFAULT 0, -- End the discussion early once members agree
+		source TEXT                          -- File the council was loaded from, if any
+	);
+	CREATE TABLE IF NOT EXISTS swarms (
+		name TEXT PRIMARY KEY,
+		members TEXT NOT NULL -- Comma-separated role=agent pairs, e.g. "coder=dev,reviewer=critic"
 	);
 	CREATE TABLE IF NOT EXISTS rss_feeds (
 		url TEXT PRIMARY KEY,
@@ -92,7 +120,7 @@ func NewStore(dbPath string) (*Store, error) {
 		parent_id TEXT,
 		title TEXT NOT NULL,
 		description TEXT,
-		status TEXT DEFAULT 'pending',
+		status TEXT DEFAULT 'pending', -- "pending", "in_progress", "completed", "failed", "cancelled", "skipped"
 		assigned_agent TEXT,
 		result TEXT,
 		FOREIGN KEY(project_id) REFERENCES projects(id),
@@ -111,6 +139,9 @@ func NewStore(dbPath string) (*Store, error) {
 		content TEXT NOT NULL,
 		type TEXT DEFAULT 'fact', -- fact, preference, observation
 		tags TEXT,
+		importance INTEGER DEFAULT 3, -- 1 (trivia) to 5 (essential)
+		embedding BLOB,               -- Little-endian float32 vector
+		embed_model TEXT,             -- Model that produced the embedding
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 	CREATE TABLE IF NOT EXISTS graph_nodes (
@@ -140,8 +171,45 @@ func NewStore(dbPath string) (*Store, error) {
 		to_agent TEXT,
 		content TEXT,
 		read BOOLEAN DEFAULT 0,
+		in_reply_to INTEGER DEFAULT 0, -- Message this one answers
+		thread_id INTEGER,             -- First message of the conversation
+		depth INTEGER DEFAULT 0,       -- Messages this one follows from, for loop protection
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
+	CREATE TABLE IF NOT EXISTS approvals (
+		id TEXT PRIMARY KEY,
+		session_id TEXT,
+		tool TEXT NOT NULL,
+		input TEXT,
+		status TEXT DEFAULT 'pending', -- "pending", "approved", "denied", "expired"
+		decided_by TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		decided_at DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT NOT NULL,
+		channel TEXT NOT NULL,
+		job_id TEXT NOT NULL,
+		kind TEXT NOT NULL, -- "subagent", "council", "swarm" or "reply"
+		status TEXT NOT NULL,
+		summary TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS run_steps (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		run_id TEXT NOT NULL,
+		session_id TEXT,
+		step INTEGER,
+		kind TEXT NOT NULL, -- "input", "llm", "tool", "final", "aborted", "cancelled", "error"
+		model TEXT,
+		tool TEXT,
+		input TEXT,
+		output TEXT,
+		error TEXT,
+		started_at DATETIME,
+		duration_ms INTEGER DEFAULT 0
+	);
 	CREATE TABLE IF NOT EXISTS webhooks (
 		id TEXT PRIMARY KEY,
 		name TEXT,
@@ -159,6 +227,32 @@ func NewStore(dbPath string) (*Store, error) {
 	_, _ = db.Exec("ALTER TABLE scheduled_tasks ADD COLUMN target_name TEXT")
 	_, _ = db.Exec("ALTER TABLE sub_agents ADD COLUMN model TEXT")
 	_, _ = db.Exec("ALTER TABLE sub_agents ADD COLUMN personality TEXT")
+	_, _ = db.Exec("ALTER TABLE sub_agents ADD COLUMN tools TEXT")
+	_, _ = db.Exec("ALTER TABLE sub_agents ADD COLUMN timeout TEXT")
+	_, _ = db.Exec("ALTER TABLE sub_agent_definitions ADD COLUMN timeout TEXT")
+	_, _ = db.Exec("ALTER TABLE sub_agents ADD COLUMN origin TEXT")
+	_, _ = db.Exec("ALTER TABLE sub_agents ADD COLUMN options TEXT")
+	_, _ = db.Exec("ALTER TABLE memories ADD COLUMN importance INTEGER DEFAULT 3")
+	_, _ = db.Exec("ALTER TABLE memories ADD COLUMN embedding BLOB")
+	_, _ = db.Exec("ALTER TABLE memories ADD COLUMN embed_model TEXT")
+	_, _ = db.Exec("ALTER TABLE councils ADD COLUMN rounds INTEGER DEFAULT 0")
+	_, _ = db.Exec("ALTER TABLE councils ADD COLUMN speaking_order TEXT")
+	_, _ = db.Exec("ALTER TABLE councils ADD COLUMN moderator TEXT")
+	_, _ = db.Exec("ALTER TABLE councils ADD COLUMN voting BOOLEAN DEFAULT 0")
+	_, _ = db.Exec("ALTER TABLE councils ADD COLUMN stop_on_consensus BOOLEAN DEFAULT 0")
+	_, _ = db.Exec("ALTER TABLE councils ADD COLUMN source TEXT")
+	_, _ = db.Exec("ALTER TABLE sub_agent_definitions ADD COLUMN options TEXT")
+	_, _ = db.Exec("ALTER TABLE sub_agent_definitions ADD COLUMN source TEXT")
+	_, _ = db.Exec("ALTER TABLE sub_agent_definitions ADD COLUMN fallbacks TEXT")
+	_, _ = db.Exec("ALTER TABLE sub_agents ADD COLUMN fallbacks TEXT")
+	_, _ = db.Exec("ALTER TABLE agent_messages ADD COLUMN in_reply_to INTEGER DEFAULT 0")
+	_, _ = db.Exec("ALTER TABLE agent_messages ADD COLUMN thread_id INTEGER")
+	_, _ = db.Exec("ALTER TABLE agent_messages ADD COLUMN depth INTEGER DEFAULT 0")
+	// Messages stored before sessions existed belong to the default session
+	_, _ = db.Exec("ALTER TABLE messages ADD COLUMN session_id TEXT DEFAULT 'main'")
+	_, _ = db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_session ON messages(session_id, timestamp)")
+	_, _ = db.Exec("CREATE INDEX IF NOT EXISTS idx_run_steps_run ON run_steps(run_id, id)")
+	_, _ = db.Exec("INSERT OR IGNORE INTO sessions (id, name, channel) VALUES (?, 'Main', ?)", DefaultSessionID, DefaultSessionID)
 
 	return &Store{DB: db}, nil
 }
@@ -220,7 +314,7 @@ func (s *Store) SaveTask(t Task) error {
 }
 
 func (s *Store) GetTasks(projectID string) ([]Task, error) {
-	rows, err := s.DB.Query("SELECT id, project_id, COALESCE(parent_id, ''), title, description, status, COALESCE(assigned_agent, ''), COALESCE(result, '') FROM tasks WHERE project_id = ?", projectID)
+	rows, err := s.DB.Query("SELECT id, project_id, COALESCE(parent_id, ''), title, description, status, COALESCE(assigned_agent, ''), COALESCE(result, '') FROM tasks WHERE project_id = ? ORDER BY rowid", projectID)
 	if err != nil {
 		return nil, err
 	}
@@ -241,6 +335,16 @@ func (s *Store) AssignAgentToTask(taskID, agentName string) error {
 	return err
 }
 
+func (s *Store) UpdateProjectStatus(id, status string) error {
+	_, err := s.DB.Exec("UPDATE projects SET status = ? WHERE id = ?", status, id)
+	return err
+}
+
+func (s *Store) UpdateTaskStatus(id, status, result string) error {
+	_, err := s.DB.Exec("UPDATE tasks SET status = ?, result = ? WHERE id = ?", status, result, id)
+	return err
+}
+
 func (s *Store) SaveKnowledge(k KnowledgeEntry) error {
 	_, err := s.DB.Exec("INSERT OR REPLACE INTO knowledge_base (key, category, content, tags, updated_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)",
 		k.Key, k.Category, k.Content, k.Tags)
@@ -345,17 +449,32 @@ func (s *Store) MarkRSSItemProcessed(guid, feedURL string) error {
 }
 
 type Council struct {
-	Name    string
-	Members string
+	Name            string
+	Members         string
+	Rounds          int    // Discussion rounds; 0 for the default
+	Order           string // Speaking order: "sequential" (default), "random" or "parallel"
+	Moderator       string // Optional sub-agent that sums up the session into a verdict
+	Voting          bool   // Members vote on the options proposed during the discussion
+	StopOnConsensus bool   // End the discussion once the members agree
+	Source          string // File the council was loaded from, if any
 }
 
-func (s *Store) SaveCouncil(name, members string) error {
-	_, err := s.DB.Exec("INSERT OR REPLACE INTO councils (name, members) VALUES (?, ?)", name, members)
+const councilColumns = "name, members, COALESCE(rounds, 0), COALESCE(speaking_order, ''), COALESCE(moderator, ''), COALESCE(voting, 0), COALESCE(stop_on_consensus, 0), COALESCE(source, '')"
+
+func scanCouncil(row interface{ Scan(...interface{}) error }) (Council, error) {
+	var c Council
+	err := row.Scan(&c.Name, &c.Members, &c.Rounds, &c.Order, &c.Moderator, &c.Voting, &c.StopOnConsensus, &c.Source)
+	return c, err
+}
+
+func (s *Store) SaveCouncil(c Council) error {
+	_, err := s.DB.Exec("INSERT OR REPLACE INTO councils (name, members, rounds, speaking_order, moderator, voting, stop_on_consensus, source) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		c.Name, c.Members, c.Rounds, c.Order, c.Moderator, c.Voting, c.StopOnConsensus, c.Source)
 	return err
 }
 
 func (s *Store) GetCouncils() ([]Council, error) {
-	rows, err := s.DB.Query("SELECT name, members FROM councils")
+	rows, err := s.DB.Query("SELECT " + councilColumns + " FROM councils")
 	if err != nil {
 		return nil, err
 	}
@@ -363,8 +482,8 @@ func (s *Store) GetCouncils() ([]Council, error) {
 
 	var councils []Council
 	for rows.Next() {
-		var c Council
-		if err := rows.Scan(&c.Name, &c.Members); err != nil {
+		c, err := scanCouncil(rows)
+		if err != nil {
 			return nil, err
 		}
 		councils = append(councils, c)
@@ -373,8 +492,7 @@ func (s *Store) GetCouncils() ([]Council, error) {
 }
 
 func (s *Store) GetCouncil(name string) (*Council, error) {
-	var c Council
-	err := s.DB.QueryRow("SELECT name, members FROM councils WHERE name = ?", name).Scan(&c.Name, &c.Members)
+	c, err := scanCouncil(s.DB.QueryRow("SELECT "+councilColumns+" FROM councils WHERE name = ?", name))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -391,15 +509,28 @@ type SubAgentDefinition struct {
 	Personality string
 	Tools       string
 	Model       string
+	Timeout     string // Optional run timeout, e.g. "30m"
+	Options     string // Optional model options as a JSON object
+	Fallbacks   string // Optional comma-separated models to fall back to, in order
+	Source      string // File the definition was loaded from, if any
+}
+
+const subAgentDefinitionColumns = "name, personality, tools, COALESCE(model, ''), COALESCE(timeout, ''), COALESCE(options, ''), COALESCE(fallbacks, ''), COALESCE(source, '')"
+
+func scanSubAgentDefinition(row interface{ Scan(...interface{}) error }) (SubAgentDefinition, error) {
+	var d SubAgentDefinition
+	err := row.Scan(&d.Name, &d.Personality, &d.Tools, &d.Model, &d.Timeout, &d.Options, &d.Fallbacks, &d.Source)
+	return d, err
 }
 
-func (s *Store) SaveSubAgentDefinition(name, personality, tools, model string) error {
-	_, err := s.DB.Exec("INSERT OR REPLACE INTO sub_agent_definitions (name, personality, tools, model) VALUES (?, ?, ?, ?)", name, personality, tools, model)
+func (s *Store) SaveSubAgentDefinition(d SubAgentDefinition) error {
+	_, err := s.DB.Exec("INSERT OR REPLACE INTO sub_agent_definitions (name, personality, tools, model, timeout, options, fallbacks, source) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		d.Name, d.Personality, d.Tools, d.Model, d.Timeout, d.Options, d.Fallbacks, d.Source)
 	return err
 }
 
 func (s *Store) GetSubAgentDefinitions() ([]SubAgentDefinition, error) {
-	rows, err := s.DB.Query("SELECT name, personality, tools, COALESCE(model, '') FROM sub_agent_definitions")
+	rows, err := s.DB.Query("SELECT " + subAgentDefinitionColumns + " FROM sub_agent_definitions")
 	if err != nil {
 		return nil, err
 	}
@@ -407,8 +538,8 @@ func (s *Store) GetSubAgentDefinitions() ([]SubAgentDefinition, error) {
 
 	var defs []SubAgentDefinition
 	for rows.Next() {
-		var d SubAgentDefinition
-		if err := rows.Scan(&d.Name, &d.Personality, &d.Tools, &d.Model); err != nil {
+		d, err := scanSubAgentDefinition(rows)
+		if err != nil {
 			return nil, err
 		}
 		defs = append(defs, d)
@@ -417,14 +548,18 @@ func (s *Store) GetSubAgentDefinitions() ([]SubAgentDefinition, error) {
 }
 
 func (s *Store) GetSubAgentDefinition(name string) (*SubAgentDefinition, error) {
-	var d SubAgentDefinition
-	err := s.DB.QueryRow("SELECT name, personality, tools, COALESCE(model, '') FROM sub_agent_definitions WHERE name = ?", name).Scan(&d.Name, &d.Personality, &d.Tools, &d.Model)
+	d, err := scanSubAgentDefinition(s.DB.QueryRow("SELECT "+subAgentDefinitionColumns+" FROM sub_agent_definitions WHERE name = ?", name))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	return &d, err
 }
 
+func (s *Store) DeleteSubAgentDefinition(name string) error {
+	_, err := s.DB.Exec("DELETE FROM sub_agent_definitions WHERE name = ?", name)
+	return err
+}
+
 type SubAgentTask struct {
 	ID          string
 	Prompt      string
@@ -433,12 +568,61 @@ type SubAgentTask struct {
 	Result      string
 	Model       string
 	Personality string
+	Tools       string
+	Timeout     string
+	Origin      string // Session that started the job
+	Options     string // Model options as a JSON object
+	Fallbacks   string // Comma-separated models to fall back to
 	CreatedAt   time.Time
 	FinishedAt  *time.Time
 }
 
-func (s *Store) SaveSubAgent(id, prompt, status, model, personality string) error {
-	_, err := s.DB.Exec("INSERT INTO sub_agents (id, prompt, status, progress, model, personality) VALUES (?, ?, ?, 0, ?, ?)", id, prompt, status, model, personality)
+const subAgentColumns = "id, prompt, status, progress, COALESCE(result, ''), COALESCE(model, ''), COALESCE(personality, ''), COALESCE(tools, ''), COALESCE(timeout, ''), COALESCE(origin, ''), COALESCE(options, ''), COALESCE(fallbacks, ''), created_at, finished_at"
+
+func scanSubAgent(row interface{ Scan(...interface{}) error }) (SubAgentTask, error) {
+	var t SubAgentTask
+	err := row.Scan(&t.ID, &t.Prompt, &t.Status, &t.Progress, &t.Result, &t.Model, &t.Personality, &t.Tools, &t.Timeout, &t.Origin, &t.Options, &t.Fallbacks, &t.CreatedAt, &t.FinishedAt)
+	return t, err
+}
+
+func (s *Store) SaveSubAgent(t SubAgentTask) error {
+	_, err := s.DB.Exec("INSERT INTO sub_agents (id, prompt, status, progress, model, personality, tools, timeout, origin, options, fallbacks) VALUES (?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?)",
+		t.ID, t.Prompt, t.Status, t.Model, t.Personality, t.Tools, t.Timeout, t.Origin, t.Options, t.Fallbacks)
+	return err
+}
+
+func (s *Store) GetSubAgent(id string) (*SubAgentTask, error) {
+	t, err := scanSubAgent(s.DB.QueryRow("SELECT "+subAgentColumns+" FROM sub_agents WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ReopenSubAgent queues a sub-agent again for a follow-up message. Its last
+// result is kept until the follow-up finishes. A non-empty origin replaces the
+// session notified when it does.
+func (s *Store) ReopenSubAgent(id, origin string) error {
+	_, err := s.DB.Exec("UPDATE sub_agents SET status = 'queued', progress = 0, finished_at = NULL, origin = CASE WHEN ? = '' THEN origin ELSE ? END WHERE id = ?", origin, origin, id)
+	return err
+}
+
+// SetSubAgentOrigin changes the session notified when a sub-agent finishes,
+// leaving its status alone.
+func (s *Store) SetSubAgentOrigin(id, origin string) error {
+	if origin == "" {
+		return nil
+	}
+	_, err := s.DB.Exec("UPDATE sub_agents SET origin = ? WHERE id = ?", origin, id)
+	return err
+}
+
+// StartSubAgent marks a queued sub-agent as running.
+func (s *Store) StartSubAgent(id string) error {
+	_, err := s.DB.Exec("UPDATE sub_agents SET status = 'running' WHERE id = ?", id)
 	return err
 }
 
@@ -453,7 +637,7 @@ func (s *Store) UpdateSubAgent(id, status, result string) error {
 }
 
 func (s *Store) GetActiveSubAgents() ([]SubAgentTask, error) {
-	rows, err := s.DB.Query("SELECT id, prompt, status, progress, COALESCE(result, ''), COALESCE(model, ''), COALESCE(personality, ''), created_at, finished_at FROM sub_agents WHERE status = 'running' ORDER BY created_at DESC")
+	rows, err := s.DB.Query("SELECT " + subAgentColumns + " FROM sub_agents WHERE status IN ('queued', 'running') ORDER BY created_at DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -461,8 +645,8 @@ func (s *Store) GetActiveSubAgents() ([]SubAgentTask, error) {
 
 	var tasks []SubAgentTask
 	for rows.Next() {
-		var t SubAgentTask
-		if err := rows.Scan(&t.ID, &t.Prompt, &t.Status, &t.Progress, &t.Result, &t.Model, &t.Personality, &t.CreatedAt, &t.FinishedAt); err != nil {
+		t, err := scanSubAgent(rows)
+		if err != nil {
 			return nil, err
 		}
 		tasks = append(tasks, t)
@@ -471,7 +655,7 @@ func (s *Store) GetActiveSubAgents() ([]SubAgentTask, error) {
 }
 
 func (s *Store) GetSubAgents() ([]SubAgentTask, error) {
-	rows, err := s.DB.Query("SELECT id, prompt, status, progress, COALESCE(result, ''), COALESCE(model, ''), COALESCE(personality, ''), created_at, finished_at FROM sub_agents ORDER BY created_at DESC")
+	rows, err := s.DB.Query("SELECT " + subAgentColumns + " FROM sub_agents ORDER BY created_at DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -479,8 +663,8 @@ func (s *Store) GetSubAgents() ([]SubAgentTask, error) {
 
 	var tasks []SubAgentTask
 	for rows.Next() {
-		var t SubAgentTask
-		if err := rows.Scan(&t.ID, &t.Prompt, &t.Status, &t.Progress, &t.Result, &t.Model, &t.Personality, &t.CreatedAt, &t.FinishedAt); err != nil {
+		t, err := scanSubAgent(rows)
+		if err != nil {
 			return nil, err
 		}
 		tasks = append(tasks, t)
@@ -545,15 +729,19 @@ func (s *Store) DeleteTask(id int) error {
 	return err
 }
 
-// SaveMessage persists a message into the database.
-func (s *Store) SaveMessage(role, content string) error {
-	_, err := s.DB.Exec("INSERT INTO messages (role, content) VALUES (?, ?)", role, content)
+// SaveMessage persists a message of the given session into the database.
+func (s *Store) SaveMessage(sessionID, role, content string) error {
+	_, err := s.DB.Exec("INSERT INTO messages (session_id, role, content) VALUES (?, ?, ?)", sessionID, role, content)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec("UPDATE sessions SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", sessionID)
 	return err
 }
 
-// LoadLastMessages retrieves the most recent n messages.
-func (s *Store) LoadLastMessages(limit int) ([]Message, error) {
-	rows, err := s.DB.Query("SELECT id, role, content, timestamp FROM messages ORDER BY timestamp DESC LIMIT ?", limit)
+// LoadLastMessages retrieves the most recent n messages of a session.
+func (s *Store) LoadLastMessages(sessionID string, limit int) ([]Message, error) {
+	rows, err := s.DB.Query("SELECT id, session_id, role, content, timestamp FROM messages WHERE session_id = ? ORDER BY timestamp DESC, id DESC LIMIT ?", sessionID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -562,7 +750,7 @@ func (s *Store) LoadLastMessages(limit int) ([]Message, error) {
 	var msgs []Message
 	for rows.Next() {
 		var m Message
-		if err := rows.Scan(&m.ID, &m.Role, &m.Content, &m.Timestamp); err != nil {
+		if err := rows.Scan(&m.ID, &m.SessionID, &m.Role, &m.Content, &m.Timestamp); err != nil {
 			return nil, err
 		}
 		// prepend to keep order correct
@@ -572,8 +760,8 @@ func (s *Store) LoadLastMessages(limit int) ([]Message, error) {
 	return msgs, nil
 }
 
-func (s *Store) GetOldestMessages(limit int) ([]Message, error) {
-	rows, err := s.DB.Query("SELECT id, role, content, timestamp FROM messages ORDER BY timestamp ASC LIMIT ?", limit)
+func (s *Store) GetOldestMessages(sessionID string, limit int) ([]Message, error) {
+	rows, err := s.DB.Query("SELECT id, session_id, role, content, timestamp FROM messages WHERE session_id = ? ORDER BY timestamp ASC, id ASC LIMIT ?", sessionID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -582,7 +770,7 @@ func (s *Store) GetOldestMessages(limit int) ([]Message, error) {
 	var msgs []Message
 	for rows.Next() {
 		var m Message
-		if err := rows.Scan(&m.ID, &m.Role, &m.Content, &m.Timestamp); err != nil {
+		if err := rows.Scan(&m.ID, &m.SessionID, &m.Role, &m.Content, &m.Timestamp); err != nil {
 			return nil, err
 		}
 		msgs = append(msgs, m)
@@ -1,26 +1,38 @@
 package db
 
 import (
+	"encoding/binary"
+	"math"
 	"time"
 )
 
+// DefaultMemoryImportance is the importance of memories saved without one.
+const DefaultMemoryImportance = 3
+
 type Memory struct {
-	ID        int
-	Content   string
-	Type      string
-	Tags      string
-	CreatedAt time.Time
+	ID         int
+	Content    string
+	Type       string
+	Tags       string
+	Importance int // 1 (trivia) to 5 (essential)
+	CreatedAt  time.Time
+	Embedding  []float32 // Nil until the memory is embedded
+	EmbedModel string    // Model that produced Embedding
+	Score      float64   // Relevance to the query, set by searches
 }
 
-func (s *Store) SaveMemory(content, memType, tags string) error {
-	_, err := s.DB.Exec("INSERT INTO memories (content, type, tags) VALUES (?, ?, ?)", content, memType, tags)
-	return err
+const memoryColumns = "id, content, COALESCE(type, ''), COALESCE(tags, ''), COALESCE(importance, 3), created_at, embedding, COALESCE(embed_model, '')"
+
+func scanMemory(row interface{ Scan(...interface{}) error }) (Memory, error) {
+	var m Memory
+	var embedding []byte
+	err := row.Scan(&m.ID, &m.Content, &m.Type, &m.Tags, &m.Importance, &m.CreatedAt, &embedding, &m.EmbedModel)
+	m.Embedding = decodeEmbedding(embedding)
+	return m, err
 }
 
-func (s *Store) SearchMemories(query string, limit int) ([]Memory, error) {
-	// Simple LIKE search for now
-	rows, err := s.DB.Query("SELECT id, content, type, tags, created_at FROM memories WHERE content LIKE ? OR tags LIKE ? ORDER BY created_at DESC LIMIT ?", 
-		"%"+query+"%", "%"+query+"%", limit)
+func (s *Store) queryMemories(query string, args ...interface{}) ([]Memory, error) {
+	rows, err := s.DB.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -28,8 +40,8 @@ func (s *Store) SearchMemories(query string, limit int) ([]Memory, error) {
 
 	var memories []Memory
 	for rows.Next() {
-		var m Memory
-		if err := rows.Scan(&m.ID, &m.Content, &m.Type, &m.Tags, &m.CreatedAt); err != nil {
+		m, err := scanMemory(rows)
+		if err != nil {
 			return nil, err
 		}
 		memories = append(memories, m)
@@ -37,20 +49,65 @@ func (s *Store) SearchMemories(query string, limit int) ([]Memory, error) {
 	return memories, nil
 }
 
-func (s *Store) GetAllMemories() ([]Memory, error) {
-	rows, err := s.DB.Query("SELECT id, content, type, tags, created_at FROM memories ORDER BY created_at DESC")
+// SaveMemory stores a memory without embedding and returns its ID.
+func (s *Store) SaveMemory(m Memory) (int, error) {
+	if m.Type == "" {
+		m.Type = "fact"
+	}
+	if m.Importance == 0 {
+		m.Importance = DefaultMemoryImportance
+	}
+	res, err := s.DB.Exec("INSERT INTO memories (content, type, tags, importance) VALUES (?, ?, ?, ?)", m.Content, m.Type, m.Tags, m.Importance)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer rows.Close()
+	id, err := res.LastInsertId()
+	return int(id), err
+}
 
-	var memories []Memory
-	for rows.Next() {
-		var m Memory
-		if err := rows.Scan(&m.ID, &m.Content, &m.Type, &m.Tags, &m.CreatedAt); err != nil {
-			return nil, err
-		}
-		memories = append(memories, m)
+// SetMemoryEmbedding stores the embedding of a memory computed with model.
+func (s *Store) SetMemoryEmbedding(id int, model string, embedding []float32) error {
+	_, err := s.DB.Exec("UPDATE memories SET embedding = ?, embed_model = ? WHERE id = ?", encodeEmbedding(embedding), model, id)
+	return err
+}
+
+// SearchMemories finds memories whose content or tags contain query, newest
+// first. An empty query returns the newest memories.
+func (s *Store) SearchMemories(query string, limit int) ([]Memory, error) {
+	return s.queryMemories("SELECT "+memoryColumns+" FROM memories WHERE content LIKE ? OR tags LIKE ? ORDER BY created_at DESC LIMIT ?",
+		"%"+query+"%", "%"+query+"%", limit)
+}
+
+// GetEmbeddedMemories returns the memories embedded with model.
+func (s *Store) GetEmbeddedMemories(model string) ([]Memory, error) {
+	return s.queryMemories("SELECT "+memoryColumns+" FROM memories WHERE embedding IS NOT NULL AND embed_model = ?", model)
+}
+
+// GetUnembeddedMemories returns up to limit memories not yet embedded with model.
+func (s *Store) GetUnembeddedMemories(model string, limit int) ([]Memory, error) {
+	return s.queryMemories("SELECT "+memoryColumns+" FROM memories WHERE embedding IS NULL OR COALESCE(embed_model, '') != ? ORDER BY id LIMIT ?", model, limit)
+}
+
+func (s *Store) GetAllMemories() ([]Memory, error) {
+	return s.queryMemories("SELECT " + memoryColumns + " FROM memories ORDER BY created_at DESC")
+}
+
+// encodeEmbedding packs a vector as little-endian float32s.
+func encodeEmbedding(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
 	}
-	return memories, nil
+	return b
+}
+
+func decodeEmbedding(b []byte) []float32 {
+	if len(b) == 0 {
+		return nil
+	}
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
 }
@@ -0,0 +1,59 @@
+package db
+
+import "time"
+
+// Notification tells a session that a background job it started has finished,
+// or carries the agent's follow-up on such a job.
+type Notification struct {
+	ID        int
+	SessionID string
+	Channel   string
+	JobID     string
+	Kind      string // "subagent", "council", "swarm" or "reply"
+	Status    string // Final status of the job
+	Summary   string
+	CreatedAt time.Time
+}
+
+func (s *Store) SaveNotification(n Notification) (int, error) {
+	res, err := s.DB.Exec("INSERT INTO notifications (session_id, channel, job_id, kind, status, summary) VALUES (?, ?, ?, ?, ?, ?)",
+		n.SessionID, SessionChannel(n.SessionID), n.JobID, n.Kind, n.Status, n.Summary)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// GetNotifications returns the notifications after the given ID, oldest first,
+// for a session or, if sessionID is empty, for every session of a channel.
+func (s *Store) GetNotifications(sessionID, channel string, afterID, limit int) ([]Notification, error) {
+	query := "SELECT id, session_id, channel, job_id, kind, status, COALESCE(summary, ''), created_at FROM notifications WHERE id > ?"
+	args := []interface{}{afterID}
+	if sessionID != "" {
+		query += " AND session_id = ?"
+		args = append(args, sessionID)
+	} else if channel != "" {
+		query += " AND channel = ?"
+		args = append(args, channel)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.SessionID, &n.Channel, &n.JobID, &n.Kind, &n.Status, &n.Summary, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		// prepend to keep order correct
+		notifications = append([]Notification{n}, notifications...)
+	}
+	return notifications, nil
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// DefaultSessionID is the session that existing and unscoped messages belong to.
+const DefaultSessionID = "main"
+
+// Session is a named conversation with its own message history.
+type Session struct {
+	ID        string
+	Name      string
+	Channel   string // "main", "api", "pwa", "tui", "telegram", "schedule", "webhook", "subagent"
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// SessionChannel derives the channel from a session ID of the form
+// "<channel>:<key>" (e.g. "telegram:12345"). Other IDs belong to "api".
+func SessionChannel(id string) string {
+	if id == DefaultSessionID {
+		return DefaultSessionID
+	}
+	if i := strings.Index(id, ":"); i > 0 {
+		return id[:i]
+	}
+	return "api"
+}
+
+func (s *Store) CreateSession(id, name, channel string) error {
+	_, err := s.DB.Exec("INSERT INTO sessions (id, name, channel) VALUES (?, ?, ?)", id, name, channel)
+	return err
+}
+
+// EnsureSession creates the session if it does not exist yet, naming it after its ID.
+func (s *Store) EnsureSession(id string) error {
+	_, err := s.DB.Exec("INSERT OR IGNORE INTO sessions (id, name, channel) VALUES (?, ?, ?)", id, id, SessionChannel(id))
+	return err
+}
+
+func (s *Store) GetSession(id string) (*Session, error) {
+	var ss Session
+	err := s.DB.QueryRow("SELECT id, name, channel, created_at, updated_at FROM sessions WHERE id = ?", id).
+		Scan(&ss.ID, &ss.Name, &ss.Channel, &ss.CreatedAt, &ss.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &ss, err
+}
+
+// ListSessions returns the sessions of a channel, most recently active first.
+// "" lists every channel but "subagent", whose conversations are shown with
+// their sub-agents rather than as sessions.
+func (s *Store) ListSessions(channel string) ([]Session, error) {
+	query := "SELECT id, name, channel, created_at, updated_at FROM sessions"
+	var args []interface{}
+	if channel != "" {
+		query += " WHERE channel = ?"
+		args = append(args, channel)
+	} else {
+		query += " WHERE channel != 'subagent'"
+	}
+	query += " ORDER BY updated_at DESC"
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var ss Session
+		if err := rows.Scan(&ss.ID, &ss.Name, &ss.Channel, &ss.CreatedAt, &ss.UpdatedAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, ss)
+	}
+	return sessions, nil
+}
+
+func (s *Store) RenameSession(id, name string) error {
+	_, err := s.DB.Exec("UPDATE sessions SET name = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", name, id)
+	return err
+}
+
+// DeleteSession removes a session together with its messages.
+func (s *Store) DeleteSession(id string) error {
+	if _, err := s.DB.Exec("DELETE FROM messages WHERE session_id = ?", id); err != nil {
+		return err
+	}
+	_, err := s.DB.Exec("DELETE FROM sessions WHERE id = ?", id)
+	return err
+}
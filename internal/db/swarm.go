@@ -0,0 +1,69 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Swarm is a team of sub-agents, each playing a role such as coder or reviewer.
+type Swarm struct {
+	Name    string
+	Members string // Comma-separated role=agent pairs
+}
+
+// SwarmMember is the sub-agent definition playing a role in a swarm.
+type SwarmMember struct {
+	Role  string
+	Agent string
+}
+
+// Roles parses the swarm's members in the order they were defined.
+func (sw Swarm) Roles() []SwarmMember {
+	var members []SwarmMember
+	for _, pair := range strings.Split(sw.Members, ",") {
+		role, agent, ok := strings.Cut(pair, "=")
+		role, agent = strings.TrimSpace(role), strings.TrimSpace(agent)
+		if !ok || role == "" || agent == "" {
+			continue
+		}
+		members = append(members, SwarmMember{Role: role, Agent: agent})
+	}
+	return members
+}
+
+func (s *Store) SaveSwarm(name, members string) error {
+	_, err := s.DB.Exec("INSERT OR REPLACE INTO swarms (name, members) VALUES (?, ?)", name, members)
+	return err
+}
+
+func (s *Store) GetSwarms() ([]Swarm, error) {
+	rows, err := s.DB.Query("SELECT name, members FROM swarms ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var swarms []Swarm
+	for rows.Next() {
+		var sw Swarm
+		if err := rows.Scan(&sw.Name, &sw.Members); err != nil {
+			return nil, err
+		}
+		swarms = append(swarms, sw)
+	}
+	return swarms, nil
+}
+
+func (s *Store) GetSwarm(name string) (*Swarm, error) {
+	var sw Swarm
+	err := s.DB.QueryRow("SELECT name, members FROM swarms WHERE name = ?", name).Scan(&sw.Name, &sw.Members)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &sw, err
+}
+
+func (s *Store) DeleteSwarm(name string) error {
+	_, err := s.DB.Exec("DELETE FROM swarms WHERE name = ?", name)
+	return err
+}
@@ -0,0 +1,93 @@
+package db
+
+import "time"
+
+// RunStep is one recorded step of an agent run: the user input, an LLM call,
+// a tool call or the run's outcome.
+type RunStep struct {
+	ID        int
+	RunID     string
+	SessionID string
+	Step      int
+	Kind      string // "input", "llm", "tool", "final", "aborted", "cancelled", "error"
+	Model     string
+	Tool      string
+	Input     string
+	Output    string
+	Error     string
+	StartedAt time.Time
+	Duration  time.Duration
+}
+
+// RunSummary describes a recorded run.
+type RunSummary struct {
+	RunID     string
+	SessionID string
+	Input     string // The user input that started the run
+	Outcome   string // Kind of the last step, e.g. "final" or "error"
+	Steps     int
+	StartedAt time.Time
+}
+
+func (s *Store) SaveRunStep(st RunStep) error {
+	_, err := s.DB.Exec(`INSERT INTO run_steps (run_id, session_id, step, kind, model, tool, input, output, error, started_at, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		st.RunID, st.SessionID, st.Step, st.Kind, st.Model, st.Tool, st.Input, st.Output, st.Error, st.StartedAt, st.Duration.Milliseconds())
+	return err
+}
+
+// GetRunSteps returns the trace of a run in the order it was recorded.
+func (s *Store) GetRunSteps(runID string) ([]RunStep, error) {
+	rows, err := s.DB.Query(`SELECT id, run_id, COALESCE(session_id, ''), step, kind, COALESCE(model, ''), COALESCE(tool, ''),
+		COALESCE(input, ''), COALESCE(output, ''), COALESCE(error, ''), started_at, duration_ms
+		FROM run_steps WHERE run_id = ? ORDER BY id ASC`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []RunStep
+	for rows.Next() {
+		var st RunStep
+		var ms int64
+		if err := rows.Scan(&st.ID, &st.RunID, &st.SessionID, &st.Step, &st.Kind, &st.Model, &st.Tool,
+			&st.Input, &st.Output, &st.Error, &st.StartedAt, &ms); err != nil {
+			return nil, err
+		}
+		st.Duration = time.Duration(ms) * time.Millisecond
+		steps = append(steps, st)
+	}
+	return steps, nil
+}
+
+// ListRecentRuns returns the most recent recorded runs of a session ("" for all), newest first.
+func (s *Store) ListRecentRuns(sessionID string, limit int) ([]RunSummary, error) {
+	// Every run starts with its "input" step
+	query := `SELECT f.run_id, COALESCE(f.session_id, ''), COALESCE(f.input, ''), f.started_at,
+		(SELECT COUNT(*) FROM run_steps WHERE run_id = f.run_id),
+		(SELECT kind FROM run_steps WHERE run_id = f.run_id ORDER BY id DESC LIMIT 1)
+		FROM run_steps f WHERE f.kind = 'input'`
+	var args []interface{}
+	if sessionID != "" {
+		query += " AND f.session_id = ?"
+		args = append(args, sessionID)
+	}
+	query += " ORDER BY f.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RunSummary
+	for rows.Next() {
+		var rs RunSummary
+		if err := rows.Scan(&rs.RunID, &rs.SessionID, &rs.Input, &rs.StartedAt, &rs.Steps, &rs.Outcome); err != nil {
+			return nil, err
+		}
+		runs = append(runs, rs)
+	}
+	return runs, nil
+}
@@ -0,0 +1,439 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrEmptyResponse is returned when a model answers with neither content nor
+// tool calls. A FallbackClient treats it like any other failure.
+var ErrEmptyResponse = errors.New("the model returned an empty response")
+
+// Defaults of the circuit breaker guarding every model and host.
+const (
+	DefaultBreakerThreshold = 3           // Consecutive failures that open the breaker
+	DefaultBreakerCooldown  = time.Minute // Time an open breaker skips the endpoint
+)
+
+// Route is one link of a fallback chain: a model, optionally on another host
+// of the same backend. It is written as "model" or "model@http://host:port".
+type Route struct {
+	Model   string
+	BaseURL string // "" for the provider's own server
+}
+
+func (r Route) String() string {
+	if r.BaseURL == "" {
+		return r.Model
+	}
+	return r.Model + "@" + r.BaseURL
+}
+
+// ParseRoutes reads a comma-separated fallback chain such as
+// "llama3.1:70b, llama3.1:8b, llama3.1:8b@http://gpu2:11434".
+func ParseRoutes(s string) ([]Route, error) {
+	var routes []Route
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		model, url, hasURL := strings.Cut(item, "@")
+		r := Route{Model: strings.TrimSpace(model), BaseURL: strings.TrimRight(strings.TrimSpace(url), "/")}
+		if r.Model == "" {
+			return nil, fmt.Errorf("fallback %q has no model", item)
+		}
+		if hasURL && !strings.HasPrefix(r.BaseURL, "http://") && !strings.HasPrefix(r.BaseURL, "https://") {
+			return nil, fmt.Errorf("fallback %q: the host must be an http:// or https:// URL", item)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// FormatRoutes writes a fallback chain the way ParseRoutes reads it.
+func FormatRoutes(routes []Route) string {
+	items := make([]string, len(routes))
+	for i, r := range routes {
+		items[i] = r.String()
+	}
+	return strings.Join(items, ",")
+}
+
+type servedKey struct{}
+
+// OnServed returns a context whose generations through a FallbackClient report
+// the route that answered them to fn.
+func OnServed(ctx context.Context, fn func(Route)) context.Context {
+	return context.WithValue(ctx, servedKey{}, fn)
+}
+
+func reportServed(ctx context.Context, r Route) {
+	if fn, ok := ctx.Value(servedKey{}).(func(Route)); ok {
+		fn(r)
+	}
+}
+
+// Health keeps a circuit breaker per endpoint (a model on a host). After
+// threshold consecutive failures the breaker opens and the endpoint is skipped
+// for the cooldown; then a single request is let through to probe it, and its
+// outcome closes or reopens the breaker. Clients derived from each other share
+// the same Health.
+type Health struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	endpoints map[string]*endpointHealth
+}
+
+type endpointHealth struct {
+	failures  int
+	lastError string
+	openUntil time.Time
+	probing   bool // A request is probing the half-open breaker
+}
+
+// EndpointStatus describes the breaker of one endpoint.
+type EndpointStatus struct {
+	Endpoint  string    `json:"endpoint"`
+	State     string    `json:"state"` // "closed", "open" or "half-open"
+	Failures  int       `json:"failures"`
+	LastError string    `json:"last_error,omitempty"`
+	OpenUntil time.Time `json:"open_until,omitempty"`
+}
+
+// NewHealth creates the breakers. Values of 0 or less use the defaults.
+func NewHealth(threshold int, cooldown time.Duration) *Health {
+	if threshold <= 0 {
+		threshold = DefaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+	return &Health{threshold: threshold, cooldown: cooldown, endpoints: make(map[string]*endpointHealth)}
+}
+
+// allow reports whether a request may be sent to the endpoint. The endpoint
+// "" has no breaker; see link.
+func (h *Health) allow(endpoint string) bool {
+	if endpoint == "" {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := h.endpoints[endpoint]
+	if e == nil || e.failures < h.threshold {
+		return true
+	}
+	if time.Now().Before(e.openUntil) || e.probing {
+		return false
+	}
+	e.probing = true
+	return true
+}
+
+// release ends a request that says nothing about the endpoint's health, e.g.
+// one the caller gave up on, so a probe it made can be made again.
+func (h *Health) release(endpoint string) {
+	if endpoint == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e := h.endpoints[endpoint]; e != nil {
+		e.probing = false
+	}
+}
+
+func (h *Health) success(endpoint string) {
+	if endpoint == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e := h.endpoints[endpoint]; e != nil {
+		if e.failures >= h.threshold {
+			fmt.Printf("[LLM]: %s recovered, closing its breaker\n", endpoint)
+		}
+		delete(h.endpoints, endpoint)
+	}
+}
+
+func (h *Health) failure(endpoint string, err error) {
+	if endpoint == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := h.endpoints[endpoint]
+	if e == nil {
+		e = &endpointHealth{}
+		h.endpoints[endpoint] = e
+	}
+	e.failures++
+	e.lastError = err.Error()
+	e.probing = false
+	if e.failures >= h.threshold {
+		e.openUntil = time.Now().Add(h.cooldown)
+		fmt.Printf("[LLM]: %s failed %d times in a row, skipping it for %s\n", endpoint, e.failures, h.cooldown)
+	}
+}
+
+// Status returns the endpoints that failed since their last success.
+func (h *Health) Status() []EndpointStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	list := make([]EndpointStatus, 0, len(h.endpoints))
+	for name, e := range h.endpoints {
+		st := EndpointStatus{Endpoint: name, State: "closed", Failures: e.failures, LastError: e.lastError}
+		if e.failures >= h.threshold {
+			st.State = "half-open"
+			if now.Before(e.openUntil) {
+				st.State = "open"
+				st.OpenUntil = e.openUntil
+			}
+		}
+		list = append(list, st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Endpoint < list[j].Endpoint })
+	return list
+}
+
+// FallbackClient is a Provider that sends every generation to the first
+// healthy link of a chain: the wrapped provider's model, then the fallback
+// routes in order. It moves on to the next link when one fails, times out or
+// answers with nothing. A streamed reply is not taken back once text has been
+// delivered, so a stream that breaks midway fails without falling back.
+type FallbackClient struct {
+	primary   Provider
+	fallbacks []Route
+	health    *Health
+}
+
+// NewFallbackClient wraps p with a fallback chain. health may be shared by
+// several clients; nil creates breakers with the default settings.
+func NewFallbackClient(p Provider, fallbacks []Route, health *Health) *FallbackClient {
+	if health == nil {
+		health = NewHealth(0, 0)
+	}
+	return &FallbackClient{primary: p, fallbacks: fallbacks, health: health}
+}
+
+// WithFallbacks returns a copy of p that falls back to routes. If p is
+// already a FallbackClient its chain is replaced and its breakers are kept.
+func WithFallbacks(p Provider, routes []Route) Provider {
+	if c, ok := p.(*FallbackClient); ok {
+		cp := *c
+		cp.fallbacks = routes
+		return &cp
+	}
+	return NewFallbackClient(p, routes, nil)
+}
+
+// Fallbacks returns the routes tried after the primary model.
+func (c *FallbackClient) Fallbacks() []Route {
+	return c.fallbacks
+}
+
+// Health returns the breakers of the client's endpoints.
+func (c *FallbackClient) Health() *Health {
+	return c.health
+}
+
+type link struct {
+	route Route
+	// The route on the server it is sent to, which names its breaker. It is ""
+	// for a link served by an Ollama pool, which leaves out the servers it
+	// cannot reach: one bad server must not open the breaker of a model the
+	// others serve.
+	endpoint string
+	provider Provider
+}
+
+// links returns the chain in order, without repeating an endpoint.
+func (c *FallbackClient) links() []link {
+	primaryURL := strings.TrimRight(c.primary.GetBaseURL(), "/")
+	server := func(r Route) string {
+		if r.BaseURL == "" {
+			r.BaseURL = primaryURL
+		}
+		return r.String()
+	}
+	newLink := func(r Route, p Provider) link {
+		l := link{route: r, endpoint: server(r), provider: p}
+		if pooled(p) {
+			l.endpoint = ""
+		}
+		return l
+	}
+
+	links := []link{newLink(Route{Model: c.primary.GetModel()}, c.primary)}
+	seen := map[string]bool{server(links[0].route): true}
+	for _, r := range c.fallbacks {
+		if seen[server(r)] {
+			continue
+		}
+		seen[server(r)] = true
+		p := c.primary.WithModel(r.Model)
+		if r.BaseURL != "" {
+			// A route that names its host is served there, not by the pool
+			pinBaseURL(p, r.BaseURL)
+		}
+		links = append(links, newLink(r, p))
+	}
+	return links
+}
+
+// generate runs call on the first healthy link that answers. If every breaker
+// is open the primary is tried anyway rather than failing outright.
+func (c *FallbackClient) generate(ctx context.Context, onDelta func(string), call func(p Provider, onDelta func(string)) (Message, error)) (Message, error) {
+	links := c.links()
+	var errs []error
+	tried := false
+	for i := 0; i < len(links); i++ {
+		l := links[i]
+		// A link is only checked right before it is tried, as checking claims
+		// the probe of a half-open breaker.
+		if !c.health.allow(l.endpoint) {
+			if i < len(links)-1 || tried {
+				errs = append(errs, fmt.Errorf("%s: skipped while its breaker is open", l.route))
+				continue
+			}
+			l = links[0]
+		}
+		tried = true
+
+		delivered := false
+		var deltas func(string)
+		if onDelta != nil {
+			deltas = func(s string) {
+				delivered = true
+				onDelta(s)
+			}
+		}
+		msg, err := call(l.provider, deltas)
+		if err == nil && strings.TrimSpace(msg.Content) == "" && len(msg.ToolCalls) == 0 {
+			err = ErrEmptyResponse
+		}
+		if err == nil {
+			c.health.success(l.endpoint)
+			reportServed(ctx, l.route)
+			return msg, nil
+		}
+		// Neither is the endpoint's fault: the model lacks tool support, which
+		// it could only say by answering, or the caller gave up.
+		if errors.Is(err, ErrToolsUnsupported) {
+			c.health.success(l.endpoint)
+			return msg, err
+		}
+		if ctx.Err() != nil {
+			c.health.release(l.endpoint)
+			return msg, err
+		}
+		c.health.failure(l.endpoint, err)
+		if delivered || len(links) == 1 {
+			if errors.Is(err, ErrEmptyResponse) {
+				// Nothing else to try; leave the empty reply to the caller
+				return msg, nil
+			}
+			return msg, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", l.route, err))
+		if i < len(links)-1 {
+			fmt.Printf("[LLM]: %s failed (%v), trying the next model of the fallback chain\n", l.route, err)
+		}
+	}
+	return Message{}, fmt.Errorf("every model of the fallback chain failed: %w", errors.Join(errs...))
+}
+
+// GenerateResponse sends the conversation through the fallback chain.
+func (c *FallbackClient) GenerateResponse(ctx context.Context, messages []Message) (string, error) {
+	msg, err := c.generate(ctx, nil, func(p Provider, _ func(string)) (Message, error) {
+		content, err := p.GenerateResponse(ctx, messages)
+		return Message{Role: "assistant", Content: content}, err
+	})
+	return msg.Content, err
+}
+
+// GenerateStream streams the reply of the first link that answers.
+func (c *FallbackClient) GenerateStream(ctx context.Context, messages []Message, onDelta func(string)) (string, error) {
+	msg, err := c.generate(ctx, onDelta, func(p Provider, onDelta func(string)) (Message, error) {
+		content, err := p.GenerateStream(ctx, messages, onDelta)
+		return Message{Role: "assistant", Content: content}, err
+	})
+	return msg.Content, err
+}
+
+// GenerateWithTools offers the tools through the fallback chain. A model
+// without tool support returns ErrToolsUnsupported right away, so the caller
+// can switch to the text protocol.
+func (c *FallbackClient) GenerateWithTools(ctx context.Context, messages []Message, tools []ToolDefinition, onDelta func(string)) (Message, error) {
+	return c.generate(ctx, onDelta, func(p Provider, onDelta func(string)) (Message, error) {
+		return p.GenerateWithTools(ctx, messages, tools, onDelta)
+	})
+}
+
+// GenerateJSON constrains the reply to schema through the fallback chain.
+func (c *FallbackClient) GenerateJSON(ctx context.Context, messages []Message, schema map[string]interface{}, onDelta func(string)) (string, error) {
+	msg, err := c.generate(ctx, onDelta, func(p Provider, onDelta func(string)) (Message, error) {
+		content, err := p.GenerateJSON(ctx, messages, schema, onDelta)
+		return Message{Role: "assistant", Content: content}, err
+	})
+	return msg.Content, err
+}
+
+// ListModels returns the models of the primary server.
+func (c *FallbackClient) ListModels(ctx context.Context) ([]string, error) {
+	return c.primary.ListModels(ctx)
+}
+
+// Embed uses the primary provider; embeddings of different models cannot be mixed.
+func (c *FallbackClient) Embed(ctx context.Context, input []string) ([][]float64, error) {
+	return c.primary.Embed(ctx, input)
+}
+
+// GetModel returns the primary model.
+func (c *FallbackClient) GetModel() string {
+	return c.primary.GetModel()
+}
+
+// SetModel updates the primary model.
+func (c *FallbackClient) SetModel(model string) {
+	c.primary.SetModel(model)
+}
+
+// WithModel returns a copy of the client with model as the primary and the
+// same fallbacks.
+func (c *FallbackClient) WithModel(model string) Provider {
+	cp := *c
+	cp.primary = c.primary.WithModel(model)
+	return &cp
+}
+
+// GetOptions returns the model options sent with every generation.
+func (c *FallbackClient) GetOptions() Options {
+	return c.primary.GetOptions()
+}
+
+// WithOptions returns a copy of the client whose every link uses opts.
+func (c *FallbackClient) WithOptions(opts Options) Provider {
+	cp := *c
+	cp.primary = c.primary.WithOptions(opts)
+	return &cp
+}
+
+// GetBaseURL returns the primary provider's server.
+func (c *FallbackClient) GetBaseURL() string {
+	return c.primary.GetBaseURL()
+}
+
+// SetBaseURL points the primary provider at a different server.
+func (c *FallbackClient) SetBaseURL(url string) {
+	c.primary.SetBaseURL(url)
+}
@@ -0,0 +1,86 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pyromancer/idony/internal/llm"
+	"github.com/pyromancer/idony/internal/llm/llmtest"
+)
+
+// flaky is a provider whose model "fake" fails, hangs until cancelled or
+// answers, as set by mode. Other models always answer.
+type flaky struct {
+	*llmtest.Provider
+	mu   *sync.Mutex
+	mode *string
+}
+
+func (f *flaky) GenerateResponse(ctx context.Context, messages []llm.Message) (string, error) {
+	if f.GetModel() == "fake" {
+		f.mu.Lock()
+		mode := *f.mode
+		f.mu.Unlock()
+		switch mode {
+		case "fail":
+			return "", errors.New("server error")
+		case "hang":
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+	}
+	return f.Provider.GenerateResponse(ctx, messages)
+}
+
+func (f *flaky) WithModel(model string) llm.Provider {
+	return &flaky{Provider: f.Provider.WithModel(model).(*llmtest.Provider), mu: f.mu, mode: f.mode}
+}
+
+func (f *flaky) set(mode string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	*f.mode = mode
+}
+
+func TestCancelledProbeReleasesBreaker(t *testing.T) {
+	mode := "fail"
+	p := &flaky{Provider: llmtest.New("ok"), mu: new(sync.Mutex), mode: &mode}
+	client := llm.NewFallbackClient(p, []llm.Route{{Model: "backup"}}, llm.NewHealth(1, time.Millisecond))
+
+	served := func(ctx context.Context) string {
+		var route llm.Route
+		if _, err := client.GenerateResponse(llm.OnServed(ctx, func(r llm.Route) { route = r }), nil); err != nil {
+			t.Fatal(err)
+		}
+		return route.Model
+	}
+
+	// One failure opens the primary's breaker
+	if got := served(context.Background()); got != "backup" {
+		t.Fatalf("served by %q, want the fallback", got)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// The caller gives up on the probe of the half-open breaker
+	p.set("hang")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := client.GenerateResponse(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+
+	// The next request probes the primary again and closes its breaker
+	p.set("ok")
+	if got := served(context.Background()); got != "fake" {
+		t.Fatalf("served by %q, want the recovered primary", got)
+	}
+	if st := client.Health().Status(); len(st) != 0 {
+		t.Errorf("breakers still tracked after recovery: %+v", st)
+	}
+}
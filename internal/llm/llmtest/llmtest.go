@@ -0,0 +1,109 @@
+// Package llmtest provides a fake llm.Provider for tests.
+package llmtest
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pyromancer/idony/internal/llm"
+)
+
+// Provider answers every request with Reply, or JSON when the reply must be
+// JSON, and counts the generations. Copies made with WithModel and WithOptions
+// share the count, like the copies of a real provider share its connection.
+type Provider struct {
+	Reply   string
+	JSON    string
+	model   string
+	options llm.Options
+	calls   *atomic.Int64
+}
+
+// New returns a provider that always answers reply. Its JSON replies give
+// reply as the final answer of the agent's text protocol.
+func New(reply string) *Provider {
+	final, _ := json.Marshal(map[string]string{"thought": "Answering.", "final": reply})
+	return &Provider{Reply: reply, JSON: string(final), model: "fake", calls: new(atomic.Int64)}
+}
+
+// Calls returns the number of generations so far.
+func (p *Provider) Calls() int {
+	return int(p.calls.Load())
+}
+
+func (p *Provider) generate(ctx context.Context, reply string, onDelta func(string)) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	p.calls.Add(1)
+	if onDelta != nil {
+		for _, word := range strings.SplitAfter(reply, " ") {
+			onDelta(word)
+		}
+	}
+	return reply, nil
+}
+
+func (p *Provider) GenerateResponse(ctx context.Context, messages []llm.Message) (string, error) {
+	return p.generate(ctx, p.Reply, nil)
+}
+
+func (p *Provider) GenerateStream(ctx context.Context, messages []llm.Message, onDelta func(string)) (string, error) {
+	return p.generate(ctx, p.Reply, onDelta)
+}
+
+func (p *Provider) GenerateWithTools(ctx context.Context, messages []llm.Message, tools []llm.ToolDefinition, onDelta func(string)) (llm.Message, error) {
+	reply, err := p.generate(ctx, p.Reply, onDelta)
+	return llm.Message{Role: "assistant", Content: reply}, err
+}
+
+func (p *Provider) GenerateJSON(ctx context.Context, messages []llm.Message, schema map[string]interface{}, onDelta func(string)) (string, error) {
+	return p.generate(ctx, p.JSON, onDelta)
+}
+
+func (p *Provider) ListModels(ctx context.Context) ([]string, error) {
+	return []string{p.model}, nil
+}
+
+// Embed returns a small vector derived from the length of each input.
+func (p *Provider) Embed(ctx context.Context, input []string) ([][]float64, error) {
+	vectors := make([][]float64, len(input))
+	for i, s := range input {
+		vectors[i] = []float64{1, float64(len(s))}
+	}
+	return vectors, nil
+}
+
+func (p *Provider) GetModel() string {
+	return p.model
+}
+
+// SetModel changes the receiver without locking, like the real providers, so
+// the race detector catches callers that use it on a shared provider.
+func (p *Provider) SetModel(model string) {
+	p.model = model
+}
+
+func (p *Provider) WithModel(model string) llm.Provider {
+	c := *p
+	c.model = model
+	return &c
+}
+
+func (p *Provider) GetOptions() llm.Options {
+	return p.options
+}
+
+func (p *Provider) WithOptions(opts llm.Options) llm.Provider {
+	c := *p
+	c.options = p.options.Merge(opts)
+	return &c
+}
+
+func (p *Provider) GetBaseURL() string {
+	return ""
+}
+
+func (p *Provider) SetBaseURL(url string) {}
@@ -1,43 +1,61 @@
 package llm
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"strings"
+	"syscall"
 	"time"
 )
 
 // Message represents a single message in the conversation history
 type Message struct {
-	Role    string   `json:"role"`
-	Content string   `json:"content"`
-	Images  []string `json:"images,omitempty"` // Base64 encoded images
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	Images     []string   `json:"images,omitempty"`       // Base64 encoded images
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`   // Native tool calls requested by the assistant
+	ToolName   string     `json:"tool_name,omitempty"`    // Tool that produced a "tool" role message
+	ToolCallID string     `json:"tool_call_id,omitempty"` // Call answered by a "tool" role message (OpenAI)
 }
 
 // Request represents an Ollama generation request
 type Request struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream"`
+	Model    string           `json:"model"`
+	Messages []Message        `json:"messages"`
+	Stream   bool             `json:"stream"`
+	Tools    []ToolDefinition `json:"tools,omitempty"`
+	Format   interface{}      `json:"format,omitempty"` // "json" or a JSON Schema constraining the output
+	// Options are the model options; keep_alive is sent on its own
+	Options   map[string]interface{} `json:"options,omitempty"`
+	KeepAlive interface{}            `json:"keep_alive,omitempty"`
 }
 
 // Response represents a response from Ollama
 type Response struct {
 	Message Message `json:"message"`
 	Done    bool    `json:"done"`
+	Error   string  `json:"error,omitempty"`
 }
 
 // OllamaClient is a basic client for the Ollama HTTP API
 type OllamaClient struct {
-	BaseURL string
-	HTTP    *http.Client
-	Model   string
+	BaseURL    string
+	HTTP       *http.Client
+	Model      string
+	EmbedModel string  // Optional; falls back to Model
+	Options    Options // Model options sent with every generation
+	// Pool optionally spreads requests over several servers; BaseURL serves
+	// the models none of them has. Changing BaseURL leaves the pool alone.
+	Pool *OllamaPool
 }
 
 // NewOllamaClient creates a new instance of OllamaClient
@@ -54,8 +72,94 @@ func (c *OllamaClient) SetModel(model string) {
 	c.Model = model
 }
 
+// GetModel returns the model used for generations
+func (c *OllamaClient) GetModel() string {
+	return c.Model
+}
+
+// WithModel returns a copy of the client that uses model
+func (c *OllamaClient) WithModel(model string) Provider {
+	cp := *c
+	cp.Model = model
+	return &cp
+}
+
+// GetOptions returns the model options sent with every generation
+func (c *OllamaClient) GetOptions() Options {
+	return c.Options
+}
+
+// WithOptions returns a copy of the client with opts layered over its options
+func (c *OllamaClient) WithOptions(opts Options) Provider {
+	cp := *c
+	cp.Options = c.Options.Merge(opts)
+	return &cp
+}
+
+// GetBaseURL returns the Ollama server address.
+func (c *OllamaClient) GetBaseURL() string {
+	return c.BaseURL
+}
+
+// SetBaseURL updates the Ollama server address. A client with a pool keeps
+// using it, and only sends the models none of its servers has there.
+func (c *OllamaClient) SetBaseURL(url string) {
+	c.BaseURL = url
+}
+
+// pooled reports whether p spreads its requests over an Ollama pool, which
+// then keeps track of the health of each server.
+func pooled(p Provider) bool {
+	o, ok := p.(*OllamaClient)
+	return ok && o.Pool != nil
+}
+
+// pinBaseURL points p at url for every request, bypassing the pool of an
+// Ollama client. p must be a copy the caller owns.
+func pinBaseURL(p Provider, url string) {
+	p.SetBaseURL(url)
+	if o, ok := p.(*OllamaClient); ok {
+		o.Pool = nil
+	}
+}
+
+// host returns the server for a request to model and a function to call with
+// the error of connecting to it (see connectFailed) once the request is done.
+func (c *OllamaClient) host(model string) (string, func(error)) {
+	if c.Pool != nil {
+		if url, release := c.Pool.acquire(model); url != "" {
+			return url, release
+		}
+	}
+	return c.BaseURL, func(error) {}
+}
+
+// connectFailed returns err if it shows that the server could not be connected
+// to at all, e.g. the connection was refused. Timeouts, cancellations by the
+// caller and errors after connecting return nil: they say nothing about
+// whether the server is up.
+func connectFailed(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() != nil {
+		return nil
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return nil
+	}
+	var opErr *net.OpError
+	if (errors.As(err, &opErr) && opErr.Op == "dial") || errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+	return nil
+}
+
 // ListModels retrieves the available models from the Ollama server
 func (c *OllamaClient) ListModels(ctx context.Context) ([]string, error) {
+	if c.Pool != nil {
+		if models := c.Pool.Models(); len(models) > 0 {
+			return models, nil
+		}
+	}
 	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/api/tags", nil)
 	if err != nil {
 		return nil, err
@@ -87,6 +191,50 @@ func (c *OllamaClient) ListModels(ctx context.Context) ([]string, error) {
 	return names, nil
 }
 
+// Embed generates embeddings for the given inputs using /api/embed
+func (c *OllamaClient) Embed(ctx context.Context, input []string) ([][]float64, error) {
+	model := c.EmbedModel
+	if model == "" {
+		model = c.Model
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"model": model,
+		"input": input,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	baseURL, release := c.host(model)
+	req, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/api/embed", bytes.NewBuffer(jsonData))
+	if err != nil {
+		release(nil)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	release(connectFailed(ctx, err))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	var data struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return data.Embeddings, nil
+}
+
 // EncodeImage converts a local file to a base64 string
 func EncodeImage(path string) (string, error) {
 	data, err := os.ReadFile(path)
@@ -98,19 +246,85 @@ func EncodeImage(path string) (string, error) {
 
 // GenerateResponse sends a conversation history to Ollama and returns the assistant's response
 func (c *OllamaClient) GenerateResponse(ctx context.Context, messages []Message) (string, error) {
-	reqBody := Request{
+	msg, err := c.chat(ctx, Request{
 		Model:    c.Model,
 		Messages: messages,
 		Stream:   false,
+	})
+	return msg.Content, err
+}
+
+// GenerateStream works like GenerateResponse but streams the reply, calling onDelta
+// with every chunk of content as it arrives. It returns the complete response.
+func (c *OllamaClient) GenerateStream(ctx context.Context, messages []Message, onDelta func(string)) (string, error) {
+	msg, err := c.chatStream(ctx, Request{
+		Model:    c.Model,
+		Messages: messages,
+		Stream:   true,
+	}, onDelta)
+	return msg.Content, err
+}
+
+// GenerateWithTools sends the conversation together with tool definitions through
+// Ollama's native "tools" field. The returned message carries any tool calls the
+// model made. If onDelta is not nil the reply is streamed.
+func (c *OllamaClient) GenerateWithTools(ctx context.Context, messages []Message, tools []ToolDefinition, onDelta func(string)) (Message, error) {
+	reqBody := Request{
+		Model:    c.Model,
+		Messages: messages,
+		Tools:    tools,
+	}
+	if onDelta != nil {
+		reqBody.Stream = true
+		return c.chatStream(ctx, reqBody, onDelta)
 	}
+	return c.chat(ctx, reqBody)
+}
 
-	jsonData, err := json.Marshal(reqBody)
+// GenerateJSON constrains the reply to the given JSON Schema through Ollama's
+// "format" field. If onDelta is not nil the reply is streamed.
+func (c *OllamaClient) GenerateJSON(ctx context.Context, messages []Message, schema map[string]interface{}, onDelta func(string)) (string, error) {
+	reqBody := Request{
+		Model:    c.Model,
+		Messages: messages,
+		Format:   schema,
+	}
+	var msg Message
+	var err error
+	if onDelta != nil {
+		reqBody.Stream = true
+		msg, err = c.chatStream(ctx, reqBody, onDelta)
+	} else {
+		msg, err = c.chat(ctx, reqBody)
+	}
+	return msg.Content, err
+}
+
+// unsupportedTools reports whether an error response means the model cannot use tools.
+func unsupportedTools(status int, body string) bool {
+	return status == http.StatusBadRequest && strings.Contains(body, "does not support tools")
+}
+
+// withOptions adds the client's model options to a request.
+func (c *OllamaClient) withOptions(reqBody Request) Request {
+	if opts := c.Options.ollama(false); len(opts) > 0 {
+		reqBody.Options = opts
+	}
+	reqBody.KeepAlive = c.Options.ollamaKeepAlive()
+	return reqBody
+}
+
+func (c *OllamaClient) chat(ctx context.Context, reqBody Request) (Message, error) {
+	jsonData, err := json.Marshal(c.withOptions(reqBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return Message{}, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	maxRetries := 2
 	var lastErr error
+	baseURL, release := c.host(reqBody.Model)
+	var unreachable error // Error of the last attempt to connect, if the server was down
+	defer func() { release(unreachable) }()
 
 	for i := 0; i <= maxRetries; i++ {
 		if i > 0 {
@@ -118,24 +332,28 @@ func (c *OllamaClient) GenerateResponse(ctx context.Context, messages []Message)
 			time.Sleep(time.Second * time.Duration(i))
 		}
 
-		req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/api/chat", bytes.NewBuffer(jsonData))
+		req, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/api/chat", bytes.NewBuffer(jsonData))
 		if err != nil {
-			return "", fmt.Errorf("failed to create request: %w", err)
+			return Message{}, fmt.Errorf("failed to create request: %w", err)
 		}
 		req.Header.Set("Content-Type", "application/json")
 
 		resp, err := c.HTTP.Do(req)
+		unreachable = connectFailed(ctx, err)
 		if err != nil {
 			lastErr = err
 			if strings.Contains(err.Error(), "EOF") || strings.Contains(err.Error(), "timeout") {
 				continue
 			}
-			return "", fmt.Errorf("failed to execute request: %w", err)
+			return Message{}, fmt.Errorf("failed to execute request: %w", err)
 		}
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
 			body, _ := io.ReadAll(resp.Body)
+			if len(reqBody.Tools) > 0 && unsupportedTools(resp.StatusCode, string(body)) {
+				return Message{}, ErrToolsUnsupported
+			}
 			lastErr = fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
 			continue
 		}
@@ -146,8 +364,100 @@ func (c *OllamaClient) GenerateResponse(ctx context.Context, messages []Message)
 			continue
 		}
 
-		return ollamaResp.Message.Content, nil
+		return ollamaResp.Message, nil
+	}
+
+	return Message{}, fmt.Errorf("failed after %d retries: %v", maxRetries, lastErr)
+}
+
+func (c *OllamaClient) chatStream(ctx context.Context, reqBody Request, onDelta func(string)) (Message, error) {
+	jsonData, err := json.Marshal(c.withOptions(reqBody))
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Retry only while connecting; once tokens have been delivered we cannot start over.
+	maxRetries := 2
+	var lastErr error
+	var resp *http.Response
+	baseURL, release := c.host(reqBody.Model)
+	var unreachable error // Error of the last attempt to connect, if the server was down
+	defer func() { release(unreachable) }()
+
+	for i := 0; i <= maxRetries; i++ {
+		if i > 0 {
+			fmt.Printf("[OllamaClient]: Stream retry %d after error: %v\n", i, lastErr)
+			time.Sleep(time.Second * time.Duration(i))
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/api/chat", bytes.NewBuffer(jsonData))
+		if err != nil {
+			return Message{}, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		// The client timeout would cut long generations short, so rely on ctx instead.
+		streamClient := *c.HTTP
+		streamClient.Timeout = 0
+		r, err := streamClient.Do(req)
+		unreachable = connectFailed(ctx, err)
+		if err != nil {
+			lastErr = err
+			if strings.Contains(err.Error(), "EOF") || strings.Contains(err.Error(), "timeout") {
+				continue
+			}
+			return Message{}, fmt.Errorf("failed to execute request: %w", err)
+		}
+		if r.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(r.Body)
+			r.Body.Close()
+			if len(reqBody.Tools) > 0 && unsupportedTools(r.StatusCode, string(body)) {
+				return Message{}, ErrToolsUnsupported
+			}
+			lastErr = fmt.Errorf("unexpected status code %d: %s", r.StatusCode, string(body))
+			continue
+		}
+		resp = r
+		break
+	}
+	if resp == nil {
+		return Message{}, fmt.Errorf("failed after %d retries: %v", maxRetries, lastErr)
+	}
+	defer resp.Body.Close()
+
+	result := Message{Role: "assistant"}
+	var full strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var chunk Response
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			result.Content = full.String()
+			return result, fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			result.Content = full.String()
+			return result, fmt.Errorf("ollama error: %s", chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			full.WriteString(chunk.Message.Content)
+			if onDelta != nil {
+				onDelta(chunk.Message.Content)
+			}
+		}
+		result.ToolCalls = append(result.ToolCalls, chunk.Message.ToolCalls...)
+		if chunk.Done {
+			break
+		}
+	}
+	result.Content = full.String()
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("stream interrupted: %w", err)
 	}
 
-	return "", fmt.Errorf("failed after %d retries: %v", maxRetries, lastErr)
+	return result, nil
 }
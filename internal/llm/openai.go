@@ -0,0 +1,561 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAIClient talks to any server implementing the OpenAI-compatible
+// /v1 API (llama.cpp server, vLLM, LM Studio, ...).
+type OpenAIClient struct {
+	BaseURL    string
+	APIKey     string
+	HTTP       *http.Client
+	Model      string
+	EmbedModel string  // Optional; falls back to Model
+	Options    Options // Model options sent with every generation; num_ctx and keep_alive do not apply
+}
+
+// openAIMessage is a chat message in the OpenAI wire format.
+// Content is either a plain string or a list of content parts when images are attached.
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    interface{}      `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// openAIToolCall differs from ToolCall in that arguments are a JSON-encoded string.
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIContentPart struct {
+	Type     string              `json:"type"`
+	Text     string              `json:"text,omitempty"`
+	ImageURL *openAIContentImage `json:"image_url,omitempty"`
+}
+
+type openAIContentImage struct {
+	URL string `json:"url"`
+}
+
+type openAIChatRequest struct {
+	Model    string           `json:"model"`
+	Messages []openAIMessage  `json:"messages"`
+	Stream   bool             `json:"stream"`
+	Tools    []ToolDefinition `json:"tools,omitempty"`
+	// ResponseFormat constrains the output, e.g. {"type": "json_schema", ...}
+	ResponseFormat map[string]interface{} `json:"response_format,omitempty"`
+	Temperature    *float64               `json:"temperature,omitempty"`
+	TopP           *float64               `json:"top_p,omitempty"`
+	Seed           *int                   `json:"seed,omitempty"`
+	Stop           []string               `json:"stop,omitempty"`
+}
+
+type openAIStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+type openAIChatResponse struct {
+	Choices []struct {
+		Message struct {
+			Role      string           `json:"role"`
+			Content   string           `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+// NewOpenAIClient creates a new instance of OpenAIClient.
+// baseURL may be given with or without the trailing /v1.
+func NewOpenAIClient(baseURL, model, apiKey string) *OpenAIClient {
+	return &OpenAIClient{
+		BaseURL: baseURL,
+		APIKey:  apiKey,
+		HTTP:    &http.Client{Timeout: 120 * time.Second},
+		Model:   model,
+	}
+}
+
+// SetModel updates the model used for generations
+func (c *OpenAIClient) SetModel(model string) {
+	c.Model = model
+}
+
+// GetModel returns the model used for generations
+func (c *OpenAIClient) GetModel() string {
+	return c.Model
+}
+
+// WithModel returns a copy of the client that uses model
+func (c *OpenAIClient) WithModel(model string) Provider {
+	cp := *c
+	cp.Model = model
+	return &cp
+}
+
+// GetOptions returns the model options sent with every generation
+func (c *OpenAIClient) GetOptions() Options {
+	return c.Options
+}
+
+// WithOptions returns a copy of the client with opts layered over its options
+func (c *OpenAIClient) WithOptions(opts Options) Provider {
+	cp := *c
+	cp.Options = c.Options.Merge(opts)
+	return &cp
+}
+
+// withOptions adds the client's model options to a request. Options without
+// an OpenAI equivalent are left out.
+func (c *OpenAIClient) withOptions(reqBody openAIChatRequest) openAIChatRequest {
+	reqBody.Temperature = c.Options.Temperature
+	reqBody.TopP = c.Options.TopP
+	reqBody.Seed = c.Options.Seed
+	reqBody.Stop = c.Options.Stop
+	return reqBody
+}
+
+// GetBaseURL returns the server address
+func (c *OpenAIClient) GetBaseURL() string {
+	return c.BaseURL
+}
+
+// SetBaseURL updates the server address
+func (c *OpenAIClient) SetBaseURL(url string) {
+	c.BaseURL = url
+}
+
+// endpoint builds the full URL for a /v1 API path.
+func (c *OpenAIClient) endpoint(path string) string {
+	base := strings.TrimRight(c.BaseURL, "/")
+	if !strings.HasSuffix(base, "/v1") {
+		base += "/v1"
+	}
+	return base + path
+}
+
+func (c *OpenAIClient) newRequest(ctx context.Context, method, path string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewBuffer(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint(path), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	return req, nil
+}
+
+// ListModels retrieves the available models from /v1/models
+func (c *OpenAIClient) ListModels(ctx context.Context) ([]string, error) {
+	req, err := c.newRequest(ctx, "GET", "/models", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var data struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, m := range data.Data {
+		names = append(names, m.ID)
+	}
+	return names, nil
+}
+
+// Embed generates embeddings for the given inputs using /v1/embeddings
+func (c *OpenAIClient) Embed(ctx context.Context, input []string) ([][]float64, error) {
+	model := c.EmbedModel
+	if model == "" {
+		model = c.Model
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"model": model,
+		"input": input,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := c.newRequest(ctx, "POST", "/embeddings", jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	var data struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	vectors := make([][]float64, len(input))
+	for _, d := range data.Data {
+		if d.Index >= 0 && d.Index < len(vectors) {
+			vectors[d.Index] = d.Embedding
+		}
+	}
+	return vectors, nil
+}
+
+// toOpenAIMessages converts our messages to the OpenAI format,
+// turning base64 images into data URLs.
+func toOpenAIMessages(messages []Message) []openAIMessage {
+	out := make([]openAIMessage, 0, len(messages))
+	for i, m := range messages {
+		if len(m.ToolCalls) > 0 || m.Role == "tool" {
+			out = append(out, openAIMessage{
+				Role:       m.Role,
+				Content:    m.Content,
+				ToolCalls:  toOpenAIToolCalls(i, m.ToolCalls),
+				ToolCallID: m.ToolCallID,
+			})
+			continue
+		}
+		if len(m.Images) == 0 {
+			out = append(out, openAIMessage{Role: m.Role, Content: m.Content})
+			continue
+		}
+		parts := []openAIContentPart{{Type: "text", Text: m.Content}}
+		for _, img := range m.Images {
+			parts = append(parts, openAIContentPart{
+				Type:     "image_url",
+				ImageURL: &openAIContentImage{URL: "data:image/jpeg;base64," + img},
+			})
+		}
+		out = append(out, openAIMessage{Role: m.Role, Content: parts})
+	}
+	return out
+}
+
+// toOpenAIToolCalls converts tool calls made in message idx, inventing stable IDs
+// for calls that came from a backend that does not assign them.
+func toOpenAIToolCalls(idx int, calls []ToolCall) []openAIToolCall {
+	var out []openAIToolCall
+	for j, tc := range calls {
+		oc := openAIToolCall{ID: tc.ID, Type: "function"}
+		if oc.ID == "" {
+			oc.ID = fmt.Sprintf("call_%d_%d", idx, j)
+		}
+		oc.Function.Name = tc.Function.Name
+		oc.Function.Arguments = string(tc.Function.Arguments)
+		if oc.Function.Arguments == "" {
+			oc.Function.Arguments = "{}"
+		}
+		out = append(out, oc)
+	}
+	return out
+}
+
+// GenerateResponse sends a conversation to /v1/chat/completions and returns the assistant's response
+func (c *OpenAIClient) GenerateResponse(ctx context.Context, messages []Message) (string, error) {
+	return c.complete(ctx, openAIChatRequest{
+		Model:    c.Model,
+		Messages: toOpenAIMessages(messages),
+		Stream:   false,
+	})
+}
+
+// GenerateStream works like GenerateResponse but consumes the server-sent event
+// stream, calling onDelta with every chunk of content as it arrives.
+func (c *OpenAIClient) GenerateStream(ctx context.Context, messages []Message, onDelta func(string)) (string, error) {
+	return c.completeStream(ctx, openAIChatRequest{
+		Model:    c.Model,
+		Messages: toOpenAIMessages(messages),
+		Stream:   true,
+	}, onDelta)
+}
+
+// GenerateJSON constrains the reply to the given JSON Schema through the
+// "json_schema" response format. If onDelta is not nil the reply is streamed.
+func (c *OpenAIClient) GenerateJSON(ctx context.Context, messages []Message, schema map[string]interface{}, onDelta func(string)) (string, error) {
+	reqBody := openAIChatRequest{
+		Model:    c.Model,
+		Messages: toOpenAIMessages(messages),
+		ResponseFormat: map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "response",
+				"schema": schema,
+			},
+		},
+	}
+	if onDelta != nil {
+		reqBody.Stream = true
+		return c.completeStream(ctx, reqBody, onDelta)
+	}
+	return c.complete(ctx, reqBody)
+}
+
+func (c *OpenAIClient) complete(ctx context.Context, reqBody openAIChatRequest) (string, error) {
+	jsonData, err := json.Marshal(c.withOptions(reqBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	maxRetries := 2
+	var lastErr error
+
+	for i := 0; i <= maxRetries; i++ {
+		if i > 0 {
+			fmt.Printf("[OpenAIClient]: Retry %d after error: %v\n", i, lastErr)
+			time.Sleep(time.Second * time.Duration(i))
+		}
+
+		req, err := c.newRequest(ctx, "POST", "/chat/completions", jsonData)
+		if err != nil {
+			return "", fmt.Errorf("failed to create request: %w", err)
+		}
+
+		resp, err := c.HTTP.Do(req)
+		if err != nil {
+			lastErr = err
+			if strings.Contains(err.Error(), "EOF") || strings.Contains(err.Error(), "timeout") {
+				continue
+			}
+			return "", fmt.Errorf("failed to execute request: %w", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			lastErr = fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+			continue
+		}
+
+		var chatResp openAIChatResponse
+		err = json.NewDecoder(resp.Body).Decode(&chatResp)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("failed to decode response: %w", err)
+			continue
+		}
+		if len(chatResp.Choices) == 0 {
+			lastErr = fmt.Errorf("response contained no choices")
+			continue
+		}
+
+		return chatResp.Choices[0].Message.Content, nil
+	}
+
+	return "", fmt.Errorf("failed after %d retries: %v", maxRetries, lastErr)
+}
+
+func (c *OpenAIClient) completeStream(ctx context.Context, reqBody openAIChatRequest, onDelta func(string)) (string, error) {
+	jsonData, err := json.Marshal(c.withOptions(reqBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Retry only while connecting; once tokens have been delivered we cannot start over.
+	maxRetries := 2
+	var lastErr error
+	var resp *http.Response
+
+	for i := 0; i <= maxRetries; i++ {
+		if i > 0 {
+			fmt.Printf("[OpenAIClient]: Stream retry %d after error: %v\n", i, lastErr)
+			time.Sleep(time.Second * time.Duration(i))
+		}
+
+		req, err := c.newRequest(ctx, "POST", "/chat/completions", jsonData)
+		if err != nil {
+			return "", fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Accept", "text/event-stream")
+
+		// The client timeout would cut long generations short, so rely on ctx instead.
+		streamClient := *c.HTTP
+		streamClient.Timeout = 0
+		r, err := streamClient.Do(req)
+		if err != nil {
+			lastErr = err
+			if strings.Contains(err.Error(), "EOF") || strings.Contains(err.Error(), "timeout") {
+				continue
+			}
+			return "", fmt.Errorf("failed to execute request: %w", err)
+		}
+		if r.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(r.Body)
+			r.Body.Close()
+			lastErr = fmt.Errorf("unexpected status code %d: %s", r.StatusCode, string(body))
+			continue
+		}
+		resp = r
+		break
+	}
+	if resp == nil {
+		return "", fmt.Errorf("failed after %d retries: %v", maxRetries, lastErr)
+	}
+	defer resp.Body.Close()
+
+	var full strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return full.String(), fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			full.WriteString(choice.Delta.Content)
+			if onDelta != nil {
+				onDelta(choice.Delta.Content)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return full.String(), fmt.Errorf("stream interrupted: %w", err)
+	}
+
+	return full.String(), nil
+}
+
+// GenerateWithTools sends the conversation together with tool definitions using the
+// OpenAI function-calling protocol. Tool calls arrive as fragments when streaming,
+// so the request is never streamed; onDelta, if set, receives the whole content at once.
+func (c *OpenAIClient) GenerateWithTools(ctx context.Context, messages []Message, tools []ToolDefinition, onDelta func(string)) (Message, error) {
+	reqBody := openAIChatRequest{
+		Model:    c.Model,
+		Messages: toOpenAIMessages(messages),
+		Tools:    tools,
+	}
+
+	jsonData, err := json.Marshal(c.withOptions(reqBody))
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	maxRetries := 2
+	var lastErr error
+
+	for i := 0; i <= maxRetries; i++ {
+		if i > 0 {
+			fmt.Printf("[OpenAIClient]: Retry %d after error: %v\n", i, lastErr)
+			time.Sleep(time.Second * time.Duration(i))
+		}
+
+		req, err := c.newRequest(ctx, "POST", "/chat/completions", jsonData)
+		if err != nil {
+			return Message{}, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		resp, err := c.HTTP.Do(req)
+		if err != nil {
+			lastErr = err
+			if strings.Contains(err.Error(), "EOF") || strings.Contains(err.Error(), "timeout") {
+				continue
+			}
+			return Message{}, fmt.Errorf("failed to execute request: %w", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			// llama.cpp without --jinja and vLLM without --enable-auto-tool-choice reject tools with a 4xx
+			if resp.StatusCode >= 400 && resp.StatusCode < 500 && strings.Contains(strings.ToLower(string(body)), "tool") {
+				return Message{}, ErrToolsUnsupported
+			}
+			lastErr = fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+			continue
+		}
+
+		var chatResp openAIChatResponse
+		err = json.NewDecoder(resp.Body).Decode(&chatResp)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("failed to decode response: %w", err)
+			continue
+		}
+		if len(chatResp.Choices) == 0 {
+			lastErr = fmt.Errorf("response contained no choices")
+			continue
+		}
+
+		choice := chatResp.Choices[0].Message
+		msg := Message{Role: "assistant", Content: choice.Content}
+		for _, oc := range choice.ToolCalls {
+			tc := ToolCall{ID: oc.ID}
+			tc.Function.Name = oc.Function.Name
+			if json.Valid([]byte(oc.Function.Arguments)) {
+				tc.Function.Arguments = json.RawMessage(oc.Function.Arguments)
+			} else {
+				tc.Function.Arguments, _ = json.Marshal(oc.Function.Arguments)
+			}
+			msg.ToolCalls = append(msg.ToolCalls, tc)
+		}
+		if onDelta != nil && msg.Content != "" {
+			onDelta(msg.Content)
+		}
+		return msg, nil
+	}
+
+	return Message{}, fmt.Errorf("failed after %d retries: %v", maxRetries, lastErr)
+}
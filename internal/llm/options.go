@@ -0,0 +1,255 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Options tune how a model generates. Unset fields leave the choice to the
+// layer below: options of a single call override those of the agent, which
+// override the global ones from config.txt, which override the backend's.
+//
+// They are written as a JSON object with Ollama's option names, e.g.
+// {"temperature": 0.2, "seed": 42, "stop": ["END"], "keep_alive": "10m"}.
+type Options struct {
+	Temperature *float64
+	TopP        *float64
+	NumCtx      int      // Context window in tokens (Ollama only)
+	Seed        *int     // Fixed seed for reproducible replies
+	Stop        []string // Stop sequences
+	KeepAlive   string   // How long Ollama keeps the model loaded, e.g. "10m" or "-1" (Ollama only)
+	// Extra holds any other Ollama option, e.g. top_k or num_predict.
+	Extra map[string]interface{}
+}
+
+type callOptionsKey struct{}
+
+// WithCallOptions returns a context asking the next agent run or sub-agent
+// started with it to use opts over its own options.
+func WithCallOptions(ctx context.Context, opts Options) context.Context {
+	return context.WithValue(ctx, callOptionsKey{}, opts)
+}
+
+// TakeCallOptions returns the options set with WithCallOptions and a context
+// that no longer carries them, so the runs and sub-agents started from the
+// call keep their own.
+func TakeCallOptions(ctx context.Context) (context.Context, Options) {
+	if opts, ok := ctx.Value(callOptionsKey{}).(Options); ok {
+		return context.WithValue(ctx, callOptionsKey{}, Options{}), opts
+	}
+	return ctx, Options{}
+}
+
+// IsZero reports whether no option is set.
+func (o Options) IsZero() bool {
+	return o.Temperature == nil && o.TopP == nil && o.NumCtx == 0 && o.Seed == nil &&
+		len(o.Stop) == 0 && o.KeepAlive == "" && len(o.Extra) == 0
+}
+
+// Merge returns o with the options set in over replacing its own.
+func (o Options) Merge(over Options) Options {
+	if over.Temperature != nil {
+		o.Temperature = over.Temperature
+	}
+	if over.TopP != nil {
+		o.TopP = over.TopP
+	}
+	if over.NumCtx > 0 {
+		o.NumCtx = over.NumCtx
+	}
+	if over.Seed != nil {
+		o.Seed = over.Seed
+	}
+	if len(over.Stop) > 0 {
+		o.Stop = over.Stop
+	}
+	if over.KeepAlive != "" {
+		o.KeepAlive = over.KeepAlive
+	}
+	if len(over.Extra) > 0 {
+		extra := make(map[string]interface{}, len(o.Extra)+len(over.Extra))
+		for k, v := range o.Extra {
+			extra[k] = v
+		}
+		for k, v := range over.Extra {
+			extra[k] = v
+		}
+		o.Extra = extra
+	}
+	return o
+}
+
+// ParseOptions reads options from a JSON object. An empty string sets none.
+func ParseOptions(s string) (Options, error) {
+	var o Options
+	if strings.TrimSpace(s) == "" {
+		return o, nil
+	}
+	err := json.Unmarshal([]byte(s), &o)
+	return o, err
+}
+
+// String returns the options as a JSON object, or "" if none is set.
+func (o Options) String() string {
+	if o.IsZero() {
+		return ""
+	}
+	data, _ := json.Marshal(o)
+	return string(data)
+}
+
+func (o Options) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.ollama(true))
+}
+
+func (o *Options) UnmarshalJSON(data []byte) error {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("options must be a JSON object: %w", err)
+	}
+	*o = Options{}
+	for key, v := range fields {
+		if err := o.set(key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set assigns one option. Numbers may also be given as strings, as forms send them.
+func (o *Options) set(key string, v interface{}) error {
+	number := func() (float64, error) {
+		switch v := v.(type) {
+		case float64:
+			return v, nil
+		case string:
+			return strconv.ParseFloat(strings.TrimSpace(v), 64)
+		}
+		return 0, fmt.Errorf("%s must be a number", key)
+	}
+
+	switch key {
+	case "temperature", "top_p":
+		f, err := number()
+		if err != nil || f < 0 {
+			return fmt.Errorf("%s must be a number of 0 or more", key)
+		}
+		if key == "temperature" {
+			o.Temperature = &f
+		} else {
+			o.TopP = &f
+		}
+	case "num_ctx":
+		f, err := number()
+		if err != nil || f < 0 || f != float64(int(f)) {
+			return fmt.Errorf("num_ctx must be a whole number of tokens")
+		}
+		o.NumCtx = int(f)
+	case "seed":
+		f, err := number()
+		if err != nil || f != float64(int(f)) {
+			return fmt.Errorf("seed must be a whole number")
+		}
+		seed := int(f)
+		o.Seed = &seed
+	case "stop":
+		switch v := v.(type) {
+		case string:
+			o.Stop = []string{v}
+		case []interface{}:
+			o.Stop = nil
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("stop must be a list of strings")
+				}
+				o.Stop = append(o.Stop, s)
+			}
+		default:
+			return fmt.Errorf("stop must be a string or a list of strings")
+		}
+	case "keep_alive":
+		switch v := v.(type) {
+		case string:
+			o.KeepAlive = v
+		case float64:
+			o.KeepAlive = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return fmt.Errorf("keep_alive must be a duration such as 10m, or -1 to keep the model loaded")
+		}
+	default:
+		if o.Extra == nil {
+			o.Extra = make(map[string]interface{})
+		}
+		o.Extra[key] = v
+	}
+	return nil
+}
+
+// ParseOptionSettings reads the global options from the <prefix>TEMPERATURE,
+// <prefix>TOP_P, <prefix>NUM_CTX, <prefix>SEED, <prefix>STOP (comma-separated)
+// and <prefix>KEEP_ALIVE settings.
+func ParseOptionSettings(settings map[string]string, prefix string) (Options, error) {
+	var o Options
+	for _, key := range []string{"temperature", "top_p", "num_ctx", "seed", "stop", "keep_alive"} {
+		v := strings.TrimSpace(settings[prefix+strings.ToUpper(key)])
+		if v == "" {
+			continue
+		}
+		var value interface{} = v
+		if key == "stop" {
+			var stops []interface{}
+			for _, s := range strings.Split(v, ",") {
+				stops = append(stops, s)
+			}
+			value = stops
+		}
+		if err := o.set(key, value); err != nil {
+			return Options{}, fmt.Errorf("%s%s: %w", prefix, strings.ToUpper(key), err)
+		}
+	}
+	return o, nil
+}
+
+// ollama returns the options under Ollama's names. keep_alive is a field of the
+// request rather than an option, so it is only included if asked for.
+func (o Options) ollama(keepAlive bool) map[string]interface{} {
+	m := make(map[string]interface{}, len(o.Extra)+6)
+	for k, v := range o.Extra {
+		m[k] = v
+	}
+	if o.Temperature != nil {
+		m["temperature"] = *o.Temperature
+	}
+	if o.TopP != nil {
+		m["top_p"] = *o.TopP
+	}
+	if o.NumCtx > 0 {
+		m["num_ctx"] = o.NumCtx
+	}
+	if o.Seed != nil {
+		m["seed"] = *o.Seed
+	}
+	if len(o.Stop) > 0 {
+		m["stop"] = o.Stop
+	}
+	if keepAlive && o.KeepAlive != "" {
+		m["keep_alive"] = o.KeepAlive
+	}
+	return m
+}
+
+// ollamaKeepAlive returns keep_alive as Ollama expects it: a number of seconds
+// or a duration string.
+func (o Options) ollamaKeepAlive() interface{} {
+	if o.KeepAlive == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(o.KeepAlive); err == nil {
+		return n
+	}
+	return o.KeepAlive
+}
@@ -0,0 +1,242 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OllamaPool spreads requests over several Ollama servers. It learns which
+// models each server has from /api/tags (and which it has in memory from
+// /api/ps) and sends each request to a server with the model, preferring the
+// ones that already have it loaded, and among those the one with the fewest
+// requests in flight.
+type OllamaPool struct {
+	http  *http.Client
+	mu    sync.Mutex
+	hosts []*poolHost
+}
+
+type poolHost struct {
+	url       string
+	reachable bool
+	models    map[string]bool
+	loaded    map[string]bool
+	inFlight  int
+	lastError string
+}
+
+// HostStatus describes one server of the pool.
+type HostStatus struct {
+	URL       string   `json:"url"`
+	Reachable bool     `json:"reachable"`
+	Models    []string `json:"models"`
+	Loaded    []string `json:"loaded,omitempty"`
+	InFlight  int      `json:"in_flight"`
+	LastError string   `json:"last_error,omitempty"`
+}
+
+// NewOllamaPool creates a pool of the given servers. Until Refresh has run
+// every server is assumed to have every model.
+func NewOllamaPool(urls []string) *OllamaPool {
+	p := &OllamaPool{http: &http.Client{Timeout: 10 * time.Second}}
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		u = strings.TrimRight(strings.TrimSpace(u), "/")
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		p.hosts = append(p.hosts, &poolHost{url: u, reachable: true})
+	}
+	return p
+}
+
+// Refresh asks every server which models it has. Servers that cannot be
+// reached get no requests until a later refresh finds them again.
+func (p *OllamaPool) Refresh(ctx context.Context) {
+	p.mu.Lock()
+	urls := make([]string, len(p.hosts))
+	for i, h := range p.hosts {
+		urls[i] = h.url
+	}
+	p.mu.Unlock()
+
+	type discovery struct {
+		models, loaded map[string]bool
+		err            error
+	}
+	found := make([]discovery, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			d := &found[i]
+			if d.models, d.err = p.list(ctx, u+"/api/tags"); d.err != nil {
+				return
+			}
+			// Only a preference, so a server without /api/ps is fine
+			d.loaded, _ = p.list(ctx, u+"/api/ps")
+		}(i, u)
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, h := range p.hosts {
+		d := found[i]
+		if d.err != nil {
+			if h.reachable {
+				fmt.Printf("[OllamaPool]: %s is unreachable: %v\n", h.url, d.err)
+			}
+			h.reachable = false
+			h.lastError = d.err.Error()
+			continue
+		}
+		if !h.reachable {
+			fmt.Printf("[OllamaPool]: %s is back with %d models\n", h.url, len(d.models))
+		}
+		h.reachable = true
+		h.lastError = ""
+		h.models = d.models
+		h.loaded = d.loaded
+	}
+}
+
+// list reads the model names of a /api/tags or /api/ps response.
+func (p *OllamaPool) list(ctx context.Context, url string) (map[string]bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	var data struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	models := make(map[string]bool, len(data.Models))
+	for _, m := range data.Models {
+		models[m.Name] = true
+	}
+	return models, nil
+}
+
+// Watch refreshes the pool every interval until ctx is done.
+func (p *OllamaPool) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Refresh(ctx)
+		}
+	}
+}
+
+// has reports whether the server has model. "llama3.1" matches "llama3.1:latest".
+func (h *poolHost) has(set map[string]bool, model string) bool {
+	return set[model] || (!strings.Contains(model, ":") && set[model+":latest"])
+}
+
+// acquire picks the server for a request to model and counts the request as
+// in flight until release is called. It returns "" if no reachable server has
+// the model. A request that could not reach its server passes the error to
+// release, which keeps the server out of the pool until the next refresh.
+func (p *OllamaPool) acquire(model string) (url string, release func(unreachable error)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var best *poolHost
+	for _, h := range p.hosts {
+		// Before the first refresh a server is assumed to have every model
+		if !h.reachable || (h.models != nil && !h.has(h.models, model)) {
+			continue
+		}
+		if best == nil {
+			best = h
+			continue
+		}
+		// Loading a model takes far longer than waiting for a busy server
+		loaded, bestLoaded := h.has(h.loaded, model), best.has(best.loaded, model)
+		if (loaded && !bestLoaded) || (loaded == bestLoaded && h.inFlight < best.inFlight) {
+			best = h
+		}
+	}
+	if best == nil {
+		return "", func(error) {}
+	}
+	best.inFlight++
+	var once sync.Once
+	return best.url, func(unreachable error) {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			best.inFlight--
+			if unreachable != nil {
+				fmt.Printf("[OllamaPool]: %s failed, leaving it out until it is found again: %v\n", best.url, unreachable)
+				best.reachable = false
+				best.lastError = unreachable.Error()
+			}
+		})
+	}
+}
+
+// Models returns the models of all reachable servers.
+func (p *OllamaPool) Models() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	set := make(map[string]bool)
+	for _, h := range p.hosts {
+		if h.reachable {
+			for m := range h.models {
+				set[m] = true
+			}
+		}
+	}
+	return sortedNames(set)
+}
+
+// Status returns the state of every server of the pool.
+func (p *OllamaPool) Status() []HostStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	list := make([]HostStatus, len(p.hosts))
+	for i, h := range p.hosts {
+		list[i] = HostStatus{
+			URL:       h.url,
+			Reachable: h.reachable,
+			Models:    sortedNames(h.models),
+			Loaded:    sortedNames(h.loaded),
+			InFlight:  h.inFlight,
+			LastError: h.lastError,
+		}
+	}
+	return list
+}
+
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
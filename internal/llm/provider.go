@@ -0,0 +1,75 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Provider is the interface every LLM backend must implement.
+// The agent, managers and tools only depend on this interface so the
+// backend can be swapped through config.txt.
+type Provider interface {
+	// GenerateResponse sends a conversation and returns the assistant's reply.
+	GenerateResponse(ctx context.Context, messages []Message) (string, error)
+	// GenerateStream is like GenerateResponse but calls onDelta for every
+	// chunk of content as it arrives. It returns the complete reply.
+	GenerateStream(ctx context.Context, messages []Message, onDelta func(string)) (string, error)
+	// GenerateWithTools offers tools to the model through the backend's native
+	// function-calling protocol and returns the reply with any tool calls.
+	// It returns ErrToolsUnsupported if the model cannot use tools.
+	GenerateWithTools(ctx context.Context, messages []Message, tools []ToolDefinition, onDelta func(string)) (Message, error)
+	// GenerateJSON constrains the reply to the given JSON Schema. If onDelta is
+	// not nil the reply is streamed. Use GenerateStructured to also validate it.
+	GenerateJSON(ctx context.Context, messages []Message, schema map[string]interface{}, onDelta func(string)) (string, error)
+	// ListModels returns the models available on the backend.
+	ListModels(ctx context.Context) ([]string, error)
+	// Embed returns one embedding vector per input string.
+	Embed(ctx context.Context, input []string) ([][]float64, error)
+	// GetModel returns the model currently used for generations.
+	GetModel() string
+	// SetModel updates the model used for generations.
+	SetModel(model string)
+	// WithModel returns a copy of the provider that generates with model,
+	// leaving the receiver unchanged. Concurrent runs use it instead of
+	// swapping the model of a shared provider.
+	WithModel(model string) Provider
+	// GetOptions returns the model options sent with every generation.
+	GetOptions() Options
+	// WithOptions returns a copy of the provider with opts layered over its
+	// options, leaving the receiver unchanged.
+	WithOptions(opts Options) Provider
+	// GetBaseURL returns the address of the provider's server.
+	GetBaseURL() string
+	// SetBaseURL points the provider at a different server.
+	SetBaseURL(url string)
+}
+
+// Supported provider names for the LLM_PROVIDER config key.
+const (
+	ProviderOllama = "ollama"
+	ProviderOpenAI = "openai"
+)
+
+// ProviderName normalizes an LLM_PROVIDER value so it can be compared with
+// the provider names. An empty name selects Ollama.
+func ProviderName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return ProviderOllama
+	}
+	return name
+}
+
+// NewProvider creates the backend selected by name.
+// apiKey is only used by OpenAI-compatible servers and may be empty.
+func NewProvider(name, baseURL, model, apiKey string) (Provider, error) {
+	switch ProviderName(name) {
+	case ProviderOllama:
+		return NewOllamaClient(baseURL, model), nil
+	case ProviderOpenAI:
+		return NewOpenAIClient(baseURL, model, apiKey), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q (expected %q or %q)", name, ProviderOllama, ProviderOpenAI)
+	}
+}
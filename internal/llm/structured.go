@@ -0,0 +1,249 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DefaultMaxRepairs is how often GenerateStructured re-prompts the model after
+// an invalid reply when Structured.MaxRepairs is not set.
+const DefaultMaxRepairs = 2
+
+// Structured describes the JSON output expected from a model.
+type Structured struct {
+	Schema     map[string]interface{} // JSON Schema the reply must satisfy
+	MaxRepairs int                    // Re-prompts after the first attempt; 0 means DefaultMaxRepairs, negative disables repairs
+	Validate   func() error           // Optional extra check, run after the reply has been decoded into the target
+	OnDelta    func(string)           // Optional streaming callback
+	OnRepair   func(err error)        // Optional callback, called before every repair attempt
+}
+
+// GenerateStructured asks p for output constrained by s.Schema, validates it and
+// decodes it into out. Invalid replies are sent back to the model together with
+// the validation error until it complies or the repair budget is exhausted.
+// It returns the raw text of the last reply.
+func GenerateStructured(ctx context.Context, p Provider, messages []Message, s Structured, out interface{}) (string, error) {
+	maxRepairs := s.MaxRepairs
+	if maxRepairs == 0 {
+		maxRepairs = DefaultMaxRepairs
+	}
+	if maxRepairs < 0 {
+		maxRepairs = 0
+	}
+
+	conversation := append([]Message(nil), messages...)
+	var raw string
+	var lastErr error
+
+	for attempt := 0; attempt <= maxRepairs; attempt++ {
+		if attempt > 0 {
+			fmt.Printf("[LLM]: Repairing structured output (attempt %d): %v\n", attempt, lastErr)
+			if s.OnRepair != nil {
+				s.OnRepair(lastErr)
+			}
+			conversation = append(conversation,
+				Message{Role: "assistant", Content: raw},
+				Message{Role: "user", Content: fmt.Sprintf("Your previous reply was invalid: %v\nReply again with only a JSON object that satisfies the required schema.", lastErr)},
+			)
+		}
+
+		var err error
+		raw, err = p.GenerateJSON(ctx, conversation, s.Schema, s.OnDelta)
+		if err != nil {
+			return raw, err
+		}
+
+		if lastErr = decodeStructured(raw, s, out); lastErr == nil {
+			return raw, nil
+		}
+	}
+
+	return raw, fmt.Errorf("invalid structured output after %d repairs: %w", maxRepairs, lastErr)
+}
+
+func decodeStructured(raw string, s Structured, out interface{}) error {
+	data := []byte(ExtractJSON(raw))
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("not valid JSON: %v", err)
+	}
+	if err := ValidateSchema(doc, s.Schema); err != nil {
+		return err
+	}
+	// Clear fields left over from a previous invalid attempt
+	if rv := reflect.ValueOf(out); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return err
+	}
+	if s.Validate != nil {
+		return s.Validate()
+	}
+	return nil
+}
+
+// ExtractJSON finds a JSON document in model output that may be wrapped in
+// <json> tags, markdown fences or surrounding prose.
+func ExtractJSON(s string) string {
+	// Try to find <json> tags first
+	if start := strings.Index(s, "<json>"); start != -1 {
+		if end := strings.Index(s[start:], "</json>"); end != -1 {
+			return s[start+6 : start+end]
+		}
+	}
+
+	// Fallback to first { and last }
+	start := strings.Index(s, "{")
+	end := strings.LastIndex(s, "}")
+	if start != -1 && end != -1 && end > start {
+		return s[start : end+1]
+	}
+	return s
+}
+
+// ValidateSchema checks a decoded JSON value against the subset of JSON Schema
+// used in this project: type (single or list), properties, required, items and enum.
+func ValidateSchema(v interface{}, schema map[string]interface{}) error {
+	return validateAt("$", v, schema)
+}
+
+func validateAt(path string, v interface{}, schema map[string]interface{}) error {
+	if len(schema) == 0 {
+		return nil
+	}
+
+	if t, ok := schema["type"]; ok {
+		var types []string
+		switch tt := t.(type) {
+		case string:
+			types = []string{tt}
+		case []string:
+			types = tt
+		case []interface{}:
+			for _, x := range tt {
+				if s, ok := x.(string); ok {
+					types = append(types, s)
+				}
+			}
+		}
+		matched := len(types) == 0
+		for _, typ := range types {
+			if jsonTypeMatches(v, typ) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s must be of type %s", path, strings.Join(types, " or "))
+		}
+	}
+
+	if enum := schemaEnum(schema["enum"]); len(enum) > 0 {
+		found := false
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s must be one of %v", path, enum)
+		}
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, name := range schemaStrings(schema["required"]) {
+			if _, ok := val[name]; !ok {
+				return fmt.Errorf("%s is missing required field %q", path, name)
+			}
+		}
+		if props, ok := schema["properties"].(map[string]interface{}); ok {
+			names := make([]string, 0, len(props))
+			for name := range props {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				sub, present := val[name]
+				propSchema, _ := props[name].(map[string]interface{})
+				if !present || propSchema == nil {
+					continue
+				}
+				if err := validateAt(path+"."+name, sub, propSchema); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range val {
+				if err := validateAt(fmt.Sprintf("%s[%d]", path, i), item, items); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func jsonTypeMatches(v interface{}, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == float64(int64(f))
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "null":
+		return v == nil
+	}
+	return true
+}
+
+func schemaStrings(v interface{}) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		var out []string
+		for _, x := range list {
+			if s, ok := x.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func schemaEnum(v interface{}) []interface{} {
+	switch list := v.(type) {
+	case []interface{}:
+		return list
+	case []string:
+		out := make([]interface{}, len(list))
+		for i, s := range list {
+			out[i] = s
+		}
+		return out
+	}
+	return nil
+}
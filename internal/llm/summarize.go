@@ -0,0 +1,18 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Summarize condenses a conversation segment into a short summary that
+// preserves key facts and context.
+func Summarize(ctx context.Context, p Provider, messages []Message) (string, error) {
+	var transcript strings.Builder
+	for _, m := range messages {
+		transcript.WriteString(fmt.Sprintf("%s: %s\n", m.Role, m.Content))
+	}
+	prompt := fmt.Sprintf("Summarize the following conversation segment concisely, preserving key facts and context:\n\n%s", transcript.String())
+	return p.GenerateResponse(ctx, []Message{{Role: "user", Content: prompt}})
+}
@@ -0,0 +1,66 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rough per-family characters-per-token ratios. Exact counts would need each
+// model's tokenizer; these are close enough for context budgeting.
+var charsPerToken = []struct {
+	family string
+	ratio  float64
+}{
+	{"deepseek", 3.5},
+	{"qwen", 3.5},
+	{"llama", 3.8},
+	{"mistral", 3.6},
+	{"gemma", 4.0},
+	{"phi", 3.6},
+}
+
+const (
+	defaultCharsPerToken  = 3.5
+	messageOverheadTokens = 4   // Role markers and separators per message
+	imageTokens           = 768 // Approximate cost of one attached image
+)
+
+// EstimateTokens approximates the number of tokens text uses with model.
+func EstimateTokens(model, text string) int {
+	if text == "" {
+		return 0
+	}
+	ratio := defaultCharsPerToken
+	name := strings.ToLower(model)
+	for _, f := range charsPerToken {
+		if strings.Contains(name, f.family) {
+			ratio = f.ratio
+			break
+		}
+	}
+	return int(float64(utf8.RuneCountInString(text))/ratio) + 1
+}
+
+// EstimateMessages approximates the tokens a conversation uses with model,
+// including per-message overhead, tool calls and attached images.
+func EstimateMessages(model string, messages []Message) int {
+	total := 0
+	for _, m := range messages {
+		total += messageOverheadTokens + EstimateTokens(model, m.Content)
+		total += len(m.Images) * imageTokens
+		for _, tc := range m.ToolCalls {
+			total += EstimateTokens(model, tc.Function.Name) + EstimateTokens(model, string(tc.Function.Arguments))
+		}
+	}
+	return total
+}
+
+// EstimateTools approximates the tokens the tool definitions add to a request.
+func EstimateTools(model string, tools []ToolDefinition) int {
+	if len(tools) == 0 {
+		return 0
+	}
+	data, _ := json.Marshal(tools)
+	return EstimateTokens(model, string(data))
+}
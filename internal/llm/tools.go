@@ -0,0 +1,49 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ErrToolsUnsupported is returned by GenerateWithTools when the backend or model
+// rejects native tool calling. Callers should fall back to a text protocol.
+var ErrToolsUnsupported = errors.New("model does not support native tool calling")
+
+// ToolDefinition describes a callable tool in the function-calling format
+// shared by Ollama and OpenAI-compatible servers.
+type ToolDefinition struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction is the function part of a ToolDefinition.
+// Parameters is a JSON Schema object describing the arguments.
+type ToolFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+// ToolCall is a structured tool invocation returned by the model.
+type ToolCall struct {
+	ID       string           `json:"id,omitempty"` // Only set by OpenAI-compatible servers
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction holds the name and JSON-encoded arguments of a ToolCall.
+type ToolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// NewToolDefinition builds a function ToolDefinition.
+func NewToolDefinition(name, description string, parameters map[string]interface{}) ToolDefinition {
+	return ToolDefinition{
+		Type: "function",
+		Function: ToolFunction{
+			Name:        name,
+			Description: description,
+			Parameters:  parameters,
+		},
+	}
+}
@@ -3,13 +3,18 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/pyromancer/idony/internal/agent"
+	"github.com/google/uuid"
 	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
+	"github.com/pyromancer/idony/internal/tools/base"
 )
 
 type Server struct {
@@ -17,6 +22,11 @@ type Server struct {
 	SubManager     *agent.SubAgentManager
 	CouncilManager *agent.CouncilManager
 	Store          *db.Store
+	Approvals      *agent.ApprovalManager // Optional
+	SwarmManager   *agent.SwarmManager    // Optional
+	AgentDir       *agent.AgentDir        // Optional
+	Health         *llm.Health            // Optional; circuit breakers of the model endpoints
+	OllamaPool     *llm.OllamaPool        // Optional; servers requests are spread over
 	APIKey         string
 }
 
@@ -45,15 +55,42 @@ func (s *Server) auth(next http.HandlerFunc) http.HandlerFunc {
 
 func (s *Server) registerRoutes() {
 	http.HandleFunc("/chat", s.auth(s.handleChat))
+	http.HandleFunc("POST /chat/stream", s.auth(s.handleChatStream))
 	http.HandleFunc("/status", s.auth(s.handleStatus))
 	http.HandleFunc("/history", s.auth(s.handleHistory))
 	http.HandleFunc("/agents", s.auth(s.handleAgents))
 	http.HandleFunc("/councils", s.auth(s.handleCouncils))
+	http.HandleFunc("GET /agents/sync", s.auth(s.handleAgentsDiff))
+	http.HandleFunc("POST /agents/sync", s.auth(s.handleAgentsSync))
+	http.HandleFunc("POST /agents/export", s.auth(s.handleAgentsExport))
+	http.HandleFunc("POST /agents/import", s.auth(s.handleAgentImport))
+	http.HandleFunc("GET /agents/{name}/file", s.auth(s.handleAgentFile))
+	http.HandleFunc("GET /councils/{name}/file", s.auth(s.handleCouncilFile))
+	http.HandleFunc("GET /subagents/{id}/messages", s.auth(s.handleSubAgentMessages))
+	http.HandleFunc("POST /subagents/{id}/messages", s.auth(s.handleContinueSubAgent))
+	http.HandleFunc("GET /swarms", s.auth(s.handleListSwarms))
+	http.HandleFunc("POST /swarms", s.auth(s.handleDefineSwarm))
+	http.HandleFunc("DELETE /swarms/{name}", s.auth(s.handleDeleteSwarm))
+	http.HandleFunc("POST /swarms/{name}/run", s.auth(s.handleRunSwarm))
 	http.HandleFunc("/tools", s.auth(s.handleTools))
 	http.HandleFunc("/projects", s.auth(s.handleProjects))
 	http.HandleFunc("/tasks", s.auth(s.handleTasks))
 	http.HandleFunc("/assign_task", s.auth(s.handleAssignTask))
 	http.HandleFunc("/ui/schemas", s.auth(s.handleUISchemas))
+	http.HandleFunc("GET /sessions", s.auth(s.handleListSessions))
+	http.HandleFunc("POST /sessions", s.auth(s.handleCreateSession))
+	http.HandleFunc("PUT /sessions/{id}", s.auth(s.handleRenameSession))
+	http.HandleFunc("DELETE /sessions/{id}", s.auth(s.handleDeleteSession))
+	http.HandleFunc("GET /sessions/{id}/messages", s.auth(s.handleSessionMessages))
+	http.HandleFunc("GET /notifications", s.auth(s.handleNotifications))
+	http.HandleFunc("GET /agent-messages/threads", s.auth(s.handleAgentThreads))
+	http.HandleFunc("GET /agent-messages/threads/{id}", s.auth(s.handleAgentThread))
+	http.HandleFunc("GET /approvals", s.auth(s.handleListApprovals))
+	http.HandleFunc("POST /approvals/{id}", s.auth(s.handleDecideApproval))
+	http.HandleFunc("GET /runs", s.auth(s.handleListRuns))
+	http.HandleFunc("POST /runs/{id}/cancel", s.auth(s.handleCancelRun))
+	http.HandleFunc("GET /runs/recent", s.auth(s.handleRecentRuns))
+	http.HandleFunc("GET /runs/{id}/trace", s.auth(s.handleRunTrace))
 	
 	// Webhooks (No Auth required? Or maybe API key? Webhooks usually public or secret in URL)
 	// The ID acts as the secret.
@@ -108,11 +145,12 @@ func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
 	prompt := strings.ReplaceAll(hook.PromptTemplate, "{{payload}}", payload)
 	fmt.Printf("[Webhook Triggered] %s: %s\n", hook.Name, prompt)
 
-	// Run async
+	// Run async, each webhook in its own session. The session is keyed by name
+	// since the ID is the webhook's secret.
 	go func() {
-		ctx := context.Background()
+		ctx := agent.WithPriority(context.Background(), agent.PriorityWebhook)
 		if hook.TargetAgent == "main" {
-			s.Agent.Run(ctx, prompt)
+			s.Agent.RunStream(ctx, "webhook:"+hook.Name, prompt, nil, nil)
 		} else {
 			s.SubManager.SpawnNamed(ctx, hook.TargetAgent, prompt, nil)
 		}
@@ -166,8 +204,11 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Text   string   `json:"text"`
-		Images []string `json:"images,omitempty"`
+		Text      string   `json:"text"`
+		Images    []string `json:"images,omitempty"`
+		SessionID string   `json:"session_id,omitempty"`
+		// Options are model parameters for this message only, e.g. {"temperature": 0}
+		Options llm.Options `json:"options,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		fmt.Printf("[Server]: JSON Decode Error: %v\n", err)
@@ -190,23 +231,28 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 
 		if tool, ok := s.Agent.GetTools()[toolName]; ok {
 			fmt.Printf("[Server]: Calling tool: %s\n", toolName)
-			if len(req.Images) > 0 {
-				s.Agent.SetLastUserImages(req.Images)
-			}
-			response, err = tool.Execute(r.Context(), toolInput)
+			ctx := base.WithImages(base.WithSession(r.Context(), sessionOrDefault(req.SessionID)), req.Images)
+			response, err = tool.Execute(ctx, toolInput)
 		} else {
 			response = "Command not recognized."
 		}
 	} else {
-		if len(req.Images) > 0 {
-			fmt.Printf("[Server]: Running Vision (%d images)\n", len(req.Images))
-			response, err = s.Agent.RunVision(r.Context(), req.Text, req.Images)
-		} else {
-			fmt.Printf("[Server]: Running Agent...\n")
-			response, err = s.Agent.Run(r.Context(), req.Text)
-		}
+		fmt.Printf("[Server]: Running Agent (session %s, %d images)...\n", sessionOrDefault(req.SessionID), len(req.Images))
+		ctx := llm.WithCallOptions(r.Context(), req.Options)
+		response, err = s.Agent.RunStream(ctx, sessionOrDefault(req.SessionID), req.Text, req.Images, nil)
 	}
 
+	var abortErr *agent.AbortError
+	if errors.As(err, &abortErr) {
+		fmt.Printf("[Server]: Agent run aborted: %v\n", err)
+		json.NewEncoder(w).Encode(map[string]string{"response": response, "aborted": string(abortErr.Reason)})
+		return
+	}
+	if errors.Is(err, agent.ErrRunCancelled) {
+		fmt.Printf("[Server]: Agent run cancelled\n")
+		json.NewEncoder(w).Encode(map[string]string{"response": response, "cancelled": "true"})
+		return
+	}
 	if err != nil {
 		fmt.Printf("[Server]: Agent Error: %v\n", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -217,14 +263,91 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"response": response})
 }
 
+// handleChatStream works like handleChat but streams agent events to the client
+// as Server-Sent Events. Each event is a JSON-encoded agent.Event.
+func (s *Server) handleChatStream(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Text      string   `json:"text"`
+		Images    []string `json:"images,omitempty"`
+		SessionID string   `json:"session_id,omitempty"`
+		// Options are model parameters for this message only, e.g. {"temperature": 0}
+		Options llm.Options `json:"options,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sessionID := sessionOrDefault(req.SessionID)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(ev agent.Event) {
+		data, _ := json.Marshal(ev)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+
+	fmt.Printf("[Server]: Streaming: %s\n", req.Text)
+
+	if strings.HasPrefix(req.Text, "/") {
+		parts := strings.SplitN(req.Text[1:], " ", 2)
+		toolName := parts[0]
+		toolInput := ""
+		if len(parts) > 1 {
+			toolInput = parts[1]
+		}
+
+		tool, ok := s.Agent.GetTools()[toolName]
+		if !ok {
+			send(agent.Event{Type: agent.EventFinal, Content: "Command not recognized."})
+			return
+		}
+		send(agent.Event{Type: agent.EventToolCall, Tool: toolName, Input: toolInput})
+		ctx := base.WithImages(base.WithSession(r.Context(), sessionID), req.Images)
+		response, err := tool.Execute(ctx, toolInput)
+		if err != nil {
+			send(agent.Event{Type: agent.EventError, Content: err.Error()})
+			return
+		}
+		send(agent.Event{Type: agent.EventFinal, Content: response})
+		return
+	}
+
+	// RunStream emits the final (or error) event itself.
+	ctx := llm.WithCallOptions(r.Context(), req.Options)
+	if _, err := s.Agent.RunStream(ctx, sessionID, req.Text, req.Images, send); err != nil {
+		fmt.Printf("[Server]: Agent Error: %v\n", err)
+	}
+}
+
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	active, _ := s.SubManager.GetActive()
-	thinking := s.Agent.IsThinking()
+	running, queued := s.Agent.QueueStats()
+	_, queuedSubAgents := s.SubManager.PoolStats()
 	
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"thinking": thinking,
+	status := map[string]interface{}{
+		"thinking": running > 0,
+		"queued_runs": queued,
 		"active_subagents": active,
-	})
+		"queued_subagents": queuedSubAgents,
+	}
+	if s.Health != nil {
+		// Endpoints that failed since they last answered
+		status["model_endpoints"] = s.Health.Status()
+	}
+	if s.OllamaPool != nil {
+		status["ollama_hosts"] = s.OllamaPool.Status()
+	}
+	json.NewEncoder(w).Encode(status)
 }
 
 func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
@@ -237,11 +360,210 @@ func (s *Server) handleAgents(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(defs)
 }
 
+// handleSubAgentMessages returns the conversation of a sub-agent.
+func (s *Server) handleSubAgentMessages(w http.ResponseWriter, r *http.Request) {
+	msgs, err := s.Store.LoadLastMessages("subagent:"+r.PathValue("id"), 50)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if msgs == nil {
+		msgs = []db.Message{}
+	}
+	json.NewEncoder(w).Encode(msgs)
+}
+
+// handleContinueSubAgent sends a follow-up message to a sub-agent. It answers in
+// the background; its result is updated once the answer is done.
+func (s *Server) handleContinueSubAgent(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Message string   `json:"message"`
+		Images  []string `json:"images,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Message == "" {
+		http.Error(w, "message required", http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("id")
+	if err := s.SubManager.Continue(r.Context(), id, req.Message, req.Images); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id": id, "status": "running"})
+}
+
 func (s *Server) handleCouncils(w http.ResponseWriter, r *http.Request) {
 	councils, _ := s.CouncilManager.ListCouncils()
 	json.NewEncoder(w).Encode(councils)
 }
 
+// handleAgentsDiff lists the changes a sync of the agents directory would make.
+func (s *Server) handleAgentsDiff(w http.ResponseWriter, r *http.Request) {
+	if s.AgentDir == nil {
+		http.Error(w, "Agents directory is disabled", http.StatusNotFound)
+		return
+	}
+	changes, err := s.AgentDir.Diff()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if changes == nil {
+		changes = []agent.AgentChange{}
+	}
+	json.NewEncoder(w).Encode(changes)
+}
+
+// handleAgentsSync applies the agents directory to the database.
+func (s *Server) handleAgentsSync(w http.ResponseWriter, r *http.Request) {
+	if s.AgentDir == nil {
+		http.Error(w, "Agents directory is disabled", http.StatusNotFound)
+		return
+	}
+	changes, err := s.AgentDir.Sync()
+	if changes == nil {
+		changes = []agent.AgentChange{}
+	}
+	resp := map[string]interface{}{"changes": changes}
+	if err != nil {
+		resp["error"] = err.Error()
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleAgentsExport writes the definitions and councils of the database to
+// the agents directory.
+func (s *Server) handleAgentsExport(w http.ResponseWriter, r *http.Request) {
+	if s.AgentDir == nil {
+		http.Error(w, "Agents directory is disabled", http.StatusNotFound)
+		return
+	}
+	written, err := s.AgentDir.Export()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if written == nil {
+		written = []string{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"dir": s.AgentDir.Dir(), "written": written})
+}
+
+// handleAgentImport saves an agent or council file sent as the request body.
+func (s *Server) handleAgentImport(w http.ResponseWriter, r *http.Request) {
+	if s.AgentDir == nil {
+		http.Error(w, "Agents directory is disabled", http.StatusNotFound)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	change, err := s.AgentDir.Import(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(change)
+}
+
+// handleAgentFile returns a sub-agent definition as an agent file.
+func (s *Server) handleAgentFile(w http.ResponseWriter, r *http.Request) {
+	s.writeAgentFile(w, "Agent", s.AgentDir.ExportAgent, r.PathValue("name"))
+}
+
+// handleCouncilFile returns a council as a council file.
+func (s *Server) handleCouncilFile(w http.ResponseWriter, r *http.Request) {
+	s.writeAgentFile(w, "Council", s.AgentDir.ExportCouncil, r.PathValue("name"))
+}
+
+func (s *Server) writeAgentFile(w http.ResponseWriter, kind string, export func(string) ([]byte, error), name string) {
+	if s.AgentDir == nil {
+		http.Error(w, "Agents directory is disabled", http.StatusNotFound)
+		return
+	}
+	data, err := export(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data == nil {
+		http.Error(w, kind+" not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Write(data)
+}
+
+func (s *Server) handleListSwarms(w http.ResponseWriter, r *http.Request) {
+	swarms := []db.Swarm{}
+	if s.SwarmManager != nil {
+		list, err := s.SwarmManager.ListSwarms()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		swarms = append(swarms, list...)
+	}
+	json.NewEncoder(w).Encode(swarms)
+}
+
+// handleDefineSwarm creates or replaces a swarm from {"name", "members": {role: agent}}.
+func (s *Server) handleDefineSwarm(w http.ResponseWriter, r *http.Request) {
+	if s.SwarmManager == nil {
+		http.Error(w, "Swarms are disabled", http.StatusNotFound)
+		return
+	}
+	var req struct {
+		Name    string            `json:"name"`
+		Members map[string]string `json:"members"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.SwarmManager.DefineSwarm(req.Name, req.Members); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+func (s *Server) handleDeleteSwarm(w http.ResponseWriter, r *http.Request) {
+	if s.SwarmManager == nil {
+		http.Error(w, "Swarms are disabled", http.StatusNotFound)
+		return
+	}
+	if err := s.SwarmManager.DeleteSwarm(r.PathValue("name")); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// handleRunSwarm starts a swarm on a goal. The run is tracked as a sub-agent
+// task; its ID is returned and can be followed through /agents or cancelled.
+func (s *Server) handleRunSwarm(w http.ResponseWriter, r *http.Request) {
+	if s.SwarmManager == nil {
+		http.Error(w, "Swarms are disabled", http.StatusNotFound)
+		return
+	}
+	var req struct {
+		Goal string `json:"goal"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Goal == "" {
+		http.Error(w, "goal required", http.StatusBadRequest)
+		return
+	}
+	id, err := s.SwarmManager.RunSwarm(r.Context(), r.PathValue("name"), req.Goal)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id": id})
+}
+
 func (s *Server) handleTools(w http.ResponseWriter, r *http.Request) {
 	tools := s.Agent.GetTools()
 	var names []string
@@ -250,3 +572,233 @@ func (s *Server) handleTools(w http.ResponseWriter, r *http.Request) {
 	}
 	json.NewEncoder(w).Encode(names)
 }
+
+// sessionOrDefault maps requests without a session to the default session.
+func sessionOrDefault(id string) string {
+	if id == "" {
+		return agent.DefaultSession
+	}
+	return id
+}
+
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := s.Store.ListSessions(r.URL.Query().Get("channel"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID      string `json:"id,omitempty"`
+		Name    string `json:"name"`
+		Channel string `json:"channel,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		req.ID = uuid.New().String()[:8]
+	}
+	if req.Name == "" {
+		req.Name = req.ID
+	}
+	if req.Channel == "" {
+		req.Channel = db.SessionChannel(req.ID)
+	}
+
+	if err := s.Store.CreateSession(req.ID, req.Name, req.Channel); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	session, err := s.Store.GetSession(req.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(session)
+}
+
+func (s *Server) handleRenameSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, "name required", http.StatusBadRequest)
+		return
+	}
+
+	session, err := s.Store.GetSession(id)
+	if err != nil || session == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err := s.Store.RenameSession(id, req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == agent.DefaultSession {
+		http.Error(w, "The default session cannot be deleted", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.Store.DeleteSession(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.Agent.DropSession(id)
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// handleSessionMessages returns the most recent messages of a session so clients
+// can restore a conversation.
+func (s *Server) handleSessionMessages(w http.ResponseWriter, r *http.Request) {
+	msgs, err := s.Store.LoadLastMessages(r.PathValue("id"), 50)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(msgs)
+}
+
+// handleNotifications returns the notifications of finished background jobs for
+// a session (session_id) or a whole channel (channel), newer than the ID in "after".
+func (s *Server) handleNotifications(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	after, _ := strconv.Atoi(q.Get("after"))
+	notifications, err := s.Store.GetNotifications(q.Get("session_id"), q.Get("channel"), after, 50)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if notifications == nil {
+		notifications = []db.Notification{}
+	}
+	json.NewEncoder(w).Encode(notifications)
+}
+
+// handleAgentThreads lists the most recent conversations between agents.
+func (s *Server) handleAgentThreads(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 20
+	}
+	threads, err := s.Store.ListAgentThreads(limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if threads == nil {
+		threads = []db.AgentThread{}
+	}
+	json.NewEncoder(w).Encode(threads)
+}
+
+func (s *Server) handleAgentThread(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid thread ID", http.StatusBadRequest)
+		return
+	}
+	msgs, err := s.Store.GetAgentThread(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(msgs) == 0 {
+		http.Error(w, "Thread not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(msgs)
+}
+
+func (s *Server) handleListApprovals(w http.ResponseWriter, r *http.Request) {
+	approvals := []db.Approval{}
+	if s.Approvals != nil {
+		pending, err := s.Approvals.Pending()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		approvals = append(approvals, pending...)
+	}
+	json.NewEncoder(w).Encode(approvals)
+}
+
+// handleDecideApproval approves or denies a pending tool call. The waiting run
+// resumes with the decision as the tool's observation.
+func (s *Server) handleDecideApproval(w http.ResponseWriter, r *http.Request) {
+	if s.Approvals == nil {
+		http.Error(w, "Approvals are disabled", http.StatusNotFound)
+		return
+	}
+	var req struct {
+		Approve bool   `json:"approve"`
+		By      string `json:"by,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.By == "" {
+		req.By = "api"
+	}
+
+	if err := s.Approvals.Decide(r.PathValue("id"), req.Approve, req.By); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// handleListRuns lists the chat runs, sub-agents and council sessions in progress.
+func (s *Server) handleListRuns(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(s.Agent.Runs().List())
+}
+
+// handleCancelRun stops a run in progress. The run ends at its next step, or at
+// once if it is still queued or waiting for an approval.
+func (s *Server) handleCancelRun(w http.ResponseWriter, r *http.Request) {
+	if err := s.Agent.Runs().Cancel(r.PathValue("id")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// handleRecentRuns lists the most recently traced runs, optionally of one session.
+func (s *Server) handleRecentRuns(w http.ResponseWriter, r *http.Request) {
+	runs, err := s.Store.ListRecentRuns(r.URL.Query().Get("session_id"), 20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if runs == nil {
+		runs = []db.RunSummary{}
+	}
+	json.NewEncoder(w).Encode(runs)
+}
+
+// handleRunTrace returns every recorded step of a run: its input, the model
+// calls, the tool calls with their results and the outcome.
+func (s *Server) handleRunTrace(w http.ResponseWriter, r *http.Request) {
+	steps, err := s.Store.GetRunSteps(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(steps) == 0 {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(steps)
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pyromancer/idony/internal/agent"
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm/llmtest"
+)
+
+func newTestServer(t *testing.T, reply string) (*Server, *llmtest.Provider) {
+	t.Helper()
+	store, err := db.NewStore(filepath.Join(t.TempDir(), "idony.db") + "?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.DB.Close() })
+	client := llmtest.New(reply)
+	a := agent.NewAgent(client, store)
+	sm := agent.NewSubAgentManager(client, store, a.GetTools())
+	return NewServer(a, sm, agent.NewCouncilManager(client, store, sm), store, ""), client
+}
+
+// waitIdle waits until the provider answered n requests and the agent has no
+// runs left, for handlers that run the agent in the background.
+func waitIdle(t *testing.T, s *Server, client *llmtest.Provider, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		running, waiting := s.Agent.QueueStats()
+		if client.Calls() >= n && running == 0 && waiting == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("got %d of %d generations before the deadline", client.Calls(), n)
+}
+
+func TestHandleChatConcurrent(t *testing.T) {
+	s, client := newTestServer(t, "hello there")
+
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Half the requests share a session, the others have their own
+			session := "shared"
+			if i%2 == 1 {
+				session = fmt.Sprintf("own-%d", i)
+			}
+			body := fmt.Sprintf(`{"text": "message %d", "session_id": %q}`, i, session)
+			rec := httptest.NewRecorder()
+			s.handleChat(rec, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body)))
+			var resp map[string]string
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				errs <- fmt.Errorf("request %d: status %d: %v", i, rec.Code, err)
+				return
+			}
+			if resp["response"] != "hello there" {
+				errs <- fmt.Errorf("request %d: got response %q", i, resp["response"])
+			}
+		}(i)
+	}
+	// Changing the model while runs are in flight must not touch their client
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			s.Agent.SetModel(fmt.Sprintf("model-%d", i))
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if got := client.Calls(); got < n {
+		t.Errorf("got %d generations, want at least %d", got, n)
+	}
+}
+
+func TestHandleWebhookConcurrent(t *testing.T) {
+	s, client := newTestServer(t, "done")
+	for _, name := range []string{"build", "deploy"} {
+		hook := db.Webhook{ID: "secret-" + name, Name: name, TargetAgent: "main", PromptTemplate: "Event: {{payload}}"}
+		if err := s.Store.SaveWebhook(hook); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /webhook/{id}", s.handleWebhook)
+
+	const n = 8
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := "secret-build"
+			if i%2 == 1 {
+				id = "secret-deploy"
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhook/"+id, strings.NewReader(fmt.Sprintf(`{"n": %d}`, i))))
+			if rec.Code != http.StatusOK {
+				t.Errorf("webhook %d: status %d: %s", i, rec.Code, rec.Body.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+	waitIdle(t, s, client, n)
+}
@@ -3,6 +3,7 @@ package telegram
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -17,6 +18,7 @@ import (
 	"github.com/pyromancer/idony/internal/config"
 	"github.com/pyromancer/idony/internal/db"
 	"github.com/pyromancer/idony/internal/tools"
+	"github.com/pyromancer/idony/internal/tools/base"
 )
 
 type Bridge struct {
@@ -27,6 +29,7 @@ type Bridge struct {
 	conf         *config.Config
 	transcriber  *tools.TranscribeTool
 	tts          *tools.TTSTool
+	approvals    *agent.ApprovalManager
 }
 
 func NewBridge(token string, a *agent.Agent, store *db.Store, conf *config.Config) (*Bridge, error) {
@@ -40,6 +43,81 @@ func NewBridge(token string, a *agent.Agent, store *db.Store, conf *config.Confi
 	}, nil
 }
 
+// SetApprovals lets Telegram users approve or deny parked tool calls with inline
+// buttons. Requests are sent to the chat whose session made the call, or to
+// TELEGRAM_APPROVAL_CHAT for runs started elsewhere.
+func (b *Bridge) SetApprovals(m *agent.ApprovalManager) {
+	b.approvals = m
+	m.OnRequest(b.requestApproval)
+}
+
+// SetNotifier sends the results of sub-agents, councils and swarms started from
+// a chat back to that chat when they finish.
+func (b *Bridge) SetNotifier(n *agent.Notifier) {
+	n.OnNotify(b.notify)
+}
+
+func (b *Bridge) notify(n db.Notification) {
+	if b.bot == nil {
+		return
+	}
+	var chatID int64
+	if _, err := fmt.Sscanf(n.SessionID, "telegram:%d", &chatID); err != nil {
+		return
+	}
+	if n.Kind == "reply" {
+		b.sendText(chatID, n.Summary)
+		return
+	}
+	b.sendText(chatID, fmt.Sprintf("The %s %s is %s:\n%s", n.Kind, n.JobID, n.Status, n.Summary))
+}
+
+func (b *Bridge) requestApproval(a db.Approval) {
+	if b.bot == nil {
+		return
+	}
+	var chatID int64
+	if _, err := fmt.Sscanf(a.SessionID, "telegram:%d", &chatID); err != nil {
+		fmt.Sscanf(b.conf.Get("TELEGRAM_APPROVAL_CHAT"), "%d", &chatID)
+	}
+	if chatID == 0 {
+		return
+	}
+
+	input := a.Input
+	if len(input) > 500 {
+		input = input[:497] + "..."
+	}
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Approval needed (session %s):\n%s %s", a.SessionID, a.Tool, input))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Approve", "approve:"+a.ID),
+		tgbotapi.NewInlineKeyboardButtonData("Deny", "deny:"+a.ID),
+	))
+	if _, err := b.bot.Send(msg); err != nil {
+		log.Printf("Failed to send approval request: %v", err)
+	}
+}
+
+// handleCallback handles the approval buttons.
+func (b *Bridge) handleCallback(cq *tgbotapi.CallbackQuery) {
+	action, id, ok := strings.Cut(cq.Data, ":")
+	if !ok || b.approvals == nil || (action != "approve" && action != "deny") {
+		return
+	}
+
+	text := "Denied."
+	if action == "approve" {
+		text = "Approved."
+	}
+	if err := b.approvals.Decide(id, action == "approve", "telegram:"+cq.From.UserName); err != nil {
+		text = err.Error()
+	}
+	b.bot.Request(tgbotapi.NewCallback(cq.ID, text))
+	if cq.Message != nil {
+		b.bot.Send(tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, cq.Message.Text+"\n\n"+text))
+	}
+}
+
 func (b *Bridge) isAllowed(userID string) bool {
 	allowedStr := b.conf.Get("TELEGRAM_ALLOWED_USERS")
 	if allowedStr == "*" {
@@ -85,6 +163,12 @@ func (b *Bridge) Start() {
 		updates := b.bot.GetUpdatesChan(u)
 
 		for update := range updates {
+			if update.CallbackQuery != nil {
+				if b.isAllowed(fmt.Sprintf("%d", update.CallbackQuery.From.ID)) {
+					go b.handleCallback(update.CallbackQuery)
+				}
+				continue
+			}
 			if update.Message == nil {
 				continue
 			}
@@ -117,12 +201,22 @@ func (b *Bridge) registerCommands() {
 		})
 	}
 
+	tgCommands = append(tgCommands, tgbotapi.BotCommand{
+		Command:     "stop",
+		Description: "Stop the agent's current turn in this chat",
+	})
+
 	config := tgbotapi.NewSetMyCommands(tgCommands...)
 	if _, err := b.bot.Request(config); err != nil {
 		log.Printf("Failed to register Telegram commands: %v", err)
 	}
 }
 
+// sessionID maps every Telegram chat to its own conversation session.
+func sessionID(chatID int64) string {
+	return fmt.Sprintf("telegram:%d", chatID)
+}
+
 func (b *Bridge) handleMessage(m *tgbotapi.Message) {
 	var input string
 	var b64Images []string
@@ -155,7 +249,14 @@ func (b *Bridge) handleMessage(m *tgbotapi.Message) {
 		return
 	}
 
+	if input == "/stop" {
+		b.stop(m.Chat.ID)
+		return
+	}
+
 	var response string
+	var delivered bool
+	wantsVoice := m.Voice != nil || strings.Contains(strings.ToLower(input), "speak")
 	if strings.HasPrefix(input, "/") {
 		parts := strings.SplitN(input[1:], " ", 2)
 		toolName := parts[0]
@@ -164,31 +265,103 @@ func (b *Bridge) handleMessage(m *tgbotapi.Message) {
 
 		if tool, ok := b.agent.GetTools()[toolName]; ok {
 			b.sendText(m.Chat.ID, fmt.Sprintf("[Direct Tool Execution]: %s", toolName))
-			if len(b64Images) > 0 {
-				b.agent.SetLastUserImages(b64Images)
-			}
-			response, err = tool.Execute(context.Background(), toolInput)
+			ctx := base.WithImages(base.WithSession(context.Background(), sessionID(m.Chat.ID)), b64Images)
+			response, err = tool.Execute(ctx, toolInput)
 		} else {
 			response = "Command not recognized."
 		}
-	} else if len(b64Images) > 0 {
-		response, err = b.agent.RunVision(context.Background(), input, b64Images)
+	} else if wantsVoice {
+		response, err = b.agent.RunStream(context.Background(), sessionID(m.Chat.ID), input, b64Images, nil)
 	} else {
-		response, err = b.agent.Run(context.Background(), input)
+		response, delivered, err = b.runStreaming(m.Chat.ID, input, b64Images)
 	}
 
+	if errors.Is(err, agent.ErrRunCancelled) {
+		// /stop already told the user
+		return
+	}
 	if err != nil {
-		b.sendText(m.Chat.ID, fmt.Sprintf("Agent Error: %v", err))
+		if !delivered {
+			b.sendText(m.Chat.ID, fmt.Sprintf("Agent Error: %v", err))
+		}
 		return
 	}
 
-	if m.Voice != nil || strings.Contains(strings.ToLower(input), "speak") {
+	if wantsVoice {
 		b.sendVoice(m.Chat.ID, response)
-	} else {
+	} else if !delivered {
 		b.sendText(m.Chat.ID, response)
 	}
 }
 
+// stop cancels the runs of the chat's session, including queued ones.
+func (b *Bridge) stop(chatID int64) {
+	if n := b.agent.Runs().CancelSession(sessionID(chatID)); n > 0 {
+		b.sendText(chatID, fmt.Sprintf("Stopped %d run(s).", n))
+	} else {
+		b.sendText(chatID, "Nothing is running.")
+	}
+}
+
+// runStreaming runs the agent and progressively edits a Telegram message with the
+// answer as it is generated. It reports whether the final answer, or the error
+// that ended the run, was already delivered; a partial answer is never left behind.
+func (b *Bridge) runStreaming(chatID int64, input string, images []string) (string, bool, error) {
+	var msgID int
+	var text strings.Builder
+	var shown string // Text of the message as last sent or edited
+	var lastEdit time.Time
+
+	emit := func(ev agent.Event) {
+		switch ev.Type {
+		case agent.EventToolCall:
+			b.bot.Send(tgbotapi.NewChatAction(chatID, tgbotapi.ChatTyping))
+		case agent.EventReset:
+			// The final edit replaces whatever was shown
+			text.Reset()
+		case agent.EventToken:
+			text.WriteString(ev.Content)
+			if strings.TrimSpace(text.String()) == "" {
+				return
+			}
+			if msgID == 0 {
+				sent, err := b.bot.Send(tgbotapi.NewMessage(chatID, text.String()))
+				if err == nil {
+					msgID = sent.MessageID
+					shown = text.String()
+					lastEdit = time.Now()
+				}
+				return
+			}
+			// Telegram rate-limits edits, so only refresh about once per second.
+			if time.Since(lastEdit) >= time.Second {
+				b.bot.Send(tgbotapi.NewEditMessageText(chatID, msgID, text.String()))
+				shown = text.String()
+				lastEdit = time.Now()
+			}
+		}
+	}
+
+	response, err := b.agent.RunStream(context.Background(), sessionID(chatID), input, images, emit)
+	if msgID == 0 {
+		return response, false, err
+	}
+	if errors.Is(err, agent.ErrRunCancelled) {
+		// /stop already told the user, so the partial answer just goes away
+		b.bot.Request(tgbotapi.NewDeleteMessage(chatID, msgID))
+		return response, true, err
+	}
+	if err != nil {
+		b.bot.Send(tgbotapi.NewEditMessageText(chatID, msgID, fmt.Sprintf("Agent Error: %v", err)))
+		return response, true, err
+	}
+	// Tokens that came in less than a second after the last edit are not shown yet
+	if response != shown {
+		b.bot.Send(tgbotapi.NewEditMessageText(chatID, msgID, response))
+	}
+	return response, true, nil
+}
+
 func (b *Bridge) processVoice(v *tgbotapi.Voice) (string, error) {
 	fileURL, err := b.bot.GetFileDirectURL(v.FileID)
 	if err != nil {
@@ -0,0 +1,161 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pyromancer/idony/internal/agent"
+	"github.com/pyromancer/idony/internal/config"
+	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
+	"github.com/pyromancer/idony/internal/llm/llmtest"
+)
+
+// fakeTelegram is a Bot API server that accepts every call and records the
+// calls made for each chat as "method: text".
+type fakeTelegram struct {
+	mu   sync.Mutex
+	sent map[string][]string
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if chat := r.Form.Get("chat_id"); chat != "" {
+		f.mu.Lock()
+		f.sent[chat] = append(f.sent[chat], path.Base(r.URL.Path)+": "+r.Form.Get("text"))
+		f.mu.Unlock()
+	}
+	// One result that decodes both as the bot's user and as a sent message
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok": true,
+		"result": map[string]interface{}{
+			"id": 1, "is_bot": true, "username": "idony",
+			"message_id": 1, "date": 0, "chat": map[string]interface{}{"id": 1, "type": "private"},
+		},
+	})
+}
+
+// newTestBridge returns a bridge for an agent on client that talks to a
+// fake Bot API server.
+func newTestBridge(t *testing.T, client llm.Provider) (*Bridge, *fakeTelegram) {
+	store, err := db.NewStore(filepath.Join(t.TempDir(), "idony.db") + "?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.DB.Close() })
+
+	api := &fakeTelegram{sent: make(map[string][]string)}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	bot, err := tgbotapi.NewBotAPIWithClient("token", srv.URL+"/bot%s/%s", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := agent.NewAgent(client, store)
+	confPath := filepath.Join(t.TempDir(), "config.txt")
+	if err := os.WriteFile(confPath, []byte("TELEGRAM_ALLOWED_USERS=*\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.LoadConfig(confPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewBridge("token", a, store, conf)
+	b.bot = bot
+	return b, api
+}
+
+func TestHandleMessageConcurrent(t *testing.T) {
+	client := llmtest.New("hello from idony")
+	b, api := newTestBridge(t, client)
+
+	const chats, perChat = 4, 3
+	var wg sync.WaitGroup
+	for c := 0; c < chats; c++ {
+		for i := 0; i < perChat; i++ {
+			wg.Add(1)
+			go func(chat int64, i int) {
+				defer wg.Done()
+				b.handleMessage(&tgbotapi.Message{
+					From: &tgbotapi.User{ID: chat},
+					Chat: &tgbotapi.Chat{ID: chat},
+					Text: fmt.Sprintf("message %d", i),
+				})
+			}(int64(c+1), i)
+		}
+	}
+	wg.Wait()
+
+	if got := client.Calls(); got < chats*perChat {
+		t.Errorf("got %d generations, want at least %d", got, chats*perChat)
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for c := 1; c <= chats; c++ {
+		answers := 0
+		for _, text := range api.sent[fmt.Sprint(c)] {
+			if strings.Contains(text, "hello from idony") {
+				answers++
+			}
+		}
+		if answers < perChat {
+			t.Errorf("chat %d got %d answers, want %d: %q", c, answers, perChat, api.sent[fmt.Sprint(c)])
+		}
+	}
+}
+
+// brokenStream streams the start of an answer and then fails.
+type brokenStream struct {
+	*llmtest.Provider
+}
+
+func (p *brokenStream) GenerateWithTools(ctx context.Context, messages []llm.Message, tools []llm.ToolDefinition, onDelta func(string)) (llm.Message, error) {
+	onDelta("The answer is ")
+	return llm.Message{}, errors.New("connection reset")
+}
+
+func (p *brokenStream) GenerateJSON(ctx context.Context, messages []llm.Message, schema map[string]interface{}, onDelta func(string)) (string, error) {
+	onDelta("The answer is ")
+	return "", errors.New("connection reset")
+}
+
+func (p *brokenStream) WithModel(model string) llm.Provider {
+	return &brokenStream{p.Provider.WithModel(model).(*llmtest.Provider)}
+}
+
+func (p *brokenStream) WithOptions(opts llm.Options) llm.Provider {
+	return &brokenStream{p.Provider.WithOptions(opts).(*llmtest.Provider)}
+}
+
+func TestFailedStreamReplacesPartialAnswer(t *testing.T) {
+	b, api := newTestBridge(t, &brokenStream{llmtest.New("")})
+	b.handleMessage(&tgbotapi.Message{
+		From: &tgbotapi.User{ID: 1},
+		Chat: &tgbotapi.Chat{ID: 1},
+		Text: "what is the answer?",
+	})
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	calls := api.sent["1"]
+	if len(calls) == 0 || !strings.HasPrefix(calls[len(calls)-1], "editMessageText: Agent Error") {
+		t.Fatalf("partial answer was not replaced by the error: %q", calls)
+	}
+	for _, call := range calls[:len(calls)-1] {
+		if strings.Contains(call, "Agent Error") {
+			t.Errorf("error was also sent separately: %q", calls)
+		}
+	}
+}
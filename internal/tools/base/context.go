@@ -0,0 +1,47 @@
+package base
+
+import "context"
+
+type (
+	sessionKey struct{}
+	imagesKey  struct{}
+	agentKey   struct{}
+)
+
+// WithSession returns a context that tells tools which conversation session
+// they are running in.
+func WithSession(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, sessionID)
+}
+
+// SessionID returns the session set by WithSession, or "" if there is none.
+func SessionID(ctx context.Context) string {
+	id, _ := ctx.Value(sessionKey{}).(string)
+	return id
+}
+
+// WithImages returns a context carrying the base64 images the user attached to
+// the current request, so tools can work with them.
+func WithImages(ctx context.Context, images []string) context.Context {
+	return context.WithValue(ctx, imagesKey{}, images)
+}
+
+// Images returns the images set by WithImages, or nil if there are none.
+func Images(ctx context.Context) []string {
+	images, _ := ctx.Value(imagesKey{}).([]string)
+	return images
+}
+
+// WithAgent returns a context that tells tools which agent is calling them, by
+// the name of its sub-agent definition.
+func WithAgent(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, agentKey{}, name)
+}
+
+// AgentName returns the agent set by WithAgent, or "main" for the main agent.
+func AgentName(ctx context.Context) string {
+	if name, _ := ctx.Value(agentKey{}).(string); name != "" {
+		return name
+	}
+	return "main"
+}
@@ -13,3 +13,10 @@ type Tool interface {
 	// Schema returns a UI schema for the mobile app to render dynamically.
 	Schema() map[string]interface{}
 }
+
+// JSONSchemaTool is implemented by tools whose input is already described by a
+// standard JSON Schema (e.g. MCP tools). The agent offers that schema to models
+// with native tool calling instead of deriving one from Schema().
+type JSONSchemaTool interface {
+	InputSchema() map[string]interface{}
+}
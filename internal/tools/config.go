@@ -7,6 +7,7 @@ import (
 	"strings"
 	"github.com/pyromancer/idony/internal/config"
 	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
 )
 
 // Refreshable interface defines things that can pick up config changes.
@@ -15,6 +16,30 @@ type Refreshable interface {
 	SetBaseURL(string)
 }
 
+// Refreshables passes config changes on to several Refreshables, e.g. the
+// main agent and the sub-agent manager.
+type Refreshables []Refreshable
+
+func (r Refreshables) SetModel(model string) {
+	for _, x := range r {
+		x.SetModel(model)
+	}
+}
+
+func (r Refreshables) SetBaseURL(url string) {
+	for _, x := range r {
+		x.SetBaseURL(url)
+	}
+}
+
+// llmURLKey returns the config key holding the server address of the active LLM provider.
+func llmURLKey(conf *config.Config) string {
+	if conf != nil && llm.ProviderName(conf.Get("LLM_PROVIDER")) == llm.ProviderOpenAI {
+		return "OPENAI_URL"
+	}
+	return "OLLAMA_URL"
+}
+
 // ConfigUpdateTool allows Idony to update its own config.txt
 type ConfigUpdateTool struct {
 	conf       *config.Config
@@ -52,7 +77,7 @@ func (c *ConfigUpdateTool) Execute(ctx context.Context, input string) (string, e
 	if c.agent != nil {
 		if key == "MODEL" {
 			c.agent.SetModel(val)
-		} else if key == "OLLAMA_URL" {
+		} else if key == llmURLKey(c.conf) {
 			c.agent.SetBaseURL(val)
 		}
 	}
@@ -122,7 +147,11 @@ func (c *ReloadConfigTool) Execute(ctx context.Context, input string) (string, e
 	// Refresh agent from new config
 	if c.agent != nil {
 		c.agent.SetModel(c.conf.GetWithDefault("MODEL", "llama3.1"))
-		c.agent.SetBaseURL(c.conf.GetWithDefault("OLLAMA_URL", "http://localhost:11434"))
+		if llmURLKey(c.conf) == "OPENAI_URL" {
+			c.agent.SetBaseURL(c.conf.GetWithDefault("OPENAI_URL", "http://localhost:8000/v1"))
+		} else {
+			c.agent.SetBaseURL(c.conf.GetWithDefault("OLLAMA_URL", "http://localhost:11434"))
+		}
 	}
 
 	return "Successfully reloaded configuration and refreshed agent from " + c.configPath, nil
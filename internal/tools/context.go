@@ -6,18 +6,15 @@ import (
 
 	"github.com/pyromancer/idony/internal/db"
 	"github.com/pyromancer/idony/internal/llm"
+	"github.com/pyromancer/idony/internal/tools/base"
 )
 
-type Summarizer interface {
-	GenerateResponse(ctx context.Context, messages []llm.Message) (string, error)
-}
-
 type CompactTool struct {
 	store  *db.Store
-	client Summarizer
+	client llm.Provider
 }
 
-func NewCompactTool(store *db.Store, client Summarizer) *CompactTool {
+func NewCompactTool(store *db.Store, client llm.Provider) *CompactTool {
 	return &CompactTool{store: store, client: client}
 }
 
@@ -26,12 +23,17 @@ func (c *CompactTool) Name() string {
 }
 
 func (c *CompactTool) Description() string {
-	return "Summarizes older conversation history to save tokens. Input: ignored."
+	return "Summarizes older conversation history of the current session to save tokens. Input: ignored."
 }
 
 func (c *CompactTool) Execute(ctx context.Context, input string) (string, error) {
+	sessionID := base.SessionID(ctx)
+	if sessionID == "" {
+		sessionID = db.DefaultSessionID
+	}
+
 	// 1. Fetch oldest 10 messages (arbitrary chunk size)
-	msgs, err := c.store.GetOldestMessages(10)
+	msgs, err := c.store.GetOldestMessages(sessionID, 10)
 	if err != nil {
 		return "", err
 	}
@@ -39,31 +41,28 @@ func (c *CompactTool) Execute(ctx context.Context, input string) (string, error)
 		return "History is too short to compact.", nil
 	}
 
-	// 2. Format for summarization
-	var transcript string
+	// 2. Ask LLM to summarize
+	var segment []llm.Message
 	var ids []int
 	for _, m := range msgs {
-		transcript += fmt.Sprintf("%s: %s\n", m.Role, m.Content)
+		segment = append(segment, llm.Message{Role: m.Role, Content: m.Content})
 		ids = append(ids, m.ID)
 	}
 
-	// 3. Ask LLM to summarize
-	prompt := fmt.Sprintf("Summarize the following conversation segment concisely, preserving key facts and context:\n\n%s", transcript)
-	
-	summary, err := c.client.GenerateResponse(ctx, []llm.Message{{Role: "user", Content: prompt}})
+	summary, err := llm.Summarize(ctx, c.client, segment)
 	if err != nil {
 		return "", fmt.Errorf("summarization failed: %w", err)
 	}
 
-	// 4. Delete old messages
+	// 3. Delete old messages
 	err = c.store.DeleteMessages(ids)
 	if err != nil {
 		return "", fmt.Errorf("failed to delete old messages: %w", err)
 	}
 
-	// 5. Insert summary as a system/context message (or just a user message saying "Previous context: ...")
+	// 4. Insert summary as a system/context message (or just a user message saying "Previous context: ...")
 	// We'll use 'system' role if supported, or 'assistant'
-	err = c.store.SaveMessage("system", fmt.Sprintf("Summary of previous conversation: %s", summary))
+	err = c.store.SaveMessage(sessionID, "system", fmt.Sprintf("Summary of previous conversation: %s", summary))
 	if err != nil {
 		return "", err
 	}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/pyromancer/idony/internal/db"
 )
 
 type CouncilInteractionManager interface {
-	RunCouncilSession(ctx context.Context, councilName, problem string) (string, error)
-	DefineCouncil(name string, members []string) error
+	RunCouncilSession(ctx context.Context, councilName, problem string, options []string) (string, error)
+	DefineCouncil(council db.Council) error
 	ListCouncils() ([]db.Council, error)
 }
 
@@ -28,15 +31,24 @@ func (c *CouncilTool) Name() string {
 
 func (c *CouncilTool) Description() string {
 	return `Manages agent councils. Input must be a JSON object: 
-{"action": "define|run|list", "name": "council_name", "members": ["member1", "member2"], "problem": "the problem for the council to solve"}`
+{"action": "define|run|list", "name": "council_name", "members": ["member1", "member2"], "problem": "the problem for the council to solve"}
+define also takes "rounds" (default 2), "order" ("sequential", "random" or "parallel"), "moderator" (a sub-agent that gives the final verdict), "voting" (true to have members vote on the proposed options) and "stop_on_consensus" (true to end the discussion once members agree).
+run also takes "options": ["...", "..."] for members to vote on; without them they vote on the options proposed in the discussion.`
 }
 
 func (c *CouncilTool) Execute(ctx context.Context, input string) (string, error) {
+	// Lists, numbers and booleans are strings when they come from the PWA form
 	var req struct {
-		Action  string   `json:"action"`
-		Name    string   `json:"name"`
-		Members []string `json:"members"`
-		Problem string   `json:"problem"`
+		Action          string          `json:"action"`
+		Name            string          `json:"name"`
+		Members         json.RawMessage `json:"members"`
+		Problem         string          `json:"problem"`
+		Options         json.RawMessage `json:"options"`
+		Rounds          json.RawMessage `json:"rounds"`
+		Order           string          `json:"order"`
+		Moderator       string          `json:"moderator"`
+		Voting          json.RawMessage `json:"voting"`
+		StopOnConsensus json.RawMessage `json:"stop_on_consensus"`
 	}
 
 	if err := json.Unmarshal([]byte(input), &req); err != nil {
@@ -45,11 +57,26 @@ func (c *CouncilTool) Execute(ctx context.Context, input string) (string, error)
 
 	switch req.Action {
 	case "define":
-		if req.Name == "" || len(req.Members) == 0 {
+		members, err := parseList(req.Members, ",")
+		if err != nil {
+			return "", fmt.Errorf("invalid members: %w", err)
+		}
+		if req.Name == "" || len(members) == 0 {
 			return "", fmt.Errorf("name and members are required for define")
 		}
-		err := c.manager.DefineCouncil(req.Name, req.Members)
-		if err != nil {
+		council := db.Council{Name: req.Name, Members: strings.Join(members, ","), Order: req.Order, Moderator: req.Moderator}
+		if v := rawValue(req.Rounds); v != "" {
+			if council.Rounds, err = strconv.Atoi(v); err != nil {
+				return "", fmt.Errorf("rounds must be a number, got %s", v)
+			}
+		}
+		if council.Voting, err = parseFlag(req.Voting); err != nil {
+			return "", fmt.Errorf("invalid voting: %w", err)
+		}
+		if council.StopOnConsensus, err = parseFlag(req.StopOnConsensus); err != nil {
+			return "", fmt.Errorf("invalid stop_on_consensus: %w", err)
+		}
+		if err := c.manager.DefineCouncil(council); err != nil {
 			return "", err
 		}
 		return fmt.Sprintf("Successfully defined council: %s", req.Name), nil
@@ -57,7 +84,12 @@ func (c *CouncilTool) Execute(ctx context.Context, input string) (string, error)
 		if req.Name == "" || req.Problem == "" {
 			return "", fmt.Errorf("name and problem are required for run")
 		}
-		id, err := c.manager.RunCouncilSession(ctx, req.Name, req.Problem)
+		// Options may contain commas, so the form puts one per line
+		options, err := parseList(req.Options, "\n")
+		if err != nil {
+			return "", fmt.Errorf("invalid options: %w", err)
+		}
+		id, err := c.manager.RunCouncilSession(ctx, req.Name, req.Problem, options)
 		if err != nil {
 			return "", err
 		}
@@ -69,7 +101,7 @@ func (c *CouncilTool) Execute(ctx context.Context, input string) (string, error)
 		}
 		var res string
 		for _, cn := range councils {
-			res += fmt.Sprintf("- %s: Members (%s)\n", cn.Name, cn.Members)
+			res += fmt.Sprintf("- %s: Members (%s)%s\n", cn.Name, cn.Members, councilSettings(cn))
 		}
 		if res == "" {
 			return "No councils defined yet.", nil
@@ -80,6 +112,73 @@ func (c *CouncilTool) Execute(ctx context.Context, input string) (string, error)
 	}
 }
 
+// councilSettings describes the settings of a council that differ from the defaults.
+func councilSettings(c db.Council) string {
+	var settings []string
+	if c.Rounds > 0 {
+		settings = append(settings, fmt.Sprintf("%d rounds", c.Rounds))
+	}
+	if c.Order != "" {
+		settings = append(settings, c.Order+" order")
+	}
+	if c.Moderator != "" {
+		settings = append(settings, "moderator "+c.Moderator)
+	}
+	if c.Voting {
+		settings = append(settings, "voting")
+	}
+	if c.StopOnConsensus {
+		settings = append(settings, "stops on consensus")
+	}
+	if len(settings) == 0 {
+		return ""
+	}
+	return ", " + strings.Join(settings, ", ")
+}
+
+// rawValue returns a JSON number, boolean or string as plain text, or "" if it is absent.
+func rawValue(raw json.RawMessage) string {
+	v := strings.TrimSpace(string(raw))
+	if v == "null" {
+		return ""
+	}
+	if unquoted, err := strconv.Unquote(v); err == nil {
+		return strings.TrimSpace(unquoted)
+	}
+	return v
+}
+
+// parseFlag reads a boolean given as true/false or as a string.
+func parseFlag(raw json.RawMessage) (bool, error) {
+	v := rawValue(raw)
+	if v == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+// parseList reads a JSON array of strings, or a string of items separated by sep.
+func parseList(raw json.RawMessage, sep string) ([]string, error) {
+	if len(raw) == 0 || rawValue(raw) == "" {
+		return nil, nil
+	}
+	var items []string
+	if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, err
+		}
+	} else {
+		items = strings.Split(rawValue(raw), sep)
+	}
+	var list []string
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list, nil
+}
+
 func (c *CouncilTool) Schema() map[string]interface{} {
 	return map[string]interface{}{
 		"title": "Agent Council",
@@ -90,6 +189,7 @@ func (c *CouncilTool) Schema() map[string]interface{} {
 				"fields": []map[string]interface{}{
 					{"name": "name", "label": "Council Name", "type": "string", "required": true},
 					{"name": "problem", "label": "Problem to Solve", "type": "longtext", "required": true},
+					{"name": "options", "label": "Options to Vote On (one per line)", "type": "longtext"},
 				},
 			},
 			{
@@ -98,6 +198,11 @@ func (c *CouncilTool) Schema() map[string]interface{} {
 				"fields": []map[string]interface{}{
 					{"name": "name", "label": "Name", "type": "string", "required": true},
 					{"name": "members", "label": "Members (comma-separated)", "type": "string", "hint": "agent1,agent2"},
+					{"name": "rounds", "label": "Rounds", "type": "string", "hint": "2"},
+					{"name": "order", "label": "Speaking Order", "type": "string", "hint": "sequential, random or parallel"},
+					{"name": "moderator", "label": "Moderator (sub-agent)", "type": "string"},
+					{"name": "voting", "label": "Vote on Options", "type": "string", "hint": "true or false"},
+					{"name": "stop_on_consensus", "label": "Stop on Consensus", "type": "string", "hint": "true or false"},
 				},
 			},
 			{
@@ -9,10 +9,10 @@ import (
 )
 
 type ModelListTool struct {
-	client *llm.OllamaClient
+	client llm.Provider
 }
 
-func NewModelListTool(c *llm.OllamaClient) *ModelListTool {
+func NewModelListTool(c llm.Provider) *ModelListTool {
 	return &ModelListTool{client: c}
 }
 
@@ -21,7 +21,7 @@ func (m *ModelListTool) Name() string {
 }
 
 func (m *ModelListTool) Description() string {
-	return "Lists all available LLM models on the Ollama server."
+	return "Lists all available LLM models on the configured LLM server."
 }
 
 func (m *ModelListTool) Execute(ctx context.Context, input string) (string, error) {
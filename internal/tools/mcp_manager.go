@@ -163,6 +163,11 @@ func (w *MCPToolWrapper) Execute(ctx context.Context, input string) (string, err
 	return w.client.CallTool(w.tool.Name, args)
 }
 
+// InputSchema returns the JSON Schema advertised by the MCP server.
+func (w *MCPToolWrapper) InputSchema() map[string]interface{} {
+	return w.tool.InputSchema
+}
+
 func (w *MCPToolWrapper) Schema() map[string]interface{} {
 	// Convert MCP inputSchema (JSON Schema) to our UI Schema
 	// MCP schema is standard JSON Schema. Our UI schema is custom.
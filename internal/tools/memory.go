@@ -4,17 +4,24 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/pyromancer/idony/internal/db"
 )
 
+// MemoryIndex saves long-term memories and finds the ones relevant to a query.
+type MemoryIndex interface {
+	Remember(ctx context.Context, m db.Memory) (int, error)
+	Recall(ctx context.Context, query string, limit int) ([]db.Memory, error)
+}
+
 type MemoryTool struct {
-	store *db.Store
+	index MemoryIndex
 }
 
-func NewMemoryTool(store *db.Store) *MemoryTool {
-	return &MemoryTool{store: store}
+func NewMemoryTool(index MemoryIndex) *MemoryTool {
+	return &MemoryTool{index: index}
 }
 
 func (m *MemoryTool) Name() string {
@@ -23,7 +30,7 @@ func (m *MemoryTool) Name() string {
 
 func (m *MemoryTool) Description() string {
 	return `Stores a fact, preference, or observation in long-term memory.
-Input: {"content": "The user likes blue", "type": "preference|fact", "tags": "user,color"}`
+Input: {"content": "The user likes blue", "type": "preference|fact", "tags": "user,color", "importance": 1-5 (optional, default 3)}`
 }
 
 func (m *MemoryTool) Execute(ctx context.Context, input string) (string, error) {
@@ -31,6 +38,8 @@ func (m *MemoryTool) Execute(ctx context.Context, input string) (string, error)
 		Content string `json:"content"`
 		Type    string `json:"type"`
 		Tags    string `json:"tags"`
+		// A number from the agent, a string from the PWA form
+		Importance json.RawMessage `json:"importance"`
 	}
 
 	if err := json.Unmarshal([]byte(input), &req); err != nil {
@@ -43,8 +52,15 @@ func (m *MemoryTool) Execute(ctx context.Context, input string) (string, error)
 		return "", fmt.Errorf("content is required")
 	}
 
-	err := m.store.SaveMemory(req.Content, req.Type, req.Tags)
-	if err != nil {
+	memory := db.Memory{Content: req.Content, Type: req.Type, Tags: req.Tags}
+	if v := rawValue(req.Importance); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 5 {
+			return "", fmt.Errorf("importance must be a number from 1 to 5, got %s", v)
+		}
+		memory.Importance = n
+	}
+	if _, err := m.index.Remember(ctx, memory); err != nil {
 		return "", err
 	}
 
@@ -58,17 +74,18 @@ func (m *MemoryTool) Schema() map[string]interface{} {
 			{"name": "content", "label": "Memory Content", "type": "longtext", "required": true},
 			{"name": "type", "label": "Type", "type": "choice", "options": []string{"fact", "preference", "observation"}},
 			{"name": "tags", "label": "Tags (comma-separated)", "type": "string"},
+			{"name": "importance", "label": "Importance (1-5)", "type": "string", "hint": "3"},
 		},
 	}
 }
 
 // RecallTool allows manual memory search
 type RecallTool struct {
-	store *db.Store
+	index MemoryIndex
 }
 
-func NewRecallTool(store *db.Store) *RecallTool {
-	return &RecallTool{store: store}
+func NewRecallTool(index MemoryIndex) *RecallTool {
+	return &RecallTool{index: index}
 }
 
 func (r *RecallTool) Name() string {
@@ -76,11 +93,11 @@ func (r *RecallTool) Name() string {
 }
 
 func (r *RecallTool) Description() string {
-	return "Searches long-term memory. Input: search query string."
+	return "Searches long-term memory for the memories most related in meaning to the query. Input: search query string."
 }
 
 func (r *RecallTool) Execute(ctx context.Context, input string) (string, error) {
-	memories, err := r.store.SearchMemories(input, 10)
+	memories, err := r.index.Recall(ctx, strings.TrimSpace(input), 10)
 	if err != nil {
 		return "", err
 	}
@@ -92,7 +109,7 @@ func (r *RecallTool) Execute(ctx context.Context, input string) (string, error)
 	var sb strings.Builder
 	sb.WriteString("Found Memories:\n")
 	for _, m := range memories {
-		sb.WriteString(fmt.Sprintf("- [%s] %s (Tags: %s)\n", m.Type, m.Content, m.Tags))
+		sb.WriteString(fmt.Sprintf("- [%s] %s (Tags: %s, Importance: %d)\n", m.Type, m.Content, m.Tags, m.Importance))
 	}
 	return sb.String(), nil
 }
@@ -0,0 +1,71 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type MeshRunner interface {
+	StartMesh(ctx context.Context, goal string) (string, error)
+	CancelMesh(id string) error
+}
+
+// MeshTool decomposes a goal into a planner project and works through it with
+// the main agent and sub-agents.
+type MeshTool struct {
+	runner MeshRunner
+}
+
+func NewMeshTool(r MeshRunner) *MeshTool {
+	return &MeshTool{runner: r}
+}
+
+func (m *MeshTool) Name() string {
+	return "mesh"
+}
+
+func (m *MeshTool) Description() string {
+	return `Runs a goal as an autonomous workflow: the goal is decomposed into a project with tasks in the planner, and the tasks are executed one after another by the main agent or an assigned sub-agent, each building on the earlier results. Runs in the background; progress is visible in the planner.
+Input: the goal as plain text, or {"goal": "..."}. To stop a running workflow: {"action": "cancel", "id": "project_id"}.`
+}
+
+func (m *MeshTool) Execute(ctx context.Context, input string) (string, error) {
+	goal := strings.TrimSpace(input)
+	var req struct {
+		Action string `json:"action"`
+		Goal   string `json:"goal"`
+		ID     string `json:"id"`
+	}
+	if strings.HasPrefix(goal, "{") && json.Unmarshal([]byte(goal), &req) == nil {
+		goal = req.Goal
+	}
+	if req.Action == "cancel" {
+		if req.ID == "" {
+			return "Error: 'id' is required for cancel action.", nil
+		}
+		if err := m.runner.CancelMesh(req.ID); err != nil {
+			return fmt.Sprintf("Error: %v", err), nil
+		}
+		return fmt.Sprintf("Mesh workflow %s is being cancelled.", req.ID), nil
+	}
+	if goal == "" {
+		return "Error: a goal is required, e.g. /mesh research the best NAS for home use and write a summary.", nil
+	}
+
+	id, err := m.runner.StartMesh(ctx, goal)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Mesh workflow started as project %s. Follow its progress in the planner; to stop it, call mesh with {\"action\": \"cancel\", \"id\": \"%s\"}.", id, id), nil
+}
+
+func (m *MeshTool) Schema() map[string]interface{} {
+	return map[string]interface{}{
+		"title": "Mesh Workflow",
+		"fields": []map[string]interface{}{
+			{"name": "goal", "label": "Goal", "type": "longtext", "required": true},
+		},
+	}
+}
@@ -4,17 +4,24 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/tools/base"
 )
 
+// AgentMessenger delivers messages between agents.
+type AgentMessenger interface {
+	Send(ctx context.Context, from, to, content string, inReplyTo int) (int, error)
+}
+
 type MessagingTool struct {
-	store *db.Store
+	messenger AgentMessenger
 }
 
-func NewMessagingTool(store *db.Store) *MessagingTool {
-	return &MessagingTool{store: store}
+func NewMessagingTool(messenger AgentMessenger) *MessagingTool {
+	return &MessagingTool{messenger: messenger}
 }
 
 func (m *MessagingTool) Name() string {
@@ -22,23 +29,33 @@ func (m *MessagingTool) Name() string {
 }
 
 func (m *MessagingTool) Description() string {
-	return `Sends a message to another agent. Input: {"to": "agent_name", "content": "..."}`
+	return `Sends a message to another agent, which is activated to handle it and replies to you. Input: {"to": "agent_name", "content": "...", "in_reply_to": 12 (optional, the message ID you answer)}`
 }
 
 func (m *MessagingTool) Execute(ctx context.Context, input string) (string, error) {
 	var req struct {
 		To      string `json:"to"`
 		Content string `json:"content"`
+		// A number from the agent, a string from the PWA form
+		InReplyTo json.RawMessage `json:"in_reply_to"`
 	}
 	if err := json.Unmarshal([]byte(input), &req); err != nil {
 		return "", err
 	}
+	inReplyTo := 0
+	if v := rawValue(req.InReplyTo); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return "", fmt.Errorf("in_reply_to must be a message ID, got %s", v)
+		}
+		inReplyTo = n
+	}
 
-	_, err := m.store.DB.Exec("INSERT INTO agent_messages (from_agent, to_agent, content) VALUES (?, ?, ?)", "main", req.To, req.Content)
+	id, err := m.messenger.Send(ctx, base.AgentName(ctx), req.To, req.Content, inReplyTo)
 	if err != nil {
 		return "", err
 	}
-	return "Message sent.", nil
+	return fmt.Sprintf("Message #%d sent to %s.", id, req.To), nil
 }
 
 func (m *MessagingTool) Schema() map[string]interface{} {
@@ -47,6 +64,7 @@ func (m *MessagingTool) Schema() map[string]interface{} {
 		"fields": []map[string]interface{}{
 			{"name": "to", "label": "Recipient", "type": "string", "required": true},
 			{"name": "content", "label": "Message", "type": "longtext", "required": true},
+			{"name": "in_reply_to", "label": "In Reply To (message ID)", "type": "string"},
 		},
 	}
 }
@@ -65,31 +83,31 @@ func (i *InboxTool) Name() string {
 }
 
 func (i *InboxTool) Description() string {
-	return "Checks messages for a specific agent. Input: agent_name"
+	return "Checks unread messages for an agent and marks them read. Input: agent_name (defaults to the calling agent)"
 }
 
 func (i *InboxTool) Execute(ctx context.Context, input string) (string, error) {
-	rows, err := i.store.DB.Query("SELECT from_agent, content, created_at FROM agent_messages WHERE to_agent = ? AND read = 0", input)
+	name := strings.TrimSpace(input)
+	if name == "" {
+		name = base.AgentName(ctx)
+	}
+	msgs, err := i.store.GetUnreadAgentMessages(name)
 	if err != nil {
 		return "", err
 	}
-	defer rows.Close()
-
-	var sb strings.Builder
-	count := 0
-	for rows.Next() {
-		var from, content, date string
-		rows.Scan(&from, &content, &date)
-		sb.WriteString(fmt.Sprintf("From %s (%s): %s\n", from, date, content))
-		count++
-	}
-	
-	if count == 0 {
+	if len(msgs) == 0 {
 		return "No new messages.", nil
 	}
 
-	// Mark as read
-	i.store.DB.Exec("UPDATE agent_messages SET read = 1 WHERE to_agent = ? AND read = 0", input)
+	var sb strings.Builder
+	for _, m := range msgs {
+		sb.WriteString(fmt.Sprintf("#%d from %s (%s)", m.ID, m.From, m.CreatedAt.Format("2006-01-02 15:04")))
+		if m.InReplyTo > 0 {
+			sb.WriteString(fmt.Sprintf(", in reply to #%d", m.InReplyTo))
+		}
+		sb.WriteString(fmt.Sprintf(": %s\n", m.Content))
+		i.store.MarkAgentMessageRead(m.ID)
+	}
 	return sb.String(), nil
 }
 
@@ -2,7 +2,6 @@ package tools
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -12,10 +11,36 @@ import (
 
 type OptimizeMemoryTool struct {
 	store  *db.Store
-	client Summarizer
+	client llm.Provider
 }
 
-func NewOptimizeMemoryTool(store *db.Store, client Summarizer) *OptimizeMemoryTool {
+// optimizationPlanSchema is the JSON Schema the model's plan must satisfy.
+var optimizationPlanSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"delete": map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"type": "integer"},
+		},
+		"merge": map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"ids": map[string]interface{}{
+						"type":  "array",
+						"items": map[string]interface{}{"type": "integer"},
+					},
+					"new_content": map[string]interface{}{"type": "string"},
+				},
+				"required": []string{"ids", "new_content"},
+			},
+		},
+	},
+	"required": []string{"delete", "merge"},
+}
+
+func NewOptimizeMemoryTool(store *db.Store, client llm.Provider) *OptimizeMemoryTool {
 	return &OptimizeMemoryTool{store: store, client: client}
 }
 
@@ -49,19 +74,6 @@ Return a JSON object with:
 Memories:
 %s`, content.String())
 
-	resp, err := o.client.GenerateResponse(ctx, []llm.Message{{Role: "user", Content: prompt}})
-	if err != nil {
-		return "", err
-	}
-
-	// Simple extraction of JSON if wrapped in markdown
-	jsonStr := resp
-	if start := strings.Index(resp, "{"); start != -1 {
-		if end := strings.LastIndex(resp, "}"); end != -1 {
-			jsonStr = resp[start : end+1]
-		}
-	}
-
 	var plan struct {
 		Delete []int `json:"delete"`
 		Merge  []struct {
@@ -70,7 +82,29 @@ Memories:
 		} `json:"merge"`
 	}
 
-	if err := json.Unmarshal([]byte(jsonStr), &plan); err != nil {
+	known := make(map[int]bool)
+	for _, m := range memories {
+		known[m.ID] = true
+	}
+	resp, err := llm.GenerateStructured(ctx, o.client, []llm.Message{{Role: "user", Content: prompt}}, llm.Structured{
+		Schema: optimizationPlanSchema,
+		Validate: func() error {
+			for _, id := range plan.Delete {
+				if !known[id] {
+					return fmt.Errorf("delete refers to unknown memory ID %d", id)
+				}
+			}
+			for _, m := range plan.Merge {
+				for _, id := range m.IDs {
+					if !known[id] {
+						return fmt.Errorf("merge refers to unknown memory ID %d", id)
+					}
+				}
+			}
+			return nil
+		},
+	}, &plan)
+	if err != nil {
 		return fmt.Sprintf("Failed to parse optimization plan: %v\nRaw: %s", err, resp), nil
 	}
 
@@ -92,7 +126,8 @@ Memories:
 		for _, id := range m.IDs {
 			o.store.DB.Exec("DELETE FROM memories WHERE id = ?", id)
 		}
-		o.store.SaveMemory(m.NewContent, "fact", "merged")
+		// Embedded on the next recall
+		o.store.SaveMemory(db.Memory{Content: m.NewContent, Type: "fact", Tags: "merged"})
 		mergedCount++
 	}
 
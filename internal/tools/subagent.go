@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"strings"
 	"github.com/pyromancer/idony/internal/db"
+	"github.com/pyromancer/idony/internal/llm"
+	"github.com/pyromancer/idony/internal/tools/base"
 )
 
 type SubAgentSpawnManager interface {
@@ -13,22 +15,19 @@ type SubAgentSpawnManager interface {
 	SpawnNamed(ctx context.Context, agentName, prompt string, images []string) (string, error)
 	List() ([]db.SubAgentTask, error)
 	ListDefinitions() ([]db.SubAgentDefinition, error)
-	DefineAgent(name, personality, tools, model string) error
+	DefineAgent(def db.SubAgentDefinition) error
 	GetAvailableTools() []string
-}
-
-type ContextImagesProvider interface {
-	GetLastUserImages() []string
+	Cancel(id string) error
+	Continue(ctx context.Context, id, prompt string, images []string) error
 }
 
 // SubAgentTool allows Idony to spawn background tasks.
 type SubAgentTool struct {
 	manager SubAgentSpawnManager
-	context ContextImagesProvider
 }
 
-func NewSubAgentTool(m SubAgentSpawnManager, c ContextImagesProvider) *SubAgentTool {
-	return &SubAgentTool{manager: m, context: c}
+func NewSubAgentTool(m SubAgentSpawnManager) *SubAgentTool {
+	return &SubAgentTool{manager: m}
 }
 
 func (s *SubAgentTool) Name() string {
@@ -42,10 +41,13 @@ Actions:
 - "spawn_named": Starts a task using a pre-defined agent's personality and tools.
 - "list": Shows all tasks and their IDs.
 - "result": Retrieves the final output of a completed task (requires "id").
-- "define": Creates a new specialized agent definition.
+- "cancel": Stops a running task, council session or swarm (requires "id").
+- "continue": Sends a follow-up "prompt" to a sub-agent (requires "id"). It answers with its earlier conversation as context; fetch the answer with "result".
+- "define": Creates a new specialized agent definition. Optional "timeout" (e.g. "30m") overrides the default run timeout, and "options" (e.g. {"temperature": 0.2, "num_ctx": 8192}) sets its model parameters. "fallbacks" (e.g. ["llama3.1:8b", "llama3.1:8b@http://gpu2:11434"]) lists models to try in order when its model fails.
 - "list_definitions": Lists all available specialized agents.
-Input MUST be a JSON object: {"action": "spawn|spawn_named|list|result|define", "prompt": "...", "images": ["base64..."], "id": "task_id", "name": "agent_name"}.
-If "action" is omitted, "spawn" is assumed. If "images" is omitted, current context images are used.`
+Input MUST be a JSON object: {"action": "spawn|spawn_named|list|result|cancel|continue|define", "prompt": "...", "images": ["base64..."], "id": "task_id", "name": "agent_name"}.
+If "action" is omitted, "spawn" is assumed. If "images" is omitted, current context images are used.
+"options" given to spawn or spawn_named apply to that task only, over the agent's own.`
 }
 
 func (s *SubAgentTool) Execute(ctx context.Context, input string) (string, error) {
@@ -58,6 +60,11 @@ func (s *SubAgentTool) Execute(ctx context.Context, input string) (string, error
 		Personality string   `json:"personality"`
 		Tools       string   `json:"tools"`
 		Model       string   `json:"model"`
+		Timeout     string   `json:"timeout"`
+		// Options is a JSON object, or a string holding one when sent from the PWA form
+		Options json.RawMessage `json:"options,omitempty"`
+		// Fallbacks is a list of models, or a comma-separated string of them
+		Fallbacks json.RawMessage `json:"fallbacks,omitempty"`
 	}
 
 	if err := json.Unmarshal([]byte(input), &req); err != nil {
@@ -73,9 +80,28 @@ func (s *SubAgentTool) Execute(ctx context.Context, input string) (string, error
 		req.Action = "spawn"
 	}
 
-	// Fallback to context images if none provided in request
-	if len(req.Images) == 0 && s.context != nil {
-		req.Images = s.context.GetLastUserImages()
+	options := rawValue(req.Options)
+	opts, err := llm.ParseOptions(options)
+	if err != nil {
+		return fmt.Sprintf("Error: invalid options: %v", err), nil
+	}
+	fallbacks, err := parseList(req.Fallbacks, ",")
+	if err != nil {
+		return fmt.Sprintf("Error: invalid fallbacks: %v", err), nil
+	}
+	def := db.SubAgentDefinition{
+		Name:        req.Name,
+		Personality: req.Personality,
+		Tools:       req.Tools,
+		Model:       req.Model,
+		Timeout:     req.Timeout,
+		Options:     options,
+		Fallbacks:   strings.Join(fallbacks, ","),
+	}
+
+	// Fallback to the images of the current request if none provided
+	if len(req.Images) == 0 {
+		req.Images = base.Images(ctx)
 	}
 
 	switch req.Action {
@@ -85,7 +111,7 @@ func (s *SubAgentTool) Execute(ctx context.Context, input string) (string, error
 	case "spawn":
 		// If name and personality are provided, it's a "define and spawn" request
 		if req.Name != "" && req.Personality != "" {
-			err := s.manager.DefineAgent(req.Name, req.Personality, req.Tools, req.Model)
+			err := s.manager.DefineAgent(def)
 			if err != nil {
 				return fmt.Sprintf("Error defining agent during spawn: %v", err), nil
 			}
@@ -102,7 +128,7 @@ func (s *SubAgentTool) Execute(ctx context.Context, input string) (string, error
 		if req.Prompt == "" {
 			return "Error: 'prompt' is required for spawn action.", nil
 		}
-		id, err := s.manager.Spawn(ctx, req.Prompt, req.Images)
+		id, err := s.manager.Spawn(llm.WithCallOptions(ctx, opts), req.Prompt, req.Images)
 		if err != nil {
 			return "", err
 		}
@@ -113,17 +139,19 @@ func (s *SubAgentTool) Execute(ctx context.Context, input string) (string, error
 		}
 		// If personality is provided, it's a "define and spawn" request
 		if req.Personality != "" {
-			err := s.manager.DefineAgent(req.Name, req.Personality, req.Tools, req.Model)
+			err := s.manager.DefineAgent(def)
 			if err != nil {
 				return fmt.Sprintf("Error defining agent during spawn: %v", err), nil
 			}
+			// The options now belong to the definition
+			opts = llm.Options{}
 		}
 		
 		if req.Prompt == "" {
 			return fmt.Sprintf("Agent '%s' defined/verified. What should I task it with?", req.Name), nil
 		}
 
-		id, err := s.manager.SpawnNamed(ctx, req.Name, req.Prompt, req.Images)
+		id, err := s.manager.SpawnNamed(llm.WithCallOptions(ctx, opts), req.Name, req.Prompt, req.Images)
 		if err != nil {
 			return "", err
 		}
@@ -132,7 +160,7 @@ func (s *SubAgentTool) Execute(ctx context.Context, input string) (string, error
 		if req.Name == "" || req.Personality == "" {
 			return "Error: Both 'name' and 'personality' are required to define an agent.", nil
 		}
-		err := s.manager.DefineAgent(req.Name, req.Personality, req.Tools, req.Model)
+		err := s.manager.DefineAgent(def)
 		if err != nil {
 			return "", err
 		}
@@ -157,7 +185,7 @@ func (s *SubAgentTool) Execute(ctx context.Context, input string) (string, error
 		}
 		var res string
 		for _, d := range defs {
-			res += fmt.Sprintf("- %s: %s (Tools: %s, Model: %s)\n", d.Name, d.Personality, d.Tools, d.Model)
+			res += fmt.Sprintf("- %s: %s (Tools: %s, Model: %s, Fallbacks: %s, Timeout: %s, Options: %s)\n", d.Name, d.Personality, d.Tools, d.Model, d.Fallbacks, d.Timeout, d.Options)
 		}
 		if res == "" {
 			return "No specialized sub-agents defined yet.", nil
@@ -174,6 +202,9 @@ func (s *SubAgentTool) Execute(ctx context.Context, input string) (string, error
 		}
 		for _, t := range tasks {
 			if t.ID == req.ID {
+				if t.Status == "queued" {
+					return fmt.Sprintf("Sub-agent %s is queued, waiting for a free worker. Please wait.", req.ID), nil
+				}
 				if t.Status == "running" {
 					return fmt.Sprintf("Sub-agent %s is still running. Progress: %d%%. Please wait.", req.ID, t.Progress), nil
 				}
@@ -181,6 +212,22 @@ func (s *SubAgentTool) Execute(ctx context.Context, input string) (string, error
 			}
 		}
 		return fmt.Sprintf("Error: Sub-agent with ID %s not found.", req.ID), nil
+	case "cancel":
+		if req.ID == "" {
+			return "Error: 'id' is required for cancel action. Use /subagent list to find the ID.", nil
+		}
+		if err := s.manager.Cancel(req.ID); err != nil {
+			return fmt.Sprintf("Error: %v", err), nil
+		}
+		return fmt.Sprintf("Sub-agent %s is being cancelled.", req.ID), nil
+	case "continue":
+		if req.ID == "" || req.Prompt == "" {
+			return "Error: 'id' and 'prompt' are required for continue action.", nil
+		}
+		if err := s.manager.Continue(ctx, req.ID, req.Prompt, req.Images); err != nil {
+			return fmt.Sprintf("Error: %v", err), nil
+		}
+		return fmt.Sprintf("Sent follow-up to sub-agent %s. Check its result with the result action once it is done.", req.ID), nil
 	default:
 		return "", fmt.Errorf("invalid action: %s", req.Action)
 	}
@@ -214,6 +261,9 @@ func (s *SubAgentTool) Schema() map[string]interface{} {
 					{"name": "personality", "label": "Personality", "type": "longtext", "required": true},
 					{"name": "tools", "label": "Tools (comma-separated)", "type": "string", "hint": "time,email,shell"},
 					{"name": "model", "label": "Model Override", "type": "string", "hint": "llama3.1"},
+					{"name": "timeout", "label": "Run Timeout", "type": "string", "hint": "30m"},
+					{"name": "fallbacks", "label": "Fallback Models (comma-separated)", "type": "string", "hint": "llama3.1:8b,llama3.1:8b@http://gpu2:11434"},
+					{"name": "options", "label": "Model Options (JSON)", "type": "string", "hint": "{\"temperature\": 0.2, \"num_ctx\": 8192}"},
 				},
 			},
 			{
@@ -223,6 +273,21 @@ func (s *SubAgentTool) Schema() map[string]interface{} {
 					{"name": "id", "label": "Task ID", "type": "string", "required": true},
 				},
 			},
+			{
+				"name":  "continue",
+				"label": "Send Follow-up",
+				"fields": []map[string]interface{}{
+					{"name": "id", "label": "Task ID", "type": "string", "required": true},
+					{"name": "prompt", "label": "Message", "type": "longtext", "required": true},
+				},
+			},
+			{
+				"name":  "cancel",
+				"label": "Cancel Task",
+				"fields": []map[string]interface{}{
+					{"name": "id", "label": "Task ID", "type": "string", "required": true},
+				},
+			},
 			{
 				"name":  "list",
 				"label": "List All Tasks",
@@ -0,0 +1,155 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pyromancer/idony/internal/db"
+)
+
+type SwarmManager interface {
+	DefineSwarm(name string, members map[string]string) error
+	RunSwarm(ctx context.Context, swarmName, goal string) (string, error)
+	ListSwarms() ([]db.Swarm, error)
+	DeleteSwarm(name string) error
+}
+
+// SwarmTool manages swarms: teams of sub-agents with roles that work on a goal together.
+type SwarmTool struct {
+	manager SwarmManager
+}
+
+func NewSwarmTool(m SwarmManager) *SwarmTool {
+	return &SwarmTool{manager: m}
+}
+
+func (s *SwarmTool) Name() string {
+	return "swarm"
+}
+
+func (s *SwarmTool) Description() string {
+	return `Manages agent swarms: teams of defined sub-agents with roles (e.g. coder, reviewer, tester). When a swarm runs, a coordinator splits the goal into sub-tasks per role, independent sub-tasks run in parallel, and work with a reviewer is revised until approved. Runs in the background as a sub-agent task. Input must be a JSON object:
+{"action": "define|run|list|delete", "name": "swarm_name", "members": {"coder": "agent1", "reviewer": "agent2"}, "goal": "what the swarm should achieve"}`
+}
+
+func (s *SwarmTool) Execute(ctx context.Context, input string) (string, error) {
+	var req struct {
+		Action  string          `json:"action"`
+		Name    string          `json:"name"`
+		Members json.RawMessage `json:"members"`
+		Goal    string          `json:"goal"`
+	}
+
+	if err := json.Unmarshal([]byte(input), &req); err != nil {
+		return "", fmt.Errorf("invalid input format: %w", err)
+	}
+
+	switch req.Action {
+	case "define":
+		members, err := parseSwarmMembers(req.Members)
+		if err != nil {
+			return "", err
+		}
+		if req.Name == "" || len(members) == 0 {
+			return "", fmt.Errorf("name and members are required for define")
+		}
+		if err := s.manager.DefineSwarm(req.Name, members); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Successfully defined swarm: %s", req.Name), nil
+	case "run":
+		if req.Name == "" || req.Goal == "" {
+			return "", fmt.Errorf("name and goal are required for run")
+		}
+		id, err := s.manager.RunSwarm(ctx, req.Name, req.Goal)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Started swarm '%s' with ID: %s. Cancel it with the subagent tool's cancel action.", req.Name, id), nil
+	case "list":
+		swarms, err := s.manager.ListSwarms()
+		if err != nil {
+			return "", err
+		}
+		var res string
+		for _, sw := range swarms {
+			res += fmt.Sprintf("- %s: Roles (%s)\n", sw.Name, sw.Members)
+		}
+		if res == "" {
+			return "No swarms defined yet.", nil
+		}
+		return res, nil
+	case "delete":
+		if req.Name == "" {
+			return "", fmt.Errorf("name is required for delete")
+		}
+		if err := s.manager.DeleteSwarm(req.Name); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Deleted swarm: %s", req.Name), nil
+	default:
+		return "", fmt.Errorf("invalid action: %s", req.Action)
+	}
+}
+
+// parseSwarmMembers accepts the members as a {"role": "agent"} object or, as
+// sent by the forms, a "role=agent,role=agent" string.
+func parseSwarmMembers(raw json.RawMessage) (map[string]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	members := make(map[string]string)
+	if err := json.Unmarshal(raw, &members); err == nil {
+		return members, nil
+	}
+	var spec string
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return nil, fmt.Errorf("members must be an object of role to agent")
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		role, agent, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid member %q, expected role=agent", pair)
+		}
+		members[strings.TrimSpace(role)] = strings.TrimSpace(agent)
+	}
+	return members, nil
+}
+
+func (s *SwarmTool) Schema() map[string]interface{} {
+	return map[string]interface{}{
+		"title": "Agent Swarm",
+		"actions": []map[string]interface{}{
+			{
+				"name":  "run",
+				"label": "Run Swarm",
+				"fields": []map[string]interface{}{
+					{"name": "name", "label": "Swarm Name", "type": "string", "required": true},
+					{"name": "goal", "label": "Goal", "type": "longtext", "required": true},
+				},
+			},
+			{
+				"name":  "define",
+				"label": "Define Swarm",
+				"fields": []map[string]interface{}{
+					{"name": "name", "label": "Name", "type": "string", "required": true},
+					{"name": "members", "label": "Members (role=agent, comma-separated)", "type": "string", "hint": "coder=dev,reviewer=critic"},
+				},
+			},
+			{
+				"name":   "list",
+				"label":  "List Swarms",
+				"fields": []map[string]interface{}{},
+			},
+			{
+				"name":  "delete",
+				"label": "Delete Swarm",
+				"fields": []map[string]interface{}{
+					{"name": "name", "label": "Name", "type": "string", "required": true},
+				},
+			},
+		},
+	}
+}
@@ -2,6 +2,7 @@ package tools
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -102,20 +103,28 @@ type WriteFileTool struct{}
 
 func (t *WriteFileTool) Name() string { return "write_file" }
 func (t *WriteFileTool) Description() string {
-	return "Writes content to a file. Input format: 'path|content'."
+	return "Writes content to a file. Input format: 'path|content' or JSON {\"path\": \"...\", \"content\": \"...\"}."
 }
 func (t *WriteFileTool) Execute(ctx context.Context, input string) (string, error) {
-	parts := strings.SplitN(input, "|", 2)
-	if len(parts) != 2 {
-		return "", fmt.Errorf("invalid format, use 'path|content'")
+	// Accept the JSON form sent by the PWA and native tool calls
+	var req struct {
+		Path    string `json:"path"`
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal([]byte(input), &req); err != nil || req.Path == "" {
+		parts := strings.SplitN(input, "|", 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("invalid format, use 'path|content'")
+		}
+		req.Path, req.Content = parts[0], parts[1]
 	}
 	
-	path, err := isAllowedPath(strings.TrimSpace(parts[0]))
+	path, err := isAllowedPath(strings.TrimSpace(req.Path))
 	if err != nil {
 		return "", err
 	}
 
-	content := parts[1]
+	content := req.Content
 	err = os.WriteFile(path, []byte(content), 0644)
 	if err != nil {
 		return "", err
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"syscall/js"
 	"time"
 )
@@ -32,6 +36,20 @@ var (
 	executeToolBtn    = document.Call("getElementById", "executeToolBtn")
 	backToToolboxBtn  = document.Call("getElementById", "backToToolboxBtn")
 
+	traceBtn            = document.Call("getElementById", "traceBtn")
+	traceModalEl        = document.Call("getElementById", "traceModal")
+	traceRunsEl         = document.Call("getElementById", "traceRuns")
+	traceStepsContainer = document.Call("getElementById", "traceStepsContainer")
+	traceStepsEl        = document.Call("getElementById", "traceSteps")
+	backToRunsBtn       = document.Call("getElementById", "backToRunsBtn")
+
+	approvalModalEl = document.Call("getElementById", "approvalModal")
+	approvalSession = document.Call("getElementById", "approvalSession")
+	approvalTool    = document.Call("getElementById", "approvalTool")
+	approvalInput   = document.Call("getElementById", "approvalInput")
+	approveBtn      = document.Call("getElementById", "approveBtn")
+	denyBtn         = document.Call("getElementById", "denyBtn")
+
 	historyPanel = document.Call("getElementById", "historyPanel")
 	agentsPanel  = document.Call("getElementById", "agentsPanel")
 	plannerPanel = document.Call("getElementById", "plannerPanel")
@@ -43,13 +61,72 @@ var (
 	currentApiKey = ""
 	cachedSchemas map[string]interface{}
 	selectedTool  string
+	sessionID     string // Conversation session of this tab
 	
 	isSending = false
+
+	// ID of the last notification shown; -1 until the first poll, which only
+	// skips the ones from before this tab was opened
+	lastNotification = -1
+
+	// Tool calls waiting for approval, shown one at a time
+	approvalMu      sync.Mutex
+	shownApprovals  = map[string]bool{}
+	approvalQueue   []approval
+	currentApproval *approval
 )
 
+// approval mirrors db.Approval as returned by /approvals.
+type approval struct {
+	ID        string
+	SessionID string
+	Tool      string
+	Input     string
+}
+
+// notification mirrors db.Notification as returned by /notifications.
+type notification struct {
+	ID      int
+	JobID   string
+	Kind    string
+	Status  string
+	Summary string
+}
+
+// runSummary mirrors db.RunSummary as returned by /runs/recent.
+type runSummary struct {
+	RunID     string
+	Input     string
+	Outcome   string
+	Steps     int
+	StartedAt time.Time
+}
+
+// runStep mirrors db.RunStep as returned by /runs/{id}/trace.
+type runStep struct {
+	Step      int
+	Kind      string
+	Model     string
+	Tool      string
+	Input     string
+	Output    string
+	Error     string
+	StartedAt time.Time
+	Duration  time.Duration
+}
+
 func main() {
 	c := make(chan struct{}, 0)
 
+	// Every tab keeps its own session; a reload continues it
+	storedSession := js.Global().Get("sessionStorage").Call("getItem", "idony_session")
+	if !storedSession.IsNull() && !storedSession.IsUndefined() && storedSession.String() != "" {
+		sessionID = storedSession.String()
+	} else {
+		sessionID = "pwa:" + js.Global().Get("crypto").Call("randomUUID").String()[:8]
+		js.Global().Get("sessionStorage").Call("setItem", "idony_session", sessionID)
+	}
+
 	// Check for stored key
 	storedKey := js.Global().Get("localStorage").Call("getItem", "idony_api_key")
 	if !storedKey.IsNull() && !storedKey.IsUndefined() && storedKey.String() != "" {
@@ -88,6 +165,27 @@ func main() {
 		return nil
 	}))
 
+	traceBtn.Set("onclick", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		go showRuns()
+		return nil
+	}))
+
+	backToRunsBtn.Set("onclick", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		traceStepsContainer.Get("style").Set("display", "none")
+		traceRunsEl.Get("style").Set("display", "block")
+		return nil
+	}))
+
+	approveBtn.Set("onclick", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		go decideApproval(true)
+		return nil
+	}))
+
+	denyBtn.Set("onclick", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		go decideApproval(false)
+		return nil
+	}))
+
 	sendBtn.Set("onclick", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
 		go sendMessage()
 		return nil
@@ -128,10 +226,83 @@ func startPolling() {
 			updateAgents()
 			updatePlanner()
 		}
+		// Approvals may come from any session, even while this tab is streaming
+		if currentApiKey != "" {
+			updateApprovals()
+			updateNotifications()
+		}
 		<-ticker.C
 	}
 }
 
+func updateApprovals() {
+	resp, err := apiGet("/approvals")
+	if err != nil { return }
+	var pending []approval
+	if err := json.Unmarshal(resp, &pending); err != nil { return }
+	for _, a := range pending {
+		queueApproval(a)
+	}
+}
+
+// updateNotifications shows the results of background jobs started from this tab's session.
+func updateNotifications() {
+	resp, err := apiGet(fmt.Sprintf("/notifications?session_id=%s&after=%d", url.QueryEscape(sessionID), max(lastNotification, 0)))
+	if err != nil { return }
+	var notes []notification
+	if err := json.Unmarshal(resp, &notes); err != nil { return }
+	for _, n := range notes {
+		if lastNotification >= 0 {
+			if n.Kind == "reply" {
+				appendMessage("assistant", n.Summary)
+			} else {
+				appendMessage("system", fmt.Sprintf("🔔 The %s %s is %s:\n%s", n.Kind, n.JobID, n.Status, n.Summary))
+			}
+		}
+		lastNotification = n.ID
+	}
+	if lastNotification < 0 { lastNotification = 0 }
+}
+
+func queueApproval(a approval) {
+	approvalMu.Lock()
+	defer approvalMu.Unlock()
+	if shownApprovals[a.ID] { return }
+	shownApprovals[a.ID] = true
+	approvalQueue = append(approvalQueue, a)
+	if currentApproval == nil { showNextApproval() }
+}
+
+// showNextApproval opens the dialog for the next queued approval. approvalMu must be held.
+func showNextApproval() {
+	if len(approvalQueue) == 0 { return }
+	a := approvalQueue[0]
+	approvalQueue = approvalQueue[1:]
+	currentApproval = &a
+
+	approvalSession.Set("innerText", "Session: "+a.SessionID)
+	approvalTool.Set("innerText", a.Tool)
+	approvalInput.Set("innerText", a.Input)
+	js.Global().Get("bootstrap").Get("Modal").Call("getOrCreateInstance", approvalModalEl).Call("show")
+}
+
+func decideApproval(approve bool) {
+	approvalMu.Lock()
+	a := currentApproval
+	currentApproval = nil
+	approvalMu.Unlock()
+	if a == nil { return }
+
+	js.Global().Get("bootstrap").Get("Modal").Call("getOrCreateInstance", approvalModalEl).Call("hide")
+	if _, err := apiPost("/approvals/"+a.ID, map[string]interface{}{"approve": approve, "by": "pwa"}); err != nil {
+		appendMessage("system", "⚠️ Approval failed: "+err.Error())
+	}
+
+	approvalMu.Lock()
+	if currentApproval == nil { showNextApproval() }
+	approvalMu.Unlock()
+}
+
 func updateHistory() {
 	resp, err := apiGet("/history")
 	if err != nil { return }
@@ -172,13 +343,41 @@ func updatePlanner() {
 
 	plannerPanel.Set("innerHTML", "")
 	for _, p := range projects {
+		id, _ := p["ID"].(string)
+		var tasks []map[string]interface{}
+		if resp, err := apiGet("/tasks?project_id=" + url.QueryEscape(id)); err == nil {
+			json.Unmarshal(resp, &tasks)
+		}
+		done := 0
+		for _, t := range tasks {
+			if t["Status"] == "completed" { done++ }
+		}
+
 		div := document.Call("createElement", "div")
 		div.Get("classList").Call("add", "sidebar-item")
-		div.Set("innerText", fmt.Sprintf("📁 %s (%s)", p["Name"], p["Status"]))
+		div.Set("innerText", fmt.Sprintf("📁 %s (%s, %d/%d)", p["Name"], p["Status"], done, len(tasks)))
 		plannerPanel.Call("appendChild", div)
+
+		// Tasks follow their project; subtasks are indented under their parent
+		for _, t := range tasks {
+			status, _ := t["Status"].(string)
+			icon, ok := taskIcons[status]
+			if !ok { icon = "○" }
+			indent := "24px"
+			if parent, _ := t["ParentID"].(string); parent != "" { indent = "40px" }
+			tdiv := document.Call("createElement", "div")
+			tdiv.Get("classList").Call("add", "sidebar-item", "small")
+			tdiv.Get("style").Set("paddingLeft", indent)
+			tdiv.Set("innerText", fmt.Sprintf("%s %s", icon, t["Title"]))
+			if result, _ := t["Result"].(string); result != "" { tdiv.Set("title", result) }
+			plannerPanel.Call("appendChild", tdiv)
+		}
 	}
 }
 
+// taskIcons marks the status of planner tasks.
+var taskIcons = map[string]string{"pending": "○", "in_progress": "⏳", "completed": "✅", "failed": "❌", "cancelled": "⛔", "skipped": "–"}
+
 func validateAndLogin(key string) {
 	loginError.Get("style").Set("display", "none")
 	
@@ -205,11 +404,28 @@ func validateAndLogin(key string) {
 	appContent.Get("style").Set("display", "flex")
 	appendMessage("assistant", "Identity verified. Secure link established.")
 	
+	go restoreSession()
 	go updateHistory()
 	go updateAgents()
 	go updatePlanner()
 }
 
+// restoreSession shows the messages this tab's session already has.
+func restoreSession() {
+	resp, err := apiGet("/sessions/" + url.PathEscape(sessionID) + "/messages")
+	if err != nil { return }
+	var msgs []map[string]interface{}
+	if err := json.Unmarshal(resp, &msgs); err != nil { return }
+
+	for _, m := range msgs {
+		role, _ := m["Role"].(string)
+		content, _ := m["Content"].(string)
+		if role == "user" || role == "assistant" {
+			appendMessage(role, content)
+		}
+	}
+}
+
 func showToolbox() {
 	resp, err := apiGet("/ui/schemas")
 	if err != nil { return }
@@ -279,11 +495,73 @@ func executeSelectedTool() {
 	sendMessage()
 }
 
-func appendMessage(role, text string) {
+// showRuns opens the trace viewer with the recent runs of this tab's session.
+func showRuns() {
+	resp, err := apiGet("/runs/recent?session_id=" + url.QueryEscape(sessionID))
+	if err != nil { return }
+	var runs []runSummary
+	if err := json.Unmarshal(resp, &runs); err != nil { return }
+
+	traceRunsEl.Set("innerHTML", "")
+	traceRunsEl.Get("style").Set("display", "block")
+	traceStepsContainer.Get("style").Set("display", "none")
+	if len(runs) == 0 {
+		traceRunsEl.Set("innerText", "No runs recorded in this session yet.")
+	}
+	for _, run := range runs {
+		div := document.Call("createElement", "div")
+		div.Get("classList").Call("add", "sidebar-item")
+		div.Set("innerText", fmt.Sprintf("%s · %s · %d steps\n%s", run.StartedAt.Local().Format("Jan 2 15:04"), run.Outcome, run.Steps, run.Input))
+		div.Set("onclick", js.FuncOf(func(id string) func(js.Value, []js.Value) interface{} {
+			return func(this js.Value, args []js.Value) interface{} { go showTrace(id); return nil }
+		}(run.RunID)))
+		traceRunsEl.Call("appendChild", div)
+	}
+	js.Global().Get("bootstrap").Get("Modal").Call("getOrCreateInstance", traceModalEl).Call("show")
+}
+
+// showTrace lists the recorded steps of a run in the trace viewer.
+func showTrace(id string) {
+	resp, err := apiGet("/runs/" + url.PathEscape(id) + "/trace")
+	if err != nil { return }
+	var steps []runStep
+	if err := json.Unmarshal(resp, &steps); err != nil { return }
+
+	traceStepsEl.Set("innerHTML", "")
+	for _, st := range steps {
+		div := document.Call("createElement", "div")
+		div.Get("classList").Call("add", "trace-step")
+		if st.Error != "" { div.Get("classList").Call("add", "error") }
+
+		title := fmt.Sprintf("#%d %s", st.Step, st.Kind)
+		if st.Tool != "" { title += " " + st.Tool }
+		if st.Kind == "llm" && st.Model != "" { title += " (" + st.Model + ")" }
+		header := document.Call("createElement", "div")
+		header.Set("innerText", fmt.Sprintf("%s · %s · %s", title, st.StartedAt.Local().Format("15:04:05"), st.Duration.Round(time.Millisecond)))
+		div.Call("appendChild", header)
+
+		for _, part := range []struct{ label, text string }{{"in", st.Input}, {"out", st.Output}, {"error", st.Error}} {
+			if part.text == "" { continue }
+			pre := document.Call("createElement", "pre")
+			pre.Set("innerText", part.label+": "+part.text)
+			div.Call("appendChild", pre)
+		}
+		traceStepsEl.Call("appendChild", div)
+	}
+	traceRunsEl.Get("style").Set("display", "none")
+	traceStepsContainer.Get("style").Set("display", "block")
+}
+
+func appendMessage(role, text string) js.Value {
 	div := document.Call("createElement", "div")
 	div.Get("classList").Call("add", "message", role)
 	div.Set("innerText", text)
 	chat.Call("appendChild", div)
+	scrollChat()
+	return div
+}
+
+func scrollChat() {
 	js.Global().Call("setTimeout", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
 		chat.Set("scrollTop", chat.Get("scrollHeight"))
 		return nil
@@ -302,22 +580,93 @@ func sendMessage() {
 	loader := document.Call("getElementById", "loader")
 	loader.Get("style").Set("display", "block")
 	
-	resp, err := apiPost("/chat", map[string]interface{}{"text": text})
-	
+	var reply js.Value
+	var streamed string
+	err := apiStream("/chat/stream", map[string]interface{}{"text": text, "session_id": sessionID}, func(ev streamEvent) {
+		switch ev.Type {
+		case "thought":
+			appendMessage("system", "💭 "+ev.Content)
+		case "tool_call":
+			appendMessage("system", fmt.Sprintf("🔧 %s %s", ev.Tool, ev.Input))
+		case "context":
+			appendMessage("system", "✂️ Context trimmed: "+ev.Content)
+		case "approval":
+			appendMessage("system", fmt.Sprintf("🛡️ Waiting for approval: %s %s", ev.Tool, ev.Input))
+			go queueApproval(approval{ID: ev.ID, SessionID: sessionID, Tool: ev.Tool, Input: ev.Input})
+		case "token":
+			loader.Get("style").Set("display", "none")
+			if reply.IsUndefined() {
+				reply = appendMessage("assistant", "")
+			}
+			streamed += ev.Content
+			reply.Set("innerText", streamed)
+			scrollChat()
+		case "reset":
+			// The streamed text was not the answer after all
+			if !reply.IsUndefined() {
+				reply.Call("remove")
+				reply = js.Undefined()
+			}
+			streamed = ""
+		case "final":
+			if reply.IsUndefined() {
+				reply = appendMessage("assistant", ev.Content)
+			} else {
+				reply.Set("innerText", ev.Content)
+			}
+		case "aborted", "cancelled":
+			appendMessage("assistant", ev.Content)
+		case "error":
+			appendMessage("assistant", "Agent Error: "+ev.Content)
+		}
+	})
+
 	loader.Get("style").Set("display", "none")
 	isSending = false
 
 	if err != nil {
 		appendMessage("assistant", "Terminal Error: "+err.Error())
-		return
 	}
-	
-	var data map[string]string
-	if err := json.Unmarshal(resp, &data); err != nil {
-		appendMessage("assistant", "Malformed Response: "+string(resp))
-		return
+}
+
+// streamEvent mirrors agent.Event as sent by the server's /chat/stream endpoint.
+type streamEvent struct {
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	Tool    string `json:"tool"`
+	Input   string `json:"input"`
+	ID      string `json:"id"`
+}
+
+// apiStream posts body to path and calls onEvent for every Server-Sent Event received.
+func apiStream(path string, body interface{}, onEvent func(streamEvent)) error {
+	jsonBody, _ := json.Marshal(body)
+	req, err := http.NewRequest("POST", path, bytes.NewBuffer(jsonBody))
+	if err != nil { return err }
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("X-API-Key", currentApiKey)
+
+	client := &http.Client{} // No timeout: generations can take minutes
+	resp, err := client.Do(req)
+	if err != nil { return err }
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") { continue }
+		var ev streamEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil { continue }
+		onEvent(ev)
 	}
-	appendMessage("assistant", data["response"])
+	return scanner.Err()
 }
 
 func apiPost(path string, body interface{}) ([]byte, error) {